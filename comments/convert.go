@@ -2,29 +2,46 @@ package comments
 
 import (
 	"bufio"
+	"io"
 	"log"
-	"os"
 	"strings"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
 )
 
 const EOL = "\r\n"
 
 func Strip(argv []string) {
 	argc := len(argv)
-
 	if argc != 2 {
 		log.Fatalln("expected 2 command-line arguments")
 	}
 	inpath := argv[0]
 	outpath := argv[1]
 
-	outfile, createErr := os.Create(outpath)
+	infile, openErr := gcode.OpenInput(inpath)
+	if openErr != nil {
+		log.Fatalln(openErr)
+	}
+	outfile, createErr := gcode.CreateOutput(outpath)
 	if createErr != nil {
 		log.Fatalln(createErr)
 	}
-	writer := bufio.NewWriter(outfile)
+	if err := StripStream(infile, outfile); err != nil {
+		log.Fatalln(err)
+	}
+	if err := infile.Close(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := outfile.Close(); err != nil {
+		log.Fatalln(err)
+	}
+}
 
-	err := ReadByLine(inpath, func(line string, _ int) error {
+// StripStream copies G-code from input to output, removing all comments and blank lines.
+func StripStream(input io.Reader, output io.Writer) error {
+	writer := bufio.NewWriter(output)
+	err := ReadByLineFrom(input, func(line string, _ int) error {
 		if len(line) == 0 {
 			return nil
 		}
@@ -42,13 +59,7 @@ func Strip(argv []string) {
 		return err
 	})
 	if err != nil {
-		log.Fatalln(err)
-	}
-
-	if err = writer.Flush(); err != nil {
-		log.Fatalln(err)
-	}
-	if err = outfile.Close(); err != nil {
-		log.Fatalln(err)
+		return err
 	}
+	return writer.Flush()
 }
@@ -3,7 +3,8 @@ package comments
 import (
 	"bufio"
 	"io"
-	"os"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
 )
 
 type LineCallback func(line string, lineNumber int) error
@@ -11,7 +12,7 @@ type LineCallback func(line string, lineNumber int) error
 // if callback returns an error, reading will stop before EOF
 
 func ReadByLine(path string, callback LineCallback) (err error) {
-	infile, openErr := os.Open(path)
+	infile, openErr := gcode.OpenInput(path)
 	if openErr != nil {
 		err = openErr
 		return
@@ -21,7 +22,12 @@ func ReadByLine(path string, callback LineCallback) (err error) {
 			err = closeErr
 		}
 	}()
-	reader := bufio.NewReader(infile)
+	return ReadByLineFrom(infile, callback)
+}
+
+// ReadByLineFrom is equivalent to ReadByLine, but reads from any io.Reader.
+func ReadByLineFrom(input io.Reader, callback LineCallback) error {
+	reader := bufio.NewReader(input)
 	lineNumber := 0
 	for {
 		line, isPrefix, readErr := reader.ReadLine()
@@ -29,8 +35,7 @@ func ReadByLine(path string, callback LineCallback) (err error) {
 			break
 		}
 		if readErr != nil {
-			err = readErr
-			return
+			return readErr
 		}
 		if isPrefix {
 			var fragment []byte
@@ -40,16 +45,14 @@ func ReadByLine(path string, callback LineCallback) (err error) {
 					break
 				}
 				if readErr != nil {
-					err = readErr
-					return
+					return readErr
 				}
 				line = append(line, fragment...)
 			}
 		}
 		cbErr := callback(string(line), lineNumber)
 		if cbErr != nil {
-			err = cbErr
-			return
+			return cbErr
 		}
 		lineNumber++
 	}
@@ -3,10 +3,9 @@ package firstlayer
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"math"
-	"os"
-	"strings"
 
 	"mosaicmfg.com/ps-postprocess/gcode"
 )
@@ -17,18 +16,22 @@ func DetermineToolsUsedInTheFirstLayer(inPath string, firstLayerStyleSettingsPat
 	if err != nil {
 		return err, FirstLayer{}
 	}
+	return determineToolsUsedInTheFirstLayer(gcode.FileLineReader(inPath), firstLayerStyleSettings)
+}
 
+func determineToolsUsedInTheFirstLayer(readLines gcode.LineReader, firstLayerStyleSettings FirstLayerStyleSettings) (error, FirstLayer) {
 	// all the tools used in first layer
 	toolUsedInFirstLayer := make(map[int]bool)
-	const layerChangeComment = ";LAYER_CHANGE"
-	// layerChangeComment appears once before initial layer change
+	// a layer change annotation appears once before the initial layer
 	layer := -1
-	err = gcode.ReadByLine(inPath, func(line gcode.Command, _ int) error {
+	dialect := gcode.Dialect{}
+	err := readLines(func(line gcode.Command, _ int) error {
+		annotation := dialect.ParseAnnotation(line)
 		if layer > 1 {
 			return gcode.ErrEarlyExit
 		} else if isToolChange, tool := line.IsToolChange(); isToolChange {
 			toolUsedInFirstLayer[tool] = true
-		} else if strings.HasPrefix(line.Raw, layerChangeComment) {
+		} else if annotation.Kind == gcode.AnnotationLayerChange {
 			layer += 1
 		}
 		return nil
@@ -77,47 +80,73 @@ func UseFirstLayerSettings(argv []string) {
 		log.Fatalln("expected 3 command-line arguments")
 	}
 
-	const EOL = "\r\n"
-
 	inPath := argv[0]                      // unmodified G-code file
 	outPath := argv[1]                     // modified G-code file
 	firstLayerStyleSettingsPath := argv[2] // style settings that are affected by the first tool
 
-	// determine the tools used in the first layer
-	err, usedFirstLayerValues := DetermineToolsUsedInTheFirstLayer(inPath, firstLayerStyleSettingsPath)
+	firstLayerStyleSettings, err := LoadFirstLayerStylesFromFile(firstLayerStyleSettingsPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	readLines, err := gcode.OpenLineReader(inPath)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	// create out file
-	outfile, createErr := os.Create(outPath)
-	if createErr != nil {
+	outfile, err := gcode.CreateOutput(outPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	usedFirstLayerValues, err := UseFirstLayerSettingsStream(readLines, outfile, firstLayerStyleSettings)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err = outfile.Close(); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err = usedFirstLayerValues.Save(outPath + ".firstLayerResults"); err != nil {
 		log.Fatalln(err)
 	}
-	writer := bufio.NewWriter(outfile)
-	writeGCodeError := gcode.ReadByLine(inPath, func(line gcode.Command, linenNum int) error {
+}
+
+// UseFirstLayerSettingsStream writes the G-code read by readLines to output, with the
+// bed temperature and Z offset of the tools used in the first layer applied.
+// readLines is called twice, so it must be able to replay its input.
+func UseFirstLayerSettingsStream(readLines gcode.LineReader, output io.Writer, firstLayerStyleSettings FirstLayerStyleSettings) (FirstLayer, error) {
+	const EOL = "\r\n"
+
+	// determine the tools used in the first layer
+	err, usedFirstLayerValues := determineToolsUsedInTheFirstLayer(readLines, firstLayerStyleSettings)
+	if err != nil {
+		return FirstLayer{}, err
+	}
+
+	writer := bufio.NewWriter(output)
+	position := gcode.PositionTracker{}
+	err = readLines(func(line gcode.Command, linenNum int) error {
+		// moves in relative mode (G91) are already offset by the last absolute Z
+		relativeMove := position.RelativeXYZ && (line.IsLinearMove() || line.IsArcMove())
+		position.TrackInstruction(line)
+		// (SetParam clears line.Raw, so that line.String() will
+		// generate the modified line rather than using line.Raw)
 		if line.Command == "M140" {
-			if _, ok := line.Params["s"]; ok {
-				line.Params["s"] = usedFirstLayerValues.BedTemperature
-				// set line.Raw to an empty string so that later line.String()
-				// will generate the correct line rather then using line.raw
-				line.Raw = ""
+			if line.HasParam("s") {
+				line.SetParam("s", usedFirstLayerValues.BedTemperature)
 			}
 		} else if line.Command == "M190" {
-			if _, ok := line.Params["s"]; ok {
-				line.Params["s"] = usedFirstLayerValues.BedTemperature
-				line.Raw = ""
-			} else if _, ok = line.Params["r"]; ok {
-				line.Params["r"] = usedFirstLayerValues.BedTemperature
-				line.Raw = ""
+			if line.HasParam("s") {
+				line.SetParam("s", usedFirstLayerValues.BedTemperature)
+			} else if line.HasParam("r") {
+				line.SetParam("r", usedFirstLayerValues.BedTemperature)
 			}
-		} else if value, ok := line.Params["z"]; ok {
+		} else if value, ok := line.Param("z"); ok && !relativeMove {
 			// Check if the command is one of the specified commands
 			switch line.Command {
 			case "G0", "G1", "G2", "G3", "G92":
 				// z-offset
-				line.Params["z"] = value + usedFirstLayerValues.ZOffset
-				line.Raw = ""
+				line.SetParam("z", value+usedFirstLayerValues.ZOffset)
 			}
 		}
 		// write g-code to outPath file
@@ -126,18 +155,11 @@ func UseFirstLayerSettings(argv []string) {
 		}
 		return nil
 	})
-
-	if writeGCodeError != nil {
-		log.Fatalln(err)
+	if err != nil {
+		return FirstLayer{}, err
 	}
 	if err = writer.Flush(); err != nil {
-		log.Fatalln(err)
-	}
-	if err = outfile.Close(); err != nil {
-		log.Fatalln(err)
-	}
-
-	if err = usedFirstLayerValues.Save(outPath + ".firstLayerResults"); err != nil {
-		log.Fatalln(err)
+		return FirstLayer{}, err
 	}
+	return usedFirstLayerValues, nil
 }
@@ -2,9 +2,8 @@ package flashforge
 
 import (
 	"bufio"
-	"fmt"
+	"io"
 	"log"
-	"os"
 	"strconv"
 
 	"mosaicmfg.com/ps-postprocess/gcode"
@@ -13,13 +12,30 @@ import (
 const EOL = "\r\n"
 
 func convert(inpath, outpath string, printExtruder int) error {
-	outfile, createErr := os.Create(outpath)
+	infile, openErr := gcode.OpenInput(inpath)
+	if openErr != nil {
+		return openErr
+	}
+	defer infile.Close()
+	outfile, createErr := gcode.CreateOutput(outpath)
 	if createErr != nil {
 		return createErr
 	}
-	writer := bufio.NewWriter(outfile)
+	if err := ConvertCommandsStream(infile, outfile, printExtruder); err != nil {
+		outfile.Close()
+		return err
+	}
+	return outfile.Close()
+}
+
+// ConvertCommandsStream copies G-code from input to output, replacing
+// commands that FlashForge firmware does not support with its equivalents.
+func ConvertCommandsStream(input io.Reader, output io.Writer, printExtruder int) error {
+	writer := bufio.NewWriter(output)
 
-	err := gcode.ReadByLine(inpath, func(line gcode.Command, _ int) error {
+	flavor := gcode.FlashForge{}
+
+	err := gcode.ReadArgsByLineFrom(input, func(line gcode.Command, _ int) error {
 		// perform the following conversions:
 		//   - stabilize print temperature: M109 S<temp> T<ext> -> M6 T<ext>
 		//   - stabilize bed temperature: M190 S<temp> -> M7
@@ -27,18 +43,20 @@ func convert(inpath, outpath string, printExtruder int) error {
 		outputLine := line.Raw
 		if line.Command == "M109" {
 			tool := printExtruder
-			if t, ok := line.Params["t"]; ok {
+			if t, ok := line.Param("t"); ok {
 				tool = int(t)
 			}
-			outputLine = fmt.Sprintf("M6 T%d", tool)
+			temp, _ := line.Param("s")
+			outputLine = flavor.WaitForExtruder(tool, temp)
 		} else if line.Command == "M190" {
-			outputLine = "M7"
+			temp, _ := line.Param("s")
+			outputLine = flavor.WaitForBed(temp)
 		} else if len(line.Command) > 1 && line.Command[0] == 'T' {
 			tool, err := strconv.ParseInt(line.Command[1:], 10, 32)
 			if err != nil {
 				return err
 			}
-			outputLine = fmt.Sprintf("M108 T%d", tool)
+			outputLine = flavor.ToolChange(int(tool))
 		}
 		if _, err := writer.WriteString(outputLine + EOL); err != nil {
 			return err
@@ -50,13 +68,7 @@ func convert(inpath, outpath string, printExtruder int) error {
 		return err
 	}
 
-	if err := writer.Flush(); err != nil {
-		return err
-	}
-	if err := outfile.Close(); err != nil {
-		return err
-	}
-	return nil
+	return writer.Flush()
 }
 
 func ConvertCommands(argv []string) {
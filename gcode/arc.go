@@ -0,0 +1,249 @@
+package gcode
+
+import (
+	"errors"
+	"math"
+)
+
+// Plane is the working plane selected by G17/G18/G19, in which arc moves are interpreted.
+type Plane int
+
+const (
+	PlaneXY Plane = 0 // G17 (default)
+	PlaneZX Plane = 1 // G18
+	PlaneYZ Plane = 2 // G19
+)
+
+// DefaultArcTolerance is the maximum chord error (distance between an arc and
+// the straight segments approximating it) used when linearizing arcs, in mm.
+const DefaultArcTolerance = float32(0.01)
+
+// distances (mm) and angles (radians) smaller than this are treated as zero
+const arcEpsilon = 1e-5
+
+var ErrInvalidArc = errors.New("arc move requires either a center offset or a radius")
+
+// planeAxes returns the indices (into an [3]float32 XYZ position) of the two
+// axes spanning the plane, followed by the index of the linear (helical) axis.
+// Axis ordering follows the right-hand rule, so that "clockwise" has the same
+// meaning in each plane when viewed from the positive end of the linear axis.
+func (p Plane) planeAxes() (int, int, int) {
+	switch p {
+	case PlaneZX:
+		return 2, 0, 1
+	case PlaneYZ:
+		return 1, 2, 0
+	}
+	return 0, 1, 2
+}
+
+// centerOffsetParams returns the parameter names of the center offsets
+// corresponding to the two axes spanning the plane.
+func (p Plane) centerOffsetParams() (string, string) {
+	switch p {
+	case PlaneZX:
+		return "k", "i"
+	case PlaneYZ:
+		return "j", "k"
+	}
+	return "i", "j"
+}
+
+// Arc describes the path swept by a single G2/G3 move, including any helical
+// movement along the axis perpendicular to the working plane.
+type Arc struct {
+	Plane      Plane
+	Clockwise  bool
+	Start      [3]float32 // XYZ position at the start of the move
+	End        [3]float32 // XYZ position at the end of the move
+	Center     [3]float32 // XYZ position of the center (linear axis component equals Start's)
+	Radius     float32
+	StartAngle float64 // radians, measured in the working plane
+	Sweep      float64 // radians, negative for clockwise arcs
+}
+
+// NewArc builds the Arc swept by a G2/G3 command starting at the given XYZ position,
+// treating the command's X/Y/Z parameters as absolute coordinates. Axes missing
+// from the command keep their starting value. Both the center offset (I/J/K) and
+// radius (R) forms are supported.
+func NewArc(command Command, plane Plane, start [3]float32) (Arc, error) {
+	end := start
+	if x, ok := command.Param("x"); ok {
+		end[0] = x
+	}
+	if y, ok := command.Param("y"); ok {
+		end[1] = y
+	}
+	if z, ok := command.Param("z"); ok {
+		end[2] = z
+	}
+	return NewArcTo(command, plane, start, end)
+}
+
+// NewArcTo is equivalent to NewArc, but takes an already-resolved end position
+// instead of reading it from the command (e.g. for moves in relative mode).
+func NewArcTo(command Command, plane Plane, start, end [3]float32) (Arc, error) {
+	arc := Arc{
+		Plane:     plane,
+		Clockwise: command.Command == "G2",
+		Start:     start,
+		End:       end,
+	}
+
+	a, b, _ := plane.planeAxes()
+	startA, startB := float64(start[a]), float64(start[b])
+	endA, endB := float64(arc.End[a]), float64(arc.End[b])
+	var centerA, centerB float64
+
+	offsetParamA, offsetParamB := plane.centerOffsetParams()
+	offsetA, hasOffsetA := command.Param(offsetParamA)
+	offsetB, hasOffsetB := command.Param(offsetParamB)
+	r, hasR := command.Param("r")
+
+	fullCircle := false
+	if hasOffsetA || hasOffsetB {
+		centerA = startA + float64(offsetA)
+		centerB = startB + float64(offsetB)
+		// identical endpoints with a center offset describe a full circle
+		fullCircle = math.Abs(endA-startA) < arcEpsilon && math.Abs(endB-startB) < arcEpsilon
+	} else if hasR && r != 0 {
+		// find the center from the chord between the endpoints and the radius
+		chordA := endA - startA
+		chordB := endB - startB
+		chord := math.Sqrt(chordA*chordA + chordB*chordB)
+		if chord < arcEpsilon {
+			return arc, ErrInvalidArc
+		}
+		radius := math.Abs(float64(r))
+		// distance from the chord midpoint to the center
+		// (a radius too small to span the chord is treated as a semicircle)
+		h := 0.0
+		if halfChord := chord / 2; radius > halfChord {
+			h = math.Sqrt(radius*radius - halfChord*halfChord)
+		}
+		// positive R selects the shorter arc, negative R the longer one
+		if arc.Clockwise == (r > 0) {
+			h = -h
+		}
+		centerA = startA + chordA/2 - h*chordB/chord
+		centerB = startB + chordB/2 + h*chordA/chord
+	} else {
+		return arc, ErrInvalidArc
+	}
+
+	arc.Center = start
+	arc.Center[a] = float32(centerA)
+	arc.Center[b] = float32(centerB)
+	arc.Radius = float32(math.Hypot(startA-centerA, startB-centerB))
+	arc.StartAngle = math.Atan2(startB-centerB, startA-centerA)
+	endAngle := math.Atan2(endB-centerB, endA-centerA)
+
+	sweep := endAngle - arc.StartAngle
+	if arc.Clockwise {
+		for sweep >= 0 {
+			sweep -= 2 * math.Pi
+		}
+	} else {
+		for sweep <= 0 {
+			sweep += 2 * math.Pi
+		}
+	}
+	if fullCircle {
+		sweep = math.Copysign(2*math.Pi, sweep)
+	} else if math.Abs(math.Abs(sweep)-2*math.Pi) < arcEpsilon {
+		// angles that differ only by floating-point noise describe a zero-length arc
+		sweep = 0
+	}
+	arc.Sweep = sweep
+	return arc, nil
+}
+
+// PlanarLength returns the length of the arc projected onto the working plane.
+func (a Arc) PlanarLength() float32 {
+	return float32(math.Abs(a.Sweep) * float64(a.Radius))
+}
+
+// Length returns the true length of the path, including any helical movement.
+func (a Arc) Length() float32 {
+	_, _, linear := a.Plane.planeAxes()
+	planar := float64(a.PlanarLength())
+	helical := float64(a.End[linear] - a.Start[linear])
+	return float32(math.Sqrt(planar*planar + helical*helical))
+}
+
+// PointAt returns the XYZ position at the given fraction (0..1) along the arc.
+func (a Arc) PointAt(t float32) [3]float32 {
+	if t <= 0 {
+		return a.Start
+	}
+	if t >= 1 {
+		return a.End
+	}
+	return a.pointAtAngle(a.StartAngle+a.Sweep*float64(t), float64(t))
+}
+
+func (a Arc) pointAtAngle(angle, t float64) [3]float32 {
+	axisA, axisB, linear := a.Plane.planeAxes()
+	var point [3]float32
+	point[axisA] = a.Center[axisA] + float32(float64(a.Radius)*math.Cos(angle))
+	point[axisB] = a.Center[axisB] + float32(float64(a.Radius)*math.Sin(angle))
+	point[linear] = a.Start[linear] + float32(t*float64(a.End[linear]-a.Start[linear]))
+	return point
+}
+
+// Extents returns the bounding box of every point swept by the arc, which
+// includes any axis-aligned extremes passed between the two endpoints.
+func (a Arc) Extents() BoundingBox {
+	bbox := NewBoundingBox()
+	bbox.ExpandPoint(a.Start)
+	bbox.ExpandPoint(a.End)
+	if a.Sweep == 0 {
+		return bbox
+	}
+	// check each quadrant boundary (0, 90, 180, 270 degrees) crossed by the sweep
+	from, to := a.StartAngle, a.StartAngle+a.Sweep
+	if to < from {
+		from, to = to, from
+	}
+	firstQuadrant := math.Ceil(from / (math.Pi / 2))
+	for q := firstQuadrant; q*(math.Pi/2) <= to; q++ {
+		angle := q * (math.Pi / 2)
+		t := (angle - a.StartAngle) / a.Sweep
+		bbox.ExpandPoint(a.pointAtAngle(angle, t))
+	}
+	return bbox
+}
+
+// SegmentCount returns the number of straight segments needed to approximate
+// the arc without deviating from it by more than tolerance mm.
+func (a Arc) SegmentCount(tolerance float32) int {
+	if tolerance <= 0 {
+		tolerance = DefaultArcTolerance
+	}
+	radius := float64(a.Radius)
+	sweep := math.Abs(a.Sweep)
+	if sweep == 0 || radius == 0 {
+		return 1
+	}
+	if float64(tolerance) >= radius {
+		// tolerance is coarser than the arc itself -- use the fewest segments
+		// that still preserve the direction of travel
+		return int(math.Max(1, math.Ceil(sweep/(math.Pi/2))))
+	}
+	// chord error of a segment spanning angle theta is r * (1 - cos(theta / 2))
+	maxSegmentAngle := 2 * math.Acos(1-float64(tolerance)/radius)
+	return int(math.Max(1, math.Ceil(sweep/maxSegmentAngle)))
+}
+
+// Linearize approximates the arc by straight segments, returning the end point
+// of each segment (the start point is not included, and the last point is
+// always exactly End). A tolerance <= 0 uses DefaultArcTolerance.
+func (a Arc) Linearize(tolerance float32) [][3]float32 {
+	segments := a.SegmentCount(tolerance)
+	points := make([][3]float32, 0, segments)
+	for i := 1; i < segments; i++ {
+		t := float64(i) / float64(segments)
+		points = append(points, a.pointAtAngle(a.StartAngle+a.Sweep*t, t))
+	}
+	return append(points, a.End)
+}
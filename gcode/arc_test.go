@@ -0,0 +1,136 @@
+package gcode
+
+import (
+	"math"
+	"testing"
+)
+
+const arcTestEpsilon = 1e-3
+
+func expectClose(t *testing.T, name string, expected, actual float32) {
+	if math.Abs(float64(expected-actual)) > arcTestEpsilon {
+		t.Errorf("expected %s = %f, got %f", name, expected, actual)
+	}
+}
+
+func Test_ArcCenterOffsetQuarterCircle(t *testing.T) {
+	// counter-clockwise quarter circle from (10, 0) to (0, 10) around the origin
+	arc, err := NewArc(ParseLine("G3 X0 Y10 I-10 J0"), PlaneXY, [3]float32{10, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, "Radius", 10, arc.Radius)
+	expectClose(t, "Sweep", math.Pi/2, float32(arc.Sweep))
+	expectClose(t, "Length", 10*math.Pi/2, arc.Length())
+	extents := arc.Extents()
+	expectClose(t, "Min X", 0, extents.Min[0])
+	expectClose(t, "Max X", 10, extents.Max[0])
+	expectClose(t, "Min Y", 0, extents.Min[1])
+	expectClose(t, "Max Y", 10, extents.Max[1])
+}
+
+func Test_ArcClockwiseSweptExtents(t *testing.T) {
+	// clockwise half circle from (-10, 0) to (10, 0) passes through (0, 10)
+	arc, err := NewArc(ParseLine("G2 X10 Y0 I10 J0"), PlaneXY, [3]float32{-10, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, "Sweep", -math.Pi, float32(arc.Sweep))
+	extents := arc.Extents()
+	expectClose(t, "Max Y", 10, extents.Max[1])
+	expectClose(t, "Min Y", 0, extents.Min[1])
+}
+
+func Test_ArcFullCircle(t *testing.T) {
+	arc, err := NewArc(ParseLine("G2 I5 J0"), PlaneXY, [3]float32{0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, "Length", 10*math.Pi, arc.Length())
+	extents := arc.Extents()
+	expectClose(t, "Min X", 0, extents.Min[0])
+	expectClose(t, "Max X", 10, extents.Max[0])
+	expectClose(t, "Min Y", -5, extents.Min[1])
+	expectClose(t, "Max Y", 5, extents.Max[1])
+}
+
+func Test_ArcRadiusForm(t *testing.T) {
+	start := [3]float32{0, 0, 0}
+	// positive R selects the shorter arc
+	short, err := NewArc(ParseLine("G3 X10 Y10 R10"), PlaneXY, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, "short Sweep", math.Pi/2, float32(short.Sweep))
+	// negative R selects the longer arc
+	long, err := NewArc(ParseLine("G3 X10 Y10 R-10"), PlaneXY, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, "long Sweep", 3*math.Pi/2, float32(long.Sweep))
+	if _, err := NewArc(ParseLine("G3 X10 Y10"), PlaneXY, start); err == nil {
+		t.Error("expected an error for an arc without center or radius")
+	}
+}
+
+func Test_ArcHelicalOtherPlanes(t *testing.T) {
+	// G18: arc in the ZX plane, with helical movement along Y
+	arc, err := NewArc(ParseLine("G3 X0 Z10 Y4 I0 K10"), PlaneZX, [3]float32{0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, "Radius", 10, arc.Radius)
+	expectClose(t, "PlanarLength", 10*math.Pi, arc.PlanarLength())
+	planar := 10 * math.Pi
+	expectClose(t, "Length", float32(math.Sqrt(planar*planar+16)), arc.Length())
+	mid := arc.PointAt(0.5)
+	expectClose(t, "mid Y", 2, mid[1])
+}
+
+func Test_ArcLinearize(t *testing.T) {
+	arc, err := NewArc(ParseLine("G3 X-10 Y0 I-10 J0"), PlaneXY, [3]float32{10, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tolerance := float32(0.05)
+	points := arc.Linearize(tolerance)
+	if len(points) != arc.SegmentCount(tolerance) {
+		t.Fatalf("expected %d points, got %d", arc.SegmentCount(tolerance), len(points))
+	}
+	if points[len(points)-1] != arc.End {
+		t.Errorf("expected last point to be the arc end point")
+	}
+	// every chord midpoint must be within tolerance of the arc
+	previous := arc.Start
+	for _, point := range points {
+		midX := float64(previous[0]+point[0]) / 2
+		midY := float64(previous[1]+point[1]) / 2
+		chordError := float64(arc.Radius) - math.Hypot(midX, midY)
+		if chordError > float64(tolerance)+arcTestEpsilon {
+			t.Fatalf("chord error %f exceeds tolerance %f", chordError, tolerance)
+		}
+		previous = point
+	}
+}
+
+func Test_PositionTrackerArcs(t *testing.T) {
+	var pt PositionTracker
+	for _, command := range parseLines([]string{
+		"G1 X10 Y0 F600",
+		"G3 X0 Y10 I-10 J0",
+	}) {
+		pt.TrackInstruction(command)
+	}
+	expectClose(t, "CurrentX", 0, pt.CurrentX)
+	expectClose(t, "CurrentY", 10, pt.CurrentY)
+	if pt.LastArc == nil {
+		t.Fatal("expected LastArc to be set after an arc move")
+	}
+	expectClose(t, "LastMoveLength", 10*math.Pi/2, pt.LastMoveLength)
+
+	pt.TrackInstruction(ParseLine("G1 X0 Y0"))
+	if pt.LastArc != nil {
+		t.Error("expected LastArc to be cleared after a linear move")
+	}
+	expectClose(t, "LastMoveLength", 10, pt.LastMoveLength)
+}
@@ -0,0 +1,95 @@
+package gcode
+
+// Args stores the parameters (e.g. X10) and flags (e.g. the X in G28 X) of a
+// command in a fixed-size array indexed by letter, so that parsing a line
+// doesn't need to allocate. Keys are single letters, and are case-insensitive.
+type Args struct {
+	values [26]float32
+	params uint32 // bit i set == parameter for letter 'a'+i is present
+	flags  uint32 // bit i set == flag for letter 'a'+i is present
+}
+
+// argIndex returns the array index for the first letter of key, or -1 if it isn't a letter.
+func argIndex(key string) int {
+	if len(key) == 0 {
+		return -1
+	}
+	return letterIndex(key[0])
+}
+
+func letterIndex(letter byte) int {
+	if letter >= 'a' && letter <= 'z' {
+		return int(letter - 'a')
+	}
+	if letter >= 'A' && letter <= 'Z' {
+		return int(letter - 'A')
+	}
+	return -1
+}
+
+func (a *Args) Get(key string) (float32, bool) {
+	i := argIndex(key)
+	if i < 0 || a.params&(1<<i) == 0 {
+		return 0, false
+	}
+	return a.values[i], true
+}
+
+func (a *Args) Has(key string) bool {
+	i := argIndex(key)
+	return i >= 0 && a.params&(1<<i) != 0
+}
+
+func (a *Args) HasFlag(key string) bool {
+	i := argIndex(key)
+	return i >= 0 && a.flags&(1<<i) != 0
+}
+
+func (a *Args) Set(key string, value float32) {
+	if i := argIndex(key); i >= 0 {
+		a.values[i] = value
+		a.params |= 1 << i
+	}
+}
+
+func (a *Args) SetFlag(key string) {
+	if i := argIndex(key); i >= 0 {
+		a.flags |= 1 << i
+	}
+}
+
+// Delete removes both the parameter and the flag for key, if present.
+func (a *Args) Delete(key string) {
+	if i := argIndex(key); i >= 0 {
+		a.values[i] = 0
+		a.params &^= 1 << i
+		a.flags &^= 1 << i
+	}
+}
+
+// Empty returns true if there are no parameters or flags.
+func (a *Args) Empty() bool {
+	return a.params == 0 && a.flags == 0
+}
+
+// Params returns the parameters as a map, for compatibility with Command.Params.
+func (a *Args) Params() Params {
+	params := make(Params)
+	for i := 0; i < len(a.values); i++ {
+		if a.params&(1<<i) != 0 {
+			params[string(rune('a'+i))] = a.values[i]
+		}
+	}
+	return params
+}
+
+// Flags returns the flags as a map, for compatibility with Command.Flags.
+func (a *Args) Flags() Flags {
+	flags := make(Flags)
+	for i := 0; i < len(a.values); i++ {
+		if a.flags&(1<<i) != 0 {
+			flags[string(rune('a'+i))] = true
+		}
+	}
+	return flags
+}
@@ -52,6 +52,29 @@ func (b *BoundingBox) ExpandZ(z float32) {
 	}
 }
 
+func (b *BoundingBox) ExpandPoint(point [3]float32) {
+	b.ExpandX(point[0])
+	b.ExpandY(point[1])
+	b.ExpandZ(point[2])
+}
+
+// ExpandArc expands the box to contain every point swept by the arc,
+// not just its endpoints.
+func (b *BoundingBox) ExpandArc(arc Arc) {
+	extents := arc.Extents()
+	b.ExpandPoint(extents.Min)
+	b.ExpandPoint(extents.Max)
+}
+
+// ExpandArcXY is equivalent to ExpandArc, but leaves the Z extents unchanged.
+func (b *BoundingBox) ExpandArcXY(arc Arc) {
+	extents := arc.Extents()
+	b.ExpandX(extents.Min[0])
+	b.ExpandX(extents.Max[0])
+	b.ExpandY(extents.Min[1])
+	b.ExpandY(extents.Max[1])
+}
+
 func (b *BoundingBox) Serialize() string {
 	serializedMin := fmt.Sprintf("%.6e%s%.6e%s%.6e", b.Min[0], delimiterCol, b.Min[1], delimiterCol, b.Min[2])
 	serializedMax := fmt.Sprintf("%.6e%s%.6e%s%.6e", b.Max[0], delimiterCol, b.Max[1], delimiterCol, b.Max[2])
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"math"
 	"sort"
-	"strconv"
 	"strings"
 )
 
@@ -12,11 +11,14 @@ type Params map[string]float32
 type Flags map[string]bool
 
 type Command struct {
-	Raw     string
-	Command string
-	Comment string
-	Params  Params
-	Flags   Flags
+	Raw      string
+	Command  string
+	Comment  string
+	Text     string // free-text argument (e.g. the message of M117), which is never parsed as parameters
+	Params   Params // map view of the parameters, for compatibility (nil when parsed by ParseLineArgs)
+	Flags    Flags  // map view of the flags, for compatibility (nil when parsed by ParseLineArgs)
+	Args     Args
+	Extended ExtendedArgs // KEY=value arguments of an extended command (e.g. SET_FAN_SPEED FAN=aux)
 }
 
 func NewCommand(raw, command, comment string, params Params, flags Flags) Command {
@@ -29,6 +31,60 @@ func NewCommand(raw, command, comment string, params Params, flags Flags) Comman
 	}
 }
 
+// usesMaps returns true if the Params and Flags maps hold the command's arguments.
+// Maps take precedence over Args when present, as existing code may modify them.
+func (gcc Command) usesMaps() bool {
+	return gcc.Params != nil || gcc.Flags != nil
+}
+
+// Param returns the value of the parameter with the given (lowercase) letter.
+func (gcc Command) Param(key string) (float32, bool) {
+	if gcc.usesMaps() {
+		value, ok := gcc.Params[key]
+		return value, ok
+	}
+	return gcc.Args.Get(key)
+}
+
+func (gcc Command) HasParam(key string) bool {
+	_, ok := gcc.Param(key)
+	return ok
+}
+
+func (gcc Command) HasFlag(key string) bool {
+	if gcc.usesMaps() {
+		return gcc.Flags[key]
+	}
+	return gcc.Args.HasFlag(key)
+}
+
+// SetParam sets the value of a parameter, and clears Raw so that String() reflects the change.
+func (gcc *Command) SetParam(key string, value float32) {
+	if gcc.usesMaps() {
+		if gcc.Params == nil {
+			gcc.Params = Params{}
+		}
+		gcc.Params[key] = value
+	} else {
+		gcc.Args.Set(key, value)
+	}
+	gcc.Raw = ""
+}
+
+// IsExtended returns true for extended (Klipper-style) commands, whose arguments
+// are in Extended rather than Params, Flags or Args.
+func (gcc Command) IsExtended() bool {
+	return IsExtendedCommand(gcc.Command)
+}
+
+// HasArgs returns true if the command has any parameters or flags.
+func (gcc Command) HasArgs() bool {
+	if gcc.usesMaps() {
+		return len(gcc.Params) > 0 || len(gcc.Flags) > 0
+	}
+	return !gcc.Args.Empty()
+}
+
 func (gcc Command) IsLinearMove() bool {
 	// slight optimization: G1 is much more common, so check for that first
 	return gcc.Command == "G1" || gcc.Command == "G0"
@@ -38,6 +94,18 @@ func (gcc Command) IsArcMove() bool {
 	return gcc.Command == "G2" || gcc.Command == "G3"
 }
 
+func (gcc Command) IsSetPlane() (bool, Plane) {
+	switch gcc.Command {
+	case "G17":
+		return true, PlaneXY
+	case "G18":
+		return true, PlaneZX
+	case "G19":
+		return true, PlaneYZ
+	}
+	return false, PlaneXY
+}
+
 func (gcc Command) IsHome() bool {
 	return gcc.Command == "G28"
 }
@@ -48,45 +116,34 @@ func (gcc Command) IsSetExtrusionMode() (bool, bool) {
 	return isSetExtrusionMode, isModeRelative
 }
 
+func (gcc Command) IsSetPositioningMode() (bool, bool) {
+	isSetPositioningMode := gcc.Command == "G90" || gcc.Command == "G91"
+	isModeRelative := gcc.Command == "G91"
+	return isSetPositioningMode, isModeRelative
+}
+
 func (gcc Command) IsSetPosition() bool {
 	return gcc.Command == "G92"
 }
 
 func (gcc Command) IsFanCommand() bool {
-	return gcc.Command == "M106" || gcc.Command == "M107"
+	return gcc.Command == "M106" || gcc.Command == "M107" || gcc.Command == "SET_FAN_SPEED"
 }
 
 func (gcc Command) IsEnableFanCommand() bool {
+	if gcc.Command == "SET_FAN_SPEED" {
+		// Klipper (e.g. SET_FAN_SPEED FAN=aux SPEED=0.5)
+		speed, _ := gcc.Extended.Float("speed")
+		return speed > 0
+	}
 	return gcc.Command == "M106"
 }
 
+// IsToolChange returns true (and the tool index) if the command selects a tool
+// in any flavor understood by the trackers. Use a Flavor's IsToolChange where
+// the firmware is known.
 func (gcc Command) IsToolChange() (bool, int) {
-	if gcc.Command == "M135" {
-		// Makerbot/Sailfish (e.g. M135 T0)
-		if t, ok := gcc.Params["t"]; ok {
-			tool := int(t + 0.5)
-			return true, tool
-		} else {
-			return false, -1
-		}
-	}
-	if len(gcc.Command) > 1 && gcc.Command[0] == 'T' {
-		// RepRap (e.g. T0)
-		tool, err := strconv.ParseInt(gcc.Command[1:], 10, 32)
-		if err != nil {
-			return false, -1
-		}
-		return true, int(tool)
-	}
-	return false, -1
-}
-
-func (gcc Command) IsMoveToFirstLayerPoint() bool {
-	return gcc.Comment == "move to first layer point"
-}
-
-func (gcc Command) IsTravelToFirstLayerPoint() bool {
-	return gcc.Comment == "travel to first layer point"
+	return Generic{}.IsToolChange(gcc)
 }
 
 func FormatFloat(value float64) string {
@@ -126,7 +183,13 @@ func (gcc Command) String() string {
 	}
 
 	line := ""
-	if gcc.Command != "" {
+	if gcc.Command != "" && gcc.Text != "" {
+		line += gcc.Command + " " + gcc.Text
+	} else if gcc.Command != "" && gcc.IsExtended() {
+		line += gcc.Command + gcc.Extended.String()
+	} else if gcc.Command != "" && !gcc.usesMaps() {
+		line += gcc.Command + gcc.argsString()
+	} else if gcc.Command != "" {
 		line += gcc.Command
 		paramsAndFlags := make([]string, 0, len(gcc.Params)+len(gcc.Flags))
 
@@ -164,3 +227,20 @@ func (gcc Command) String() string {
 	}
 	return line
 }
+
+// argsOrder lists letters in the same order as String() sorts map parameters:
+// X, Y, Z, E, F, then alphabetical
+const argsOrder = "xyzefabcdghijklmnopqrstuvw"
+
+func (gcc Command) argsString() string {
+	var line strings.Builder
+	for i := 0; i < len(argsOrder); i++ {
+		key := argsOrder[i : i+1]
+		if value, ok := gcc.Args.Get(key); ok {
+			line.WriteString(" " + strings.ToUpper(key) + FormatFloat(float64(value)))
+		} else if gcc.Args.HasFlag(key) {
+			line.WriteString(" " + strings.ToUpper(key))
+		}
+	}
+	return line.String()
+}
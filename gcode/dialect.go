@@ -0,0 +1,351 @@
+package gcode
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AnnotationKind identifies a slicer annotation (a comment-only line that
+// describes the print), independent of the slicer's comment dialect.
+type AnnotationKind int
+
+const (
+	AnnotationNone         AnnotationKind = iota
+	AnnotationLayerChange                 // start of a layer (Value is its top Z, if the slicer includes it)
+	AnnotationLayerZ                      // top Z of the current layer
+	AnnotationLayerHeight                 // thickness of the current layer
+	AnnotationFeature                     // start of a feature (Feature is its PrusaSlicer name)
+	AnnotationWidth                       // extrusion width of the following moves
+	AnnotationWipeStart                   // start of a wipe move
+	AnnotationWipeEnd                     // end of a wipe move
+	AnnotationTimeEstimate                // Value is the estimated print time, in seconds
+
+	// moves, rather than comment-only lines
+	AnnotationMoveToFirstLayerPoint   // move to the first layer's Z
+	AnnotationTravelToFirstLayerPoint // travel to the first point of a layer
+)
+
+// Annotation is a slicer comment normalised to PrusaSlicer's meaning.
+type Annotation struct {
+	Kind    AnnotationKind
+	Value   float32
+	Feature string
+}
+
+// feature types, as named by PrusaSlicer
+const (
+	FeaturePerimeter                = "Perimeter"
+	FeatureExternalPerimeter        = "External perimeter"
+	FeatureOverhangPerimeter        = "Overhang perimeter"
+	FeatureInternalInfill           = "Internal infill"
+	FeatureSolidInfill              = "Solid infill"
+	FeatureTopSolidInfill           = "Top solid infill"
+	FeatureBridgeInfill             = "Bridge infill"
+	FeatureIroning                  = "Ironing"
+	FeatureGapFill                  = "Gap fill"
+	FeatureSkirt                    = "Skirt"
+	FeatureSkirtBrim                = "Skirt/Brim"
+	FeatureSupportMaterial          = "Support material"
+	FeatureSupportMaterialInterface = "Support material interface"
+	FeatureWipeTower                = "Wipe tower"
+	FeatureCustom                   = "Custom"
+)
+
+// featureNames maps other slicers' feature types to PrusaSlicer's
+var featureNames = map[string]string{
+	// Cura
+	"WALL-OUTER":        FeatureExternalPerimeter,
+	"WALL-INNER":        FeaturePerimeter,
+	"SKIN":              FeatureSolidInfill,
+	"FILL":              FeatureInternalInfill,
+	"SUPPORT":           FeatureSupportMaterial,
+	"SUPPORT-INTERFACE": FeatureSupportMaterialInterface,
+	"SKIRT":             FeatureSkirtBrim,
+	"PRIME-TOWER":       FeatureWipeTower,
+	// OrcaSlicer and Bambu Studio
+	"Outer wall":            FeatureExternalPerimeter,
+	"Inner wall":            FeaturePerimeter,
+	"Overhang wall":         FeatureOverhangPerimeter,
+	"Sparse infill":         FeatureInternalInfill,
+	"Internal solid infill": FeatureSolidInfill,
+	"Bottom surface":        FeatureSolidInfill,
+	"Top surface":           FeatureTopSolidInfill,
+	"Bridge":                FeatureBridgeInfill,
+	"Internal Bridge":       FeatureBridgeInfill,
+	"Gap infill":            FeatureGapFill,
+	"Brim":                  FeatureSkirtBrim,
+	"Support":               FeatureSupportMaterial,
+	"Support interface":     FeatureSupportMaterialInterface,
+	"Support transition":    FeatureSupportMaterial,
+	"Prime tower":           FeatureWipeTower,
+	// SuperSlicer
+	"Internal perimeter":     FeaturePerimeter,
+	"Internal bridge infill": FeatureBridgeInfill,
+	"Thin wall":              FeatureExternalPerimeter,
+	// Simplify3D
+	"outer perimeter": FeatureExternalPerimeter,
+	"inner perimeter": FeaturePerimeter,
+	"solid layer":     FeatureSolidInfill,
+	"infill":          FeatureInternalInfill,
+	"bridge":          FeatureBridgeInfill,
+	"gap fill":        FeatureGapFill,
+	"skirt":           FeatureSkirtBrim,
+	"support":         FeatureSupportMaterial,
+	"dense support":   FeatureSupportMaterialInterface,
+	"prime pillar":    FeatureWipeTower,
+}
+
+// NormalizeFeature returns the PrusaSlicer name of another slicer's feature
+// type, or the name as-is if it isn't known.
+func NormalizeFeature(name string) string {
+	if normalized, ok := featureNames[name]; ok {
+		return normalized
+	}
+	return name
+}
+
+var durationRegexp = regexp.MustCompile("(?:(\\d+)d ?)?(?:(\\d+)h ?)?(?:(\\d+)m ?)?(?:(\\d+)s)?")
+var simplify3DLayerRegexp = regexp.MustCompile("^layer \\d+, Z = (-?[0-9.]+)")
+var simplify3DBuildTimeRegexp = regexp.MustCompile("^Build time: (?:(\\d+) hours? ?)?(?:(\\d+) minutes?)?")
+var simplify3DToolRegexp = regexp.MustCompile("^tool H[0-9.]+ W([0-9.]+)")
+
+// parseDuration parses durations like "1d 2h 3m 4s" into seconds
+func parseDuration(str string) (float32, bool) {
+	matches := durationRegexp.FindStringSubmatch(strings.TrimSpace(str))
+	if matches == nil || len(matches[0]) == 0 {
+		return 0, false
+	}
+	seconds := 0
+	for i, unit := range []int{86400, 3600, 60, 1} {
+		if value, err := strconv.Atoi(matches[i+1]); err == nil {
+			seconds += value * unit
+		}
+	}
+	return float32(seconds), true
+}
+
+func parseAnnotationValue(kind AnnotationKind, value string) Annotation {
+	floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+	if err != nil {
+		return Annotation{}
+	}
+	return Annotation{Kind: kind, Value: float32(floatValue)}
+}
+
+type Slicer int
+
+const (
+	SlicerUnknown Slicer = iota // treated as PrusaSlicer
+	SlicerPrusaSlicer
+	SlicerSuperSlicer
+	SlicerOrcaSlicer
+	SlicerBambuStudio
+	SlicerCura
+	SlicerSimplify3D
+)
+
+// detectSlicer recognises the header comments written by each slicer
+func detectSlicer(comment string) Slicer {
+	switch {
+	case strings.HasPrefix(comment, "generated by PrusaSlicer"):
+		return SlicerPrusaSlicer
+	case strings.HasPrefix(comment, "generated by SuperSlicer"):
+		return SlicerSuperSlicer
+	case strings.HasPrefix(comment, "generated by OrcaSlicer"):
+		return SlicerOrcaSlicer
+	case strings.Contains(comment, "BambuStudio"):
+		return SlicerBambuStudio
+	case strings.HasPrefix(comment, "FLAVOR:"), strings.HasPrefix(comment, "Generated with Cura"):
+		return SlicerCura
+	case strings.HasPrefix(comment, "G-Code generated by Simplify3D"):
+		return SlicerSimplify3D
+	}
+	return SlicerUnknown
+}
+
+// Dialect normalises the annotations of the slicer that generated a G-code
+// file. The slicer is detected from the header comments (before the first
+// command), unless Slicer is set in advance. Use a new Dialect for every pass.
+type Dialect struct {
+	Slicer      Slicer
+	seenCommand bool
+
+	// for slicers that don't comment the moves to the first point of a layer
+	seenLayerChange    bool
+	seenFirstLayerMove bool
+	awaitingTravel     bool
+}
+
+// ParseAnnotation recognises the annotations written by PrusaSlicer, SuperSlicer,
+// OrcaSlicer, Bambu Studio, Cura and Simplify3D. Lines that aren't annotations
+// return AnnotationNone. It must be called for every line, in order.
+func (d *Dialect) ParseAnnotation(line Command) Annotation {
+	if line.Command != "" {
+		d.seenCommand = true
+		return d.parseMoveAnnotation(line)
+	}
+	comment := line.Comment
+	if comment == "" {
+		return Annotation{}
+	}
+	if d.Slicer == SlicerUnknown && !d.seenCommand {
+		d.Slicer = detectSlicer(comment)
+	}
+
+	annotation := d.parseCommentAnnotation(comment)
+	if annotation.Kind == AnnotationLayerChange {
+		d.seenLayerChange = true
+		d.awaitingTravel = true
+	}
+	return annotation
+}
+
+func (d *Dialect) parseCommentAnnotation(comment string) Annotation {
+	switch d.Slicer {
+	case SlicerCura:
+		return parseCuraAnnotation(comment)
+	case SlicerSimplify3D:
+		return parseSimplify3DAnnotation(comment)
+	case SlicerBambuStudio:
+		return parseBambuAnnotation(comment)
+	case SlicerOrcaSlicer:
+		if strings.HasPrefix(comment, "FEATURE:") {
+			return Annotation{Kind: AnnotationFeature, Feature: NormalizeFeature(strings.TrimSpace(comment[8:]))}
+		}
+	}
+	return parsePrusaSlicerAnnotation(comment)
+}
+
+// parseMoveAnnotation recognises the moves to the first point of a layer. PrusaSlicer
+// and SuperSlicer comment them; for the other slicers, they are the first Z move after
+// the first layer change, and the first travel after each layer change.
+func (d *Dialect) parseMoveAnnotation(line Command) Annotation {
+	if !line.IsLinearMove() {
+		return Annotation{}
+	}
+	switch line.Comment {
+	case "move to first layer point":
+		d.seenFirstLayerMove = true
+		return Annotation{Kind: AnnotationMoveToFirstLayerPoint}
+	case "travel to first layer point":
+		d.awaitingTravel = false
+		return Annotation{Kind: AnnotationTravelToFirstLayerPoint}
+	}
+	switch d.Slicer {
+	case SlicerCura, SlicerSimplify3D, SlicerOrcaSlicer, SlicerBambuStudio:
+	default:
+		return Annotation{}
+	}
+	if _, ok := line.Param("e"); ok || !d.seenLayerChange {
+		return Annotation{}
+	}
+	_, hasZ := line.Param("z")
+	if hasZ && !d.seenFirstLayerMove {
+		d.seenFirstLayerMove = true
+		d.awaitingTravel = false
+		return Annotation{Kind: AnnotationMoveToFirstLayerPoint}
+	}
+	_, hasX := line.Param("x")
+	_, hasY := line.Param("y")
+	if (hasX || hasY) && d.awaitingTravel {
+		d.awaitingTravel = false
+		return Annotation{Kind: AnnotationTravelToFirstLayerPoint}
+	}
+	return Annotation{}
+}
+
+func parsePrusaSlicerAnnotation(comment string) Annotation {
+	switch comment {
+	case "LAYER_CHANGE":
+		return Annotation{Kind: AnnotationLayerChange}
+	case "WIPE_START":
+		return Annotation{Kind: AnnotationWipeStart}
+	case "WIPE_END":
+		return Annotation{Kind: AnnotationWipeEnd}
+	}
+	if strings.HasPrefix(comment, "TYPE:") {
+		return Annotation{Kind: AnnotationFeature, Feature: NormalizeFeature(strings.TrimSpace(comment[5:]))}
+	} else if strings.HasPrefix(comment, "Z:") {
+		return parseAnnotationValue(AnnotationLayerZ, comment[2:])
+	} else if strings.HasPrefix(comment, "HEIGHT:") {
+		return parseAnnotationValue(AnnotationLayerHeight, comment[7:])
+	} else if strings.HasPrefix(comment, "WIDTH:") {
+		return parseAnnotationValue(AnnotationWidth, comment[6:])
+	} else if strings.HasPrefix(comment, "estimated printing time (normal mode) = ") {
+		if seconds, ok := parseDuration(comment[len("estimated printing time (normal mode) = "):]); ok {
+			return Annotation{Kind: AnnotationTimeEstimate, Value: seconds}
+		}
+	}
+	return Annotation{}
+}
+
+func parseBambuAnnotation(comment string) Annotation {
+	switch comment {
+	case "CHANGE_LAYER":
+		return Annotation{Kind: AnnotationLayerChange}
+	case "WIPE_START":
+		return Annotation{Kind: AnnotationWipeStart}
+	case "WIPE_END":
+		return Annotation{Kind: AnnotationWipeEnd}
+	}
+	if strings.HasPrefix(comment, "FEATURE:") {
+		return Annotation{Kind: AnnotationFeature, Feature: NormalizeFeature(strings.TrimSpace(comment[8:]))}
+	} else if strings.HasPrefix(comment, "Z_HEIGHT:") {
+		return parseAnnotationValue(AnnotationLayerZ, comment[9:])
+	} else if strings.HasPrefix(comment, "LAYER_HEIGHT:") {
+		return parseAnnotationValue(AnnotationLayerHeight, comment[13:])
+	} else if strings.HasPrefix(comment, "LINE_WIDTH:") {
+		return parseAnnotationValue(AnnotationWidth, comment[11:])
+	} else if index := strings.Index(comment, "total estimated time: "); index >= 0 {
+		// e.g. "model printing time: 1h 2m; total estimated time: 1h 5m 3s"
+		if seconds, ok := parseDuration(comment[index+len("total estimated time: "):]); ok {
+			return Annotation{Kind: AnnotationTimeEstimate, Value: seconds}
+		}
+	}
+	return Annotation{}
+}
+
+func parseCuraAnnotation(comment string) Annotation {
+	if strings.HasPrefix(comment, "LAYER:") {
+		// e.g. ;LAYER:0
+		if _, err := strconv.Atoi(comment[6:]); err == nil {
+			return Annotation{Kind: AnnotationLayerChange}
+		}
+	} else if strings.HasPrefix(comment, "TYPE:") {
+		// e.g. ;TYPE:WALL-OUTER
+		return Annotation{Kind: AnnotationFeature, Feature: NormalizeFeature(comment[5:])}
+	} else if strings.HasPrefix(comment, "TIME:") {
+		// in seconds
+		if seconds, err := strconv.Atoi(comment[5:]); err == nil {
+			return Annotation{Kind: AnnotationTimeEstimate, Value: float32(seconds)}
+		}
+	}
+	return Annotation{}
+}
+
+func parseSimplify3DAnnotation(comment string) Annotation {
+	if strings.HasPrefix(comment, "layer ") {
+		// e.g. "layer 1, Z = 0.200"
+		if matches := simplify3DLayerRegexp.FindStringSubmatch(comment); matches != nil {
+			return parseAnnotationValue(AnnotationLayerChange, matches[1])
+		}
+	} else if strings.HasPrefix(comment, "feature ") {
+		// e.g. "feature outer perimeter"
+		return Annotation{Kind: AnnotationFeature, Feature: NormalizeFeature(comment[8:])}
+	} else if strings.HasPrefix(comment, "tool ") {
+		// e.g. "tool H0.200 W0.480"
+		if matches := simplify3DToolRegexp.FindStringSubmatch(comment); matches != nil {
+			return parseAnnotationValue(AnnotationWidth, matches[1])
+		}
+	} else if strings.HasPrefix(comment, "Build time: ") {
+		// e.g. "Build time: 2 hours 42 minutes"
+		matches := simplify3DBuildTimeRegexp.FindStringSubmatch(comment)
+		if matches != nil && len(matches[0]) > len("Build time: ") {
+			hours, _ := strconv.Atoi(matches[1])
+			minutes, _ := strconv.Atoi(matches[2])
+			return Annotation{Kind: AnnotationTimeEstimate, Value: float32(hours*3600 + minutes*60)}
+		}
+	}
+	return Annotation{}
+}
@@ -0,0 +1,131 @@
+package gcode
+
+import "testing"
+
+func annotate(lines []string) []Annotation {
+	var dialect Dialect
+	annotations := make([]Annotation, 0)
+	for _, command := range parseLines(lines) {
+		if annotation := dialect.ParseAnnotation(command); annotation.Kind != AnnotationNone {
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations
+}
+
+func expectAnnotations(t *testing.T, name string, expected, actual []Annotation) {
+	if len(expected) != len(actual) {
+		t.Fatalf("%s: expected %d annotations, got %d (%v)", name, len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("%s: expected %+v, got %+v", name, expected[i], actual[i])
+		}
+	}
+}
+
+func Test_DialectPrusaSlicer(t *testing.T) {
+	expectAnnotations(t, "PrusaSlicer", []Annotation{
+		{Kind: AnnotationMoveToFirstLayerPoint},
+		{Kind: AnnotationTravelToFirstLayerPoint},
+		{Kind: AnnotationLayerChange},
+		{Kind: AnnotationLayerZ, Value: 0.2},
+		{Kind: AnnotationLayerHeight, Value: 0.2},
+		{Kind: AnnotationFeature, Feature: FeatureExternalPerimeter},
+		{Kind: AnnotationTimeEstimate, Value: 3723},
+	}, annotate([]string{
+		"; generated by PrusaSlicer 2.5.0+win64 on 2022-10-12 at 10:00:00 UTC",
+		"G21",
+		"G1 Z0.2 F7800 ; move to first layer point",
+		"G1 X10 Y10 ; travel to first layer point",
+		";LAYER_CHANGE",
+		";Z:0.2",
+		";HEIGHT:0.2",
+		"; layer 1, Z = 0.2", // from a layer change script, not Simplify3D
+		";TYPE:External perimeter",
+		"; estimated printing time (normal mode) = 1h 2m 3s",
+	}))
+}
+
+func Test_DialectCura(t *testing.T) {
+	expectAnnotations(t, "Cura", []Annotation{
+		{Kind: AnnotationTimeEstimate, Value: 6666},
+		{Kind: AnnotationLayerChange},
+		{Kind: AnnotationFeature, Feature: FeatureExternalPerimeter},
+		{Kind: AnnotationFeature, Feature: FeatureWipeTower},
+	}, annotate([]string{
+		";FLAVOR:Marlin",
+		";TIME:6666",
+		";Generated with Cura_SteamEngine 5.2.1",
+		";LAYER_COUNT:10",
+		";LAYER:0",
+		";TYPE:WALL-OUTER",
+		";TYPE:PRIME-TOWER",
+	}))
+}
+
+func Test_DialectFirstLayerPointMoves(t *testing.T) {
+	expectAnnotations(t, "Cura", []Annotation{
+		{Kind: AnnotationLayerChange},
+		{Kind: AnnotationMoveToFirstLayerPoint},
+		{Kind: AnnotationLayerChange},
+		{Kind: AnnotationTravelToFirstLayerPoint},
+	}, annotate([]string{
+		";FLAVOR:Marlin",
+		"G28",
+		"G1 Z15 F6000", // before the first layer
+		";LAYER:0",
+		"G0 F6000 X10 Y10 Z0.3",
+		"G1 X20 Y10 E1",
+		"G0 X30 Y30",
+		";LAYER:1",
+		"G0 X10 Y10 Z0.5",
+		"G1 X20 Y10 E1",
+		"G0 X30 Y30",
+	}))
+}
+
+func Test_DialectOrcaAndBambu(t *testing.T) {
+	expectAnnotations(t, "BambuStudio", []Annotation{
+		{Kind: AnnotationTimeEstimate, Value: 3903},
+		{Kind: AnnotationLayerChange},
+		{Kind: AnnotationLayerZ, Value: 0.2},
+		{Kind: AnnotationLayerHeight, Value: 0.2},
+		{Kind: AnnotationFeature, Feature: FeatureInternalInfill},
+		{Kind: AnnotationWidth, Value: 0.45},
+	}, annotate([]string{
+		"; HEADER_BLOCK_START",
+		"; BambuStudio 01.07.04.52",
+		"; model printing time: 1h 2m; total estimated time: 1h 5m 3s",
+		"; CHANGE_LAYER",
+		"; Z_HEIGHT: 0.2",
+		"; LAYER_HEIGHT: 0.2",
+		"; FEATURE: Sparse infill",
+		"; LINE_WIDTH: 0.45",
+	}))
+	expectAnnotations(t, "OrcaSlicer", []Annotation{
+		{Kind: AnnotationLayerChange},
+		{Kind: AnnotationFeature, Feature: FeatureWipeTower},
+	}, annotate([]string{
+		"; generated by OrcaSlicer 1.8.0 on 2023-11-01 at 10:00:00",
+		"; CHANGE_LAYER", // Orca writes both, so only ;LAYER_CHANGE counts
+		";LAYER_CHANGE",
+		"; FEATURE: Prime tower",
+	}))
+}
+
+func Test_DialectSimplify3D(t *testing.T) {
+	expectAnnotations(t, "Simplify3D", []Annotation{
+		{Kind: AnnotationLayerChange, Value: 0.3},
+		{Kind: AnnotationWidth, Value: 0.48},
+		{Kind: AnnotationFeature, Feature: FeatureExternalPerimeter},
+		{Kind: AnnotationTimeEstimate, Value: 9720},
+	}, annotate([]string{
+		"; G-Code generated by Simplify3D(R) Version 4.1.2",
+		"G90",
+		"; layer 1, Z = 0.300",
+		"; tool H0.300 W0.480",
+		"; feature outer perimeter",
+		";   Build time: 2 hours 42 minutes",
+	}))
+}
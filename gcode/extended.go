@@ -0,0 +1,122 @@
+package gcode
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ExtendedArg is a KEY=value argument of an extended (Klipper-style) command,
+// e.g. FAN=aux in "SET_FAN_SPEED FAN=aux SPEED=0.5". Keys are uppercase, and
+// values keep their original case (without surrounding quotes).
+type ExtendedArg struct {
+	Key   string
+	Value string
+}
+
+type ExtendedArgs []ExtendedArg
+
+// IsExtendedCommand returns true if name isn't a traditional command (a letter
+// followed by a number, e.g. G1 or M104), e.g. SET_FAN_SPEED or PAUSE.
+func IsExtendedCommand(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	c := name[0]
+	if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_') {
+		return false
+	}
+	if len(name) == 1 {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		c = name[i]
+		if !(c >= '0' && c <= '9' || c == '.') {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExtendedArg splits a KEY=value word
+func parseExtendedArg(word string) (ExtendedArg, bool) {
+	equals := strings.IndexByte(word, '=')
+	if equals <= 0 {
+		return ExtendedArg{}, false
+	}
+	value := word[equals+1:]
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return ExtendedArg{
+		Key:   strings.ToUpper(word[:equals]),
+		Value: value,
+	}, true
+}
+
+// Get returns the value of the given (case-insensitive) key.
+func (args ExtendedArgs) Get(key string) (string, bool) {
+	for _, arg := range args {
+		if strings.EqualFold(arg.Key, key) {
+			return arg.Value, true
+		}
+	}
+	return "", false
+}
+
+// Float returns the value of the given key as a number.
+func (args ExtendedArgs) Float(key string) (float32, bool) {
+	value, ok := args.Get(key)
+	if !ok {
+		return 0, false
+	}
+	floatValue, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return 0, false
+	}
+	return float32(floatValue), true
+}
+
+func (args ExtendedArgs) String() string {
+	var line strings.Builder
+	for _, arg := range args {
+		value := arg.Value
+		if strings.ContainsAny(value, " \t;#") {
+			value = "\"" + value + "\""
+		}
+		line.WriteString(" " + arg.Key + "=" + value)
+	}
+	return line.String()
+}
+
+// klipperExtruderIndex returns the tool index of a Klipper extruder name
+// (extruder == 0, extruder1 == 1, etc.)
+func klipperExtruderIndex(name string) (int, bool) {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "extruder") {
+		return -1, false
+	}
+	if name == "extruder" {
+		return 0, true
+	}
+	index, err := strconv.Atoi(name[len("extruder"):])
+	if err != nil || index < 0 {
+		return -1, false
+	}
+	return index, true
+}
+
+// parseKlipperHeater returns the heater (and tool, for extruders) of a Klipper heater name
+func parseKlipperHeater(name string) (Heater, int) {
+	if tool, ok := klipperExtruderIndex(name); ok {
+		return HeaterExtruder, tool
+	}
+	name = strings.ToLower(name)
+	if name == "heater_bed" {
+		return HeaterBed, -1
+	}
+	if strings.Contains(name, "chamber") {
+		// e.g. [heater_generic chamber]
+		return HeaterChamber, -1
+	}
+	return HeaterUnknown, -1
+}
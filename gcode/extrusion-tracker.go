@@ -16,7 +16,7 @@ func (et *ExtrusionTracker) TrackInstruction(instruction Command) {
 		return
 	}
 	if instruction.IsLinearMove() || instruction.IsArcMove() {
-		if eValue, ok := instruction.Params["e"]; ok {
+		if eValue, ok := instruction.Param("e"); ok {
 			et.PreviousExtrusionValue = et.CurrentExtrusionValue
 			et.CurrentExtrusionValue = eValue
 			if et.RelativeExtrusion {
@@ -59,16 +59,19 @@ func (et *ExtrusionTracker) TrackInstruction(instruction Command) {
 		}
 	} else if setExtrusionMode, relative := instruction.IsSetExtrusionMode(); setExtrusionMode {
 		et.RelativeExtrusion = relative
+	} else if setPositioningMode, relative := instruction.IsSetPositioningMode(); setPositioningMode {
+		// G90/G91 also switch the E axis (M82/M83 can override it afterwards)
+		et.RelativeExtrusion = relative
 	} else if instruction.IsSetPosition() {
-		hasParamsOrFlags := len(instruction.Params) > 0 || len(instruction.Flags) > 0
+		hasParamsOrFlags := instruction.HasArgs()
 		if hasParamsOrFlags {
-			if eValue, ok := instruction.Params["e"]; ok {
+			if eValue, ok := instruction.Param("e"); ok {
 				et.LastCommandWasG92 = true
 				et.CurrentExtrusionValue = eValue
-			} else if aValue, ok := instruction.Params["a"]; ok {
+			} else if aValue, ok := instruction.Param("a"); ok {
 				et.LastCommandWasG92 = true
 				et.CurrentExtrusionValue = aValue
-			} else if bValue, ok := instruction.Params["b"]; ok {
+			} else if bValue, ok := instruction.Param("b"); ok {
 				et.LastCommandWasG92 = true
 				et.CurrentExtrusionValue = bValue
 			}
@@ -0,0 +1,433 @@
+package gcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Flavor describes the G-code dialect of a firmware family: how it changes
+// tools, waits for heaters, retracts, pauses and dwells. Generated lines are
+// returned without a line ending.
+type Flavor interface {
+	Name() string
+
+	// IsToolChange returns true (and the tool index) if command selects a tool.
+	IsToolChange(command Command) (bool, int)
+	ToolChange(tool int) string
+
+	// IsTemperatureWait returns true if command blocks until a heater reaches its
+	// set-point, along with the heater and (for HeaterExtruder) the tool it waits
+	// for, or -1 for the active tool.
+	IsTemperatureWait(command Command) (bool, Heater, int)
+	// WaitForExtruder and WaitForBed return a command that waits for the heater
+	// to reach temp. Firmwares whose wait commands don't take a temperature wait
+	// for the current set-point instead.
+	WaitForExtruder(tool int, temp float32) string
+	WaitForBed(temp float32) string
+
+	// FirmwareRetract and FirmwareUnretract return "" if the firmware doesn't
+	// support firmware retraction.
+	FirmwareRetract() string
+	FirmwareUnretract() string
+
+	// Pause returns a command that pauses the print until the user resumes it.
+	Pause() string
+	// FilamentChange returns a command that parks the nozzle and waits for the user
+	// to load a different filament, or "" if the firmware has none (callers pause
+	// instead).
+	FilamentChange() string
+	Dwell(durationMS int) string
+	// DwellDuration returns the duration of a dwell command, in seconds.
+	DwellDuration(command Command) (float32, bool)
+}
+
+// DefaultFlavor is used when no flavor is configured.
+var DefaultFlavor Flavor = Generic{}
+
+// FlavorOrDefault returns flavor, or DefaultFlavor if it is nil.
+func FlavorOrDefault(flavor Flavor) Flavor {
+	if flavor == nil {
+		return DefaultFlavor
+	}
+	return flavor
+}
+
+// FlavorByName returns the flavor with the given (case-insensitive) name.
+// An empty name returns DefaultFlavor.
+func FlavorByName(name string) (Flavor, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return DefaultFlavor, nil
+	case "generic":
+		return Generic{}, nil
+	case "marlin":
+		return Marlin{}, nil
+	case "reprap", "reprapfirmware", "rrf":
+		return RepRapFirmware{}, nil
+	case "klipper":
+		return Klipper{}, nil
+	case "sailfish", "makerbot":
+		return Sailfish{}, nil
+	case "flashforge":
+		return FlashForge{}, nil
+	case "bambu":
+		return Bambu{}, nil
+	}
+	return nil, fmt.Errorf("unknown firmware flavor %q", name)
+}
+
+// parseToolCommand parses RepRap-style tool changes (e.g. T0)
+func parseToolCommand(command Command) (bool, int) {
+	if len(command.Command) > 1 && command.Command[0] == 'T' {
+		tool, err := strconv.ParseInt(command.Command[1:], 10, 32)
+		if err != nil {
+			return false, -1
+		}
+		return true, int(tool)
+	}
+	return false, -1
+}
+
+// parseWaitTool returns the tool given by a wait command's parameter, or -1 for the active tool
+func parseWaitTool(command Command, param string) int {
+	if t, ok := command.Param(param); ok {
+		return int(t + 0.5)
+	}
+	return -1
+}
+
+// parseToolParam parses tool changes that take the tool as a T parameter (e.g. M135 T0)
+func parseToolParam(command Command, name string) (bool, int) {
+	if command.Command != name {
+		return false, -1
+	}
+	if t, ok := command.Param("t"); ok {
+		return true, int(t + 0.5)
+	}
+	return false, -1
+}
+
+// Marlin is also the base of most other flavors.
+type Marlin struct{}
+
+func (Marlin) Name() string {
+	return "marlin"
+}
+
+func (Marlin) IsToolChange(command Command) (bool, int) {
+	return parseToolCommand(command)
+}
+
+func (Marlin) ToolChange(tool int) string {
+	return fmt.Sprintf("T%d", tool)
+}
+
+func (Marlin) IsTemperatureWait(command Command) (bool, Heater, int) {
+	switch command.Command {
+	case "M109":
+		return true, HeaterExtruder, parseWaitTool(command, "t")
+	case "M190":
+		return true, HeaterBed, -1
+	case "M191":
+		return true, HeaterChamber, -1
+	}
+	return false, HeaterUnknown, -1
+}
+
+func (Marlin) WaitForExtruder(tool int, temp float32) string {
+	return fmt.Sprintf("M109 S%s T%d", FormatFloat(float64(temp)), tool)
+}
+
+func (Marlin) WaitForBed(temp float32) string {
+	return "M190 S" + FormatFloat(float64(temp))
+}
+
+func (Marlin) FirmwareRetract() string {
+	return "G10"
+}
+
+func (Marlin) FirmwareUnretract() string {
+	return "G11"
+}
+
+func (Marlin) Pause() string {
+	return "M0"
+}
+
+func (Marlin) FilamentChange() string {
+	return "M600"
+}
+
+func (Marlin) Dwell(durationMS int) string {
+	return fmt.Sprintf("G4 P%d", durationMS)
+}
+
+func (Marlin) DwellDuration(command Command) (float32, bool) {
+	if command.Command != "G4" {
+		return 0, false
+	}
+	if ms, ok := command.Param("p"); ok {
+		// e.g. G4 P5000
+		return ms / 1000, true
+	}
+	if s, ok := command.Param("s"); ok {
+		// e.g. G4 S5
+		return s, true
+	}
+	return 0, true
+}
+
+// Generic outputs Marlin syntax, but recognises the tool changes and heater
+// waits of every flavor that the trackers understand (RepRap, Sailfish and Klipper).
+type Generic struct {
+	Marlin
+}
+
+func (Generic) Name() string {
+	return "generic"
+}
+
+func (Generic) IsToolChange(command Command) (bool, int) {
+	if isToolChange, tool := (Sailfish{}).IsToolChange(command); isToolChange {
+		return isToolChange, tool
+	}
+	return Klipper{}.IsToolChange(command)
+}
+
+func (Generic) IsTemperatureWait(command Command) (bool, Heater, int) {
+	if isWait, heater, tool := (Sailfish{}).IsTemperatureWait(command); isWait {
+		return isWait, heater, tool
+	}
+	if isWait, heater, tool := (RepRapFirmware{}).IsTemperatureWait(command); isWait {
+		return isWait, heater, tool
+	}
+	return Klipper{}.IsTemperatureWait(command)
+}
+
+type RepRapFirmware struct {
+	Marlin
+}
+
+func (RepRapFirmware) Name() string {
+	return "reprap"
+}
+
+func (RepRapFirmware) IsTemperatureWait(command Command) (bool, Heater, int) {
+	if command.Command == "M116" {
+		// wait for all heaters, or only the tool given by P
+		if _, ok := command.Param("p"); ok {
+			return true, HeaterExtruder, parseWaitTool(command, "p")
+		}
+		return true, HeaterAll, -1
+	}
+	return Marlin{}.IsTemperatureWait(command)
+}
+
+func (RepRapFirmware) WaitForExtruder(tool int, _ float32) string {
+	// (M109 would also select the tool)
+	return fmt.Sprintf("M116 P%d", tool)
+}
+
+func (RepRapFirmware) Pause() string {
+	return "M226"
+}
+
+type Klipper struct {
+	Marlin
+}
+
+func (Klipper) Name() string {
+	return "klipper"
+}
+
+func (Klipper) IsToolChange(command Command) (bool, int) {
+	if command.Command == "ACTIVATE_EXTRUDER" {
+		// e.g. ACTIVATE_EXTRUDER EXTRUDER=extruder1
+		if name, ok := command.Extended.Get("extruder"); ok {
+			if tool, ok := klipperExtruderIndex(name); ok {
+				return true, tool
+			}
+		}
+		return false, -1
+	}
+	// T<n> macros
+	return parseToolCommand(command)
+}
+
+func (Klipper) ToolChange(tool int) string {
+	if tool == 0 {
+		return "ACTIVATE_EXTRUDER EXTRUDER=extruder"
+	}
+	return fmt.Sprintf("ACTIVATE_EXTRUDER EXTRUDER=extruder%d", tool)
+}
+
+func (Klipper) IsTemperatureWait(command Command) (bool, Heater, int) {
+	if command.Command == "TEMPERATURE_WAIT" {
+		// e.g. TEMPERATURE_WAIT SENSOR=extruder MINIMUM=205
+		name, _ := command.Extended.Get("sensor")
+		// sensors may be given with their type (e.g. "heater_generic chamber")
+		if space := strings.LastIndexByte(name, ' '); space >= 0 {
+			name = name[space+1:]
+		}
+		heater, tool := parseKlipperHeater(name)
+		return true, heater, tool
+	}
+	return Marlin{}.IsTemperatureWait(command)
+}
+
+func (Klipper) Pause() string {
+	return "PAUSE"
+}
+
+func (Klipper) FilamentChange() string {
+	// M600 is only available if the printer's config defines it as a macro
+	return "PAUSE"
+}
+
+// Sailfish is used by Makerbot printers and their clones.
+type Sailfish struct {
+	Marlin
+}
+
+func (Sailfish) Name() string {
+	return "sailfish"
+}
+
+func (Sailfish) IsToolChange(command Command) (bool, int) {
+	// e.g. M135 T0
+	return parseToolParam(command, "M135")
+}
+
+func (Sailfish) ToolChange(tool int) string {
+	return fmt.Sprintf("M135 T%d", tool)
+}
+
+func (Sailfish) IsTemperatureWait(command Command) (bool, Heater, int) {
+	switch command.Command {
+	case "M133":
+		// e.g. M133 T0
+		return true, HeaterExtruder, parseWaitTool(command, "t")
+	case "M134":
+		return true, HeaterBed, -1
+	}
+	return false, HeaterUnknown, -1
+}
+
+func (Sailfish) WaitForExtruder(tool int, _ float32) string {
+	return fmt.Sprintf("M133 T%d", tool)
+}
+
+func (Sailfish) WaitForBed(_ float32) string {
+	return "M134 T0"
+}
+
+func (Sailfish) FirmwareRetract() string {
+	return ""
+}
+
+func (Sailfish) FirmwareUnretract() string {
+	return ""
+}
+
+func (Sailfish) Pause() string {
+	// display a message and wait for the user to press a button
+	return "M71 (Paused)"
+}
+
+func (Sailfish) FilamentChange() string {
+	return ""
+}
+
+type FlashForge struct {
+	Marlin
+}
+
+func (FlashForge) Name() string {
+	return "flashforge"
+}
+
+func (FlashForge) IsToolChange(command Command) (bool, int) {
+	// e.g. M108 T0
+	return parseToolParam(command, "M108")
+}
+
+func (FlashForge) ToolChange(tool int) string {
+	return fmt.Sprintf("M108 T%d", tool)
+}
+
+func (FlashForge) IsTemperatureWait(command Command) (bool, Heater, int) {
+	switch command.Command {
+	case "M6":
+		// e.g. M6 T0
+		return true, HeaterExtruder, parseWaitTool(command, "t")
+	case "M7":
+		return true, HeaterBed, -1
+	}
+	return false, HeaterUnknown, -1
+}
+
+func (FlashForge) WaitForExtruder(tool int, _ float32) string {
+	return fmt.Sprintf("M6 T%d", tool)
+}
+
+func (FlashForge) WaitForBed(_ float32) string {
+	return "M7"
+}
+
+func (FlashForge) FirmwareRetract() string {
+	return ""
+}
+
+func (FlashForge) FirmwareUnretract() string {
+	return ""
+}
+
+func (FlashForge) Pause() string {
+	// pause the SD card print
+	return "M25"
+}
+
+func (FlashForge) FilamentChange() string {
+	return ""
+}
+
+// bambuSpecialTools are T commands with special meanings in Bambu firmware
+// (e.g. T255 unloads the AMS), rather than tool changes
+const bambuSpecialTools = 255
+
+type Bambu struct {
+	Marlin
+}
+
+func (Bambu) Name() string {
+	return "bambu"
+}
+
+func (Bambu) IsToolChange(command Command) (bool, int) {
+	if isToolChange, tool := parseToolCommand(command); isToolChange && tool < bambuSpecialTools {
+		return true, tool
+	}
+	return false, -1
+}
+
+func (Bambu) WaitForExtruder(_ int, temp float32) string {
+	// single hotend
+	return "M109 S" + FormatFloat(float64(temp))
+}
+
+func (Bambu) FirmwareRetract() string {
+	return ""
+}
+
+func (Bambu) FirmwareUnretract() string {
+	return ""
+}
+
+func (Bambu) Pause() string {
+	return "M400 U1"
+}
+
+func (Bambu) FilamentChange() string {
+	return ""
+}
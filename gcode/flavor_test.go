@@ -0,0 +1,90 @@
+package gcode
+
+import "testing"
+
+func Test_FlavorToolChanges(t *testing.T) {
+	for _, test := range []struct {
+		flavor   string
+		line     string
+		expected int // -1 == not a tool change
+	}{
+		{"marlin", "T1", 1},
+		{"marlin", "M135 T1", -1},
+		{"sailfish", "M135 T1", 1},
+		{"flashforge", "M108 T1", 1},
+		{"klipper", "ACTIVATE_EXTRUDER EXTRUDER=extruder2", 2},
+		{"bambu", "T255", -1},
+		{"", "M135 T3", 3},
+		{"", "T2", 2},
+	} {
+		flavor, err := FlavorByName(test.flavor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isToolChange, tool := flavor.IsToolChange(ParseLineArgs(test.line))
+		if !isToolChange {
+			tool = -1
+		}
+		if tool != test.expected {
+			t.Errorf("%s: expected %q to select tool %d, got %d", flavor.Name(), test.line, test.expected, tool)
+		}
+	}
+}
+
+func Test_FlavorTemperatureWaits(t *testing.T) {
+	for _, test := range []struct {
+		flavor string
+		line   string
+		heater Heater // HeaterUnknown == not a wait
+		tool   int
+	}{
+		{"marlin", "M109 S210 T1", HeaterExtruder, 1},
+		{"marlin", "M104 S210", HeaterUnknown, -1},
+		{"marlin", "M116", HeaterUnknown, -1},
+		{"reprap", "M116", HeaterAll, -1},
+		{"reprap", "M116 P2", HeaterExtruder, 2},
+		{"klipper", "TEMPERATURE_WAIT SENSOR=heater_bed MINIMUM=58", HeaterBed, -1},
+		{"sailfish", "M133 T1", HeaterExtruder, 1},
+		{"flashforge", "M7", HeaterBed, -1},
+		{"", "M190 S60", HeaterBed, -1},
+	} {
+		flavor, err := FlavorByName(test.flavor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isWait, heater, tool := flavor.IsTemperatureWait(ParseLineArgs(test.line))
+		if !isWait {
+			heater, tool = HeaterUnknown, -1
+		}
+		if heater != test.heater || tool != test.tool {
+			t.Errorf("%s: expected %q to wait for heater %d (tool %d), got %d (tool %d)", flavor.Name(), test.line, test.heater, test.tool, heater, tool)
+		}
+	}
+}
+
+func Test_FlavorOutput(t *testing.T) {
+	if line := (FlashForge{}).WaitForExtruder(1, 210); line != "M6 T1" {
+		t.Errorf("unexpected FlashForge wait %q", line)
+	}
+	if line := (Marlin{}).WaitForExtruder(1, 210); line != "M109 S210 T1" {
+		t.Errorf("unexpected Marlin wait %q", line)
+	}
+	if seconds, ok := (Marlin{}).DwellDuration(ParseLineArgs("G4 P2500")); !ok || seconds != 2.5 {
+		t.Errorf("expected 2.5s dwell, got %f", seconds)
+	}
+	if line := (Sailfish{}).Pause(); line == (Marlin{}).Pause() {
+		t.Errorf("expected Sailfish to have its own pause command, got %q", line)
+	}
+	if line := (FlashForge{}).Pause(); line == (Marlin{}).Pause() {
+		t.Errorf("expected FlashForge to have its own pause command, got %q", line)
+	}
+	if line := (Klipper{}).FilamentChange(); line != "PAUSE" {
+		t.Errorf("unexpected Klipper filament change %q", line)
+	}
+	if line := (Bambu{}).FilamentChange(); line != "" {
+		t.Errorf("expected no Bambu filament change command, got %q", line)
+	}
+	if _, err := FlavorByName("unknown"); err == nil {
+		t.Error("expected an error for an unknown flavor")
+	}
+}
@@ -0,0 +1,191 @@
+package gcode
+
+import "strings"
+
+type TokenKind int
+
+const (
+	TokenWhitespace TokenKind = iota
+	TokenLineNumber           // e.g. N123
+	TokenCommand              // e.g. G1, M104, T0
+	TokenParam                // e.g. X10, E.5, P"file.gcode", a flag (letter without a value), or KEY=value
+	TokenText                 // free-text argument of commands like M117, or a standalone quoted string
+	TokenChecksum             // e.g. *45
+	TokenComment              // "; ..." (or "# ..." after an extended command) to the end of the line, or "( ... )"
+)
+
+// Token is a single lexical element of a line. Text is the token's original
+// spelling (a sub-slice of the line), so concatenating the Text of every token
+// reproduces the line byte-for-byte.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int // byte offset of the token in the line
+}
+
+// freeTextCommands take the rest of the line as a single string argument,
+// which must not be interpreted as parameters.
+var freeTextCommands = map[string]bool{
+	"M23":  true, // select SD file
+	"M28":  true, // start SD write
+	"M70":  true, // display message (Makerbot)
+	"M117": true, // display message
+	"M118": true, // serial print
+}
+
+func IsFreeTextCommand(command string) bool {
+	return freeTextCommands[command]
+}
+
+// Lexer splits a line of G-code into tokens without allocating.
+type Lexer struct {
+	line       string
+	pos        int
+	seenWord   bool // true once the command has been read
+	inFreeText bool // true if the rest of the line (before a comment) is free text
+	extended   bool // true if the command is an extended command (e.g. SET_FAN_SPEED)
+}
+
+func NewLexer(line string) Lexer {
+	return Lexer{line: line}
+}
+
+// Lex returns every token in line.
+func Lex(line string) []Token {
+	tokens := make([]Token, 0, 8)
+	lexer := NewLexer(line)
+	for token, ok := lexer.Next(); ok; token, ok = lexer.Next() {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// skipQuoted returns the position just after the quoted string starting at
+// start ("" inside a string is an escaped quote), or the end of the line
+func (l *Lexer) skipQuoted(start int) int {
+	i := start + 1
+	for i < len(l.line) {
+		if l.line[i] == '"' {
+			if i+1 < len(l.line) && l.line[i+1] == '"' {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+// isChecksumAt returns true if a checksum (*<digits>) starts at i, and is only
+// followed by whitespace or a comment
+func (l *Lexer) isChecksumAt(i int) bool {
+	if i >= len(l.line) || l.line[i] != '*' {
+		return false
+	}
+	j := i + 1
+	for j < len(l.line) && l.line[j] >= '0' && l.line[j] <= '9' {
+		j++
+	}
+	if j == i+1 {
+		return false
+	}
+	for j < len(l.line) && isSpace(l.line[j]) {
+		j++
+	}
+	return j == len(l.line) || l.line[j] == ';'
+}
+
+func (l *Lexer) token(kind TokenKind, end int) (Token, bool) {
+	token := Token{Kind: kind, Text: l.line[l.pos:end], Pos: l.pos}
+	l.pos = end
+	return token, true
+}
+
+// Next returns the next token, or false at the end of the line.
+func (l *Lexer) Next() (Token, bool) {
+	if l.pos >= len(l.line) {
+		return Token{}, false
+	}
+	start := l.pos
+	c := l.line[start]
+
+	if isSpace(c) {
+		end := start
+		for end < len(l.line) && isSpace(l.line[end]) {
+			end++
+		}
+		return l.token(TokenWhitespace, end)
+	}
+	if c == ';' || c == '#' && l.extended {
+		return l.token(TokenComment, len(l.line))
+	}
+	if l.isChecksumAt(start) {
+		end := start + 1
+		for end < len(l.line) && l.line[end] >= '0' && l.line[end] <= '9' {
+			end++
+		}
+		return l.token(TokenChecksum, end)
+	}
+	if l.inFreeText {
+		// runs until a comment or checksum, excluding trailing whitespace
+		end := start
+		lastNonSpace := start
+		for end < len(l.line) && l.line[end] != ';' && !l.isChecksumAt(end) {
+			if l.line[end] == '"' {
+				end = l.skipQuoted(end)
+				lastNonSpace = end
+				continue
+			}
+			if !isSpace(l.line[end]) {
+				lastNonSpace = end + 1
+			}
+			end++
+		}
+		return l.token(TokenText, lastNonSpace)
+	}
+	if c == '(' {
+		end := strings.IndexByte(l.line[start:], ')')
+		if end < 0 {
+			return l.token(TokenComment, len(l.line))
+		}
+		return l.token(TokenComment, start+end+1)
+	}
+	if c == '"' {
+		return l.token(TokenText, l.skipQuoted(start))
+	}
+
+	// a word: a letter followed by its value, which may include a quoted string
+	end := start + 1
+	for end < len(l.line) {
+		c = l.line[end]
+		if c == '"' {
+			end = l.skipQuoted(end)
+			continue
+		}
+		if isSpace(c) || c == ';' || c == '(' || c == '#' && l.extended || l.isChecksumAt(end) {
+			break
+		}
+		end++
+	}
+	if l.seenWord {
+		return l.token(TokenParam, end)
+	}
+	word := l.line[start:end]
+	if (word[0] == 'N' || word[0] == 'n') && len(word) > 1 && isDigits(word[1:]) {
+		return l.token(TokenLineNumber, end)
+	}
+	l.seenWord = true
+	l.inFreeText = IsFreeTextCommand(strings.ToUpper(word))
+	l.extended = IsExtendedCommand(word)
+	return l.token(TokenCommand, end)
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
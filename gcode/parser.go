@@ -12,43 +12,81 @@ func normalizeNewlines(input string) string {
 	return strings.ReplaceAll(input, "\r", "\n")
 }
 
-func ParseLine(raw string) Command {
-	params := Params{}
-	flags := Flags{}
-	if len(strings.Trim(raw, " ")) == 0 {
-		return NewCommand(raw, "", "", params, flags)
-	}
-
-	// collect any comments
-	commentSplit := strings.Split(raw, ";")
-	line := strings.Trim(commentSplit[0], " ")
-	comment := strings.Trim(strings.Join(commentSplit[1:], ";"), " ")
-
-	// split the line at spaces
-	argv := strings.Fields(line)
-	argc := len(argv)
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
 
-	// get the command
-	command := ""
-	if len(argv) > 0 {
-		command = strings.ToUpper(argv[0])
+// parseLine parses raw into a Command whose Args are populated. Command, Comment
+// and Text are sub-slices of raw, so the only allocations are for unusual input
+// (e.g. lowercase commands). Line numbers and checksums are skipped -- Raw keeps
+// the original line, so unmodified commands are written back byte-for-byte.
+func parseLine(raw string) Command {
+	command := Command{Raw: raw}
+	if len(strings.Trim(raw, " ")) == 0 {
+		return command
 	}
 
-	// collect arguments
-	for i := 1; i < argc; i++ {
-		key := strings.ToLower(argv[i][0:1])
-		value := argv[i][1:]
-		if len(value) == 0 {
-			flags[key] = true
-		} else {
-			floatValue, err := strconv.ParseFloat(value, 32)
-			if err == nil {
-				params[key] = float32(floatValue)
+	lexer := NewLexer(raw)
+	for token, ok := lexer.Next(); ok; token, ok = lexer.Next() {
+		switch token.Kind {
+		case TokenCommand:
+			// (ToUpper returns the word as-is if it has no lowercase letters)
+			command.Command = strings.ToUpper(token.Text)
+		case TokenParam:
+			if IsExtendedCommand(command.Command) {
+				if arg, ok := parseExtendedArg(token.Text); ok {
+					command.Extended = append(command.Extended, arg)
+				}
+				continue
+			}
+			key := token.Text[0:1]
+			value := token.Text[1:]
+			if len(value) == 0 {
+				command.Args.SetFlag(key)
+			} else {
+				// string values (e.g. P"file.gcode") aren't stored
+				floatValue, err := strconv.ParseFloat(value, 32)
+				if err == nil {
+					command.Args.Set(key, float32(floatValue))
+				}
+			}
+		case TokenText:
+			if command.Text == "" {
+				command.Text = token.Text
+			}
+		case TokenComment:
+			if token.Text[0] == ';' || token.Text[0] == '#' {
+				command.Comment = strings.Trim(token.Text[1:], " ")
+			} else if command.Comment == "" {
+				// ( ... ) comment
+				command.Comment = strings.Trim(strings.TrimSuffix(token.Text[1:], ")"), " ")
 			}
 		}
 	}
+	return command
+}
+
+// ParseLine parses a single line of G-code. For compatibility, the Params and
+// Flags maps are populated as well as Args -- use ParseLineArgs instead where
+// the maps aren't needed.
+func ParseLine(raw string) Command {
+	command := parseLine(raw)
+	command.Params = command.Args.Params()
+	command.Flags = command.Args.Flags()
+	return command
+}
+
+// ParseLineArgs parses a single line of G-code into a Command without the
+// Params and Flags maps. Its parameters must be read with Command.Param and
+// Command.HasFlag, or through Args.
+func ParseLineArgs(raw string) Command {
+	return parseLine(raw)
+}
 
-	return NewCommand(raw, command, comment, params, flags)
+// ParseLineBytes is equivalent to ParseLineArgs, but takes the line as a byte slice
+// (e.g. from a bufio.Reader). The line is copied once, to be kept as Raw.
+func ParseLineBytes(line []byte) Command {
+	return parseLine(string(line))
 }
 
 func ParseLines(raw string) []Command {
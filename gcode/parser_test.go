@@ -0,0 +1,195 @@
+package gcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var benchmarkLines = []string{
+	";LAYER_CHANGE",
+	";Z:0.4",
+	"G1 Z.4 F10800",
+	"G1 X104.882 Y97.43 E.01234",
+	"G1 X105.529 Y96.866 E.02781 ; perimeter",
+	"G1 E-.8 F2100",
+	"M106 S255",
+	"G92 E0",
+	"T1",
+	"",
+}
+
+func Test_ParseLineArgsMatchesMaps(t *testing.T) {
+	for _, raw := range []string{
+		"G1 X10 Y-2.5 E.01 F1200 ; comment ; with semicolon",
+		"g28 x y",
+		"  M104   S215	T1  ",
+		"; only a comment",
+		"",
+	} {
+		compat := ParseLine(raw)
+		fast := ParseLineArgs(raw)
+		if compat.Command != fast.Command || compat.Comment != fast.Comment {
+			t.Errorf("%q: expected %q/%q, got %q/%q", raw, compat.Command, compat.Comment, fast.Command, fast.Comment)
+		}
+		if fast.Params != nil || fast.Flags != nil {
+			t.Errorf("%q: expected no maps from ParseLineArgs", raw)
+		}
+		for key, value := range compat.Params {
+			if fastValue, ok := fast.Param(key); !ok || fastValue != value {
+				t.Errorf("%q: expected %s = %f, got %f", raw, key, value, fastValue)
+			}
+		}
+		for key := range compat.Flags {
+			if !fast.HasFlag(key) {
+				t.Errorf("%q: expected flag %s", raw, key)
+			}
+		}
+	}
+}
+
+func Test_CommandStringFromArgs(t *testing.T) {
+	command := ParseLineArgs("G1 F1200 E1.5 Y2 X1 ; move")
+	command.SetParam("z", 0.2)
+	expected := "G1 X1 Y2 Z0.2 E1.5 F1200 ; move"
+	if command.String() != expected {
+		t.Errorf("expected %q, got %q", expected, command.String())
+	}
+	// the compatibility maps produce the same output
+	compat := ParseLine("G1 F1200 E1.5 Y2 X1 ; move")
+	compat.SetParam("z", 0.2)
+	if compat.String() != expected {
+		t.Errorf("expected %q, got %q", expected, compat.String())
+	}
+}
+
+func Test_LexIsLossless(t *testing.T) {
+	for _, raw := range []string{
+		"N123 G1 X10 Y-2.5 E.01*45 ; comment",
+		"G1 X10 (move) Y20 ; done",
+		"M117 \"text; with semicolon\" ; comment",
+		"M23 file.gco",
+		"M291 P\"Load \"\"filament\"\"\" S1",
+		"  M104   S215	T1  ",
+		"G1 X1 (unclosed",
+	} {
+		var joined strings.Builder
+		for _, token := range Lex(raw) {
+			if raw[token.Pos:token.Pos+len(token.Text)] != token.Text {
+				t.Errorf("%q: token %q is not at position %d", raw, token.Text, token.Pos)
+			}
+			joined.WriteString(token.Text)
+		}
+		if joined.String() != raw {
+			t.Errorf("expected %q, got %q", raw, joined.String())
+		}
+	}
+}
+
+func Test_ParseLineTokens(t *testing.T) {
+	command := ParseLineArgs("N123 G1 X10 (move) Y20*45 ; done")
+	if command.Command != "G1" || command.Comment != "done" {
+		t.Errorf("expected G1/done, got %q/%q", command.Command, command.Comment)
+	}
+	if x, _ := command.Param("x"); x != 10 {
+		t.Errorf("expected X10, got %f", x)
+	}
+	if y, _ := command.Param("y"); y != 20 {
+		t.Errorf("expected Y20, got %f", y)
+	}
+	if command.HasParam("n") {
+		t.Errorf("expected no N param")
+	}
+
+	command = ParseLine("M117 \"X10; with semicolon\" ; comment")
+	if command.Text != "\"X10; with semicolon\"" || command.Comment != "comment" {
+		t.Errorf("unexpected text/comment %q/%q", command.Text, command.Comment)
+	}
+	if command.HasArgs() {
+		t.Errorf("expected free text not to be parsed as params")
+	}
+
+	command = ParseLineArgs("M23 X5.gco")
+	command.Raw = ""
+	if command.HasArgs() || command.String() != "M23 X5.gco" {
+		t.Errorf("expected M23 X5.gco, got %q", command.String())
+	}
+}
+
+func Test_ParseLineExtended(t *testing.T) {
+	command := ParseLine("set_fan_speed FAN=aux SPEED=0.5 # comment")
+	if !command.IsExtended() || command.Command != "SET_FAN_SPEED" || command.Comment != "comment" {
+		t.Errorf("unexpected command %q/%q", command.Command, command.Comment)
+	}
+	if command.HasArgs() {
+		t.Errorf("expected no letter params for an extended command")
+	}
+	if fan, _ := command.Extended.Get("fan"); fan != "aux" {
+		t.Errorf("expected FAN=aux, got %q", fan)
+	}
+	if speed, _ := command.Extended.Float("SPEED"); speed != 0.5 {
+		t.Errorf("expected SPEED=0.5, got %f", speed)
+	}
+	if !command.IsFanCommand() || !command.IsEnableFanCommand() {
+		t.Errorf("expected SET_FAN_SPEED to be a fan command")
+	}
+
+	command = ParseLineArgs(`RESPOND MSG="hello world"`)
+	command.Raw = ""
+	if command.String() != `RESPOND MSG="hello world"` {
+		t.Errorf("unexpected string %q", command.String())
+	}
+
+	for _, traditional := range []string{"G1", "M862.3", "T0", "M117"} {
+		if IsExtendedCommand(traditional) {
+			t.Errorf("expected %s not to be extended", traditional)
+		}
+	}
+}
+
+func Benchmark_ParseLine(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, line := range benchmarkLines {
+			ParseLine(line)
+		}
+	}
+}
+
+func Benchmark_ParseLineArgs(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, line := range benchmarkLines {
+			ParseLineArgs(line)
+		}
+	}
+}
+
+func benchmarkReader(b *testing.B, read func(*bytes.Reader, LineCallback) error) {
+	data := []byte(strings.Repeat(strings.Join(benchmarkLines, "\n")+"\n", 1000))
+	var tracker PositionTracker
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := read(bytes.NewReader(data), func(command Command, _ int) error {
+			tracker.TrackInstruction(command)
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ReadByLineFrom(b *testing.B) {
+	benchmarkReader(b, func(input *bytes.Reader, callback LineCallback) error {
+		return ReadByLineFrom(input, callback)
+	})
+}
+
+func Benchmark_ReadArgsByLineFrom(b *testing.B) {
+	benchmarkReader(b, func(input *bytes.Reader, callback LineCallback) error {
+		return ReadArgsByLineFrom(input, callback)
+	})
+}
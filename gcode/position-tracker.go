@@ -1,46 +1,125 @@
 package gcode
 
+import "math"
+
+// PositionTracker follows the toolhead position through a G-code file.
+//
+// CurrentX/Y/Z are logical (workspace) coordinates, i.e. the values an absolute
+// move would need to use to reach the current position. G92 shifts the workspace
+// relative to the machine's own coordinates by Offset, so that the physical
+// position is always MachinePosition() == Current + Offset.
 type PositionTracker struct {
 	CurrentX        float32
 	CurrentY        float32
 	CurrentZ        float32
 	CurrentFeedrate float32
+	RelativeXYZ     bool       // true == relative (G91), false == absolute (G90)
+	Offset          [3]float32 // workspace offset applied by G92, per axis
+	HomePosition    [3]float32 // machine position of each axis after homing with G28
+	Plane           Plane      // working plane for arc moves, set by G17/G18/G19
+	LastMoveLength  float32    // length of the path travelled by the most recent move, in mm
+	LastArc         *Arc       // path swept by the most recent move, or nil if it was not an arc
+}
+
+func (pt *PositionTracker) Position() [3]float32 {
+	return [3]float32{pt.CurrentX, pt.CurrentY, pt.CurrentZ}
+}
+
+// MachinePosition returns the current position in machine coordinates,
+// i.e. with any G92 workspace offset removed.
+func (pt *PositionTracker) MachinePosition() [3]float32 {
+	return [3]float32{
+		pt.CurrentX + pt.Offset[0],
+		pt.CurrentY + pt.Offset[1],
+		pt.CurrentZ + pt.Offset[2],
+	}
+}
+
+// Target returns the logical position a move command will end at,
+// taking the current positioning mode into account.
+func (pt *PositionTracker) Target(instruction Command) [3]float32 {
+	target := pt.Position()
+	for axis, param := range [3]string{"x", "y", "z"} {
+		if value, ok := instruction.Param(param); ok {
+			if pt.RelativeXYZ {
+				target[axis] += value
+			} else {
+				target[axis] = value
+			}
+		}
+	}
+	return target
+}
+
+func (pt *PositionTracker) setPosition(position [3]float32) {
+	pt.CurrentX, pt.CurrentY, pt.CurrentZ = position[0], position[1], position[2]
 }
 
 func (pt *PositionTracker) TrackInstruction(instruction Command) {
 	if len(instruction.Command) == 0 {
 		return
 	}
-	if instruction.IsLinearMove() || instruction.IsArcMove() {
-		if x, ok := instruction.Params["x"]; ok {
-			pt.CurrentX = x
-		}
-		if y, ok := instruction.Params["y"]; ok {
-			pt.CurrentY = y
-		}
-		if z, ok := instruction.Params["z"]; ok {
-			pt.CurrentZ = z
+	if instruction.IsArcMove() {
+		start := pt.Position()
+		if arc, err := NewArcTo(instruction, pt.Plane, start, pt.Target(instruction)); err == nil {
+			pt.setPosition(arc.End)
+			if f, ok := instruction.Param("f"); ok {
+				pt.CurrentFeedrate = f
+			}
+			pt.LastMoveLength = arc.Length()
+			pt.LastArc = &arc
+			return
 		}
-		if f, ok := instruction.Params["f"]; ok {
+		// malformed arcs are treated as straight moves to their endpoint
+	}
+	if instruction.IsLinearMove() || instruction.IsArcMove() {
+		fromX, fromY, fromZ := pt.CurrentX, pt.CurrentY, pt.CurrentZ
+		pt.setPosition(pt.Target(instruction))
+		if f, ok := instruction.Param("f"); ok {
 			pt.CurrentFeedrate = f
 		}
-	} else if instruction.IsHome() {
-		if instruction.Flags["x"] || instruction.Flags["y"] || instruction.Flags["z"] {
-			// flags present == only home some axes
-			if instruction.Flags["x"] {
-				pt.CurrentX = 0
+		dx := float64(pt.CurrentX - fromX)
+		dy := float64(pt.CurrentY - fromY)
+		dz := float64(pt.CurrentZ - fromZ)
+		pt.LastMoveLength = float32(math.Sqrt(dx*dx + dy*dy + dz*dz))
+		pt.LastArc = nil
+	} else if isSetPositioningMode, relative := instruction.IsSetPositioningMode(); isSetPositioningMode {
+		pt.RelativeXYZ = relative
+	} else if isSetPlane, plane := instruction.IsSetPlane(); isSetPlane {
+		pt.Plane = plane
+	} else if instruction.IsSetPosition() {
+		machine := pt.MachinePosition()
+		position := pt.Position()
+		hasParamsOrFlags := instruction.HasArgs()
+		for axis, param := range [3]string{"x", "y", "z"} {
+			if !hasParamsOrFlags {
+				// no parameters == set all axes to zero
+				position[axis] = 0
+			} else if value, ok := instruction.Param(param); ok {
+				position[axis] = value
+			} else {
+				continue
 			}
-			if instruction.Flags["y"] {
-				pt.CurrentY = 0
+			// the toolhead doesn't move -- only the workspace is shifted
+			pt.Offset[axis] = machine[axis] - position[axis]
+		}
+		pt.setPosition(position)
+	} else if instruction.IsHome() {
+		position := pt.Position()
+		homeAll := true
+		for _, param := range [3]string{"x", "y", "z"} {
+			if instruction.HasFlag(param) || instruction.HasParam(param) {
+				// flags present == only home some axes
+				homeAll = false
 			}
-			if instruction.Flags["z"] {
-				pt.CurrentZ = 0
+		}
+		for axis, param := range [3]string{"x", "y", "z"} {
+			if homeAll || instruction.HasFlag(param) || instruction.HasParam(param) {
+				// homing also clears any G92 workspace offset on the axis
+				pt.Offset[axis] = 0
+				position[axis] = pt.HomePosition[axis]
 			}
-		} else {
-			// no flags == home all axes
-			pt.CurrentX = 0
-			pt.CurrentY = 0
-			pt.CurrentZ = 0
 		}
+		pt.setPosition(position)
 	}
 }
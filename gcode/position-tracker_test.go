@@ -0,0 +1,76 @@
+package gcode
+
+import "testing"
+
+func trackPositions(pt *PositionTracker, lines []string) {
+	for _, command := range parseLines(lines) {
+		pt.TrackInstruction(command)
+	}
+}
+
+func Test_PositionTrackerRelativeMoves(t *testing.T) {
+	var pt PositionTracker
+	trackPositions(&pt, []string{
+		"G1 X10 Y10 Z0.2",
+		"G91",
+		"G1 X5 Z1",
+		"G1 Y-2",
+		"G90",
+		"G1 X1",
+	})
+	expectClose(t, "CurrentX", 1, pt.CurrentX)
+	expectClose(t, "CurrentY", 8, pt.CurrentY)
+	expectClose(t, "CurrentZ", 1.2, pt.CurrentZ)
+}
+
+func Test_PositionTrackerRelativeArc(t *testing.T) {
+	var pt PositionTracker
+	trackPositions(&pt, []string{
+		"G1 X10 Y0",
+		"G91",
+		"G3 X-10 Y10 I-10 J0",
+	})
+	expectClose(t, "CurrentX", 0, pt.CurrentX)
+	expectClose(t, "CurrentY", 10, pt.CurrentY)
+	if pt.LastArc == nil {
+		t.Fatal("expected LastArc to be set after a relative arc move")
+	}
+	expectClose(t, "Radius", 10, pt.LastArc.Radius)
+}
+
+func Test_PositionTrackerSetPosition(t *testing.T) {
+	var pt PositionTracker
+	trackPositions(&pt, []string{
+		"G1 X10 Y20 Z5",
+		"G92 X0 Z0",
+		"G1 X5",
+	})
+	expectClose(t, "CurrentX", 5, pt.CurrentX)
+	expectClose(t, "CurrentY", 20, pt.CurrentY)
+	expectClose(t, "CurrentZ", 0, pt.CurrentZ)
+	machine := pt.MachinePosition()
+	expectClose(t, "machine X", 15, machine[0])
+	expectClose(t, "machine Z", 5, machine[2])
+
+	// homing clears the offset of the homed axes only
+	pt.HomePosition = [3]float32{0, 0, 10}
+	pt.TrackInstruction(ParseLine("G28 X"))
+	expectClose(t, "CurrentX", 0, pt.CurrentX)
+	expectClose(t, "Offset X", 0, pt.Offset[0])
+	expectClose(t, "Offset Z", 5, pt.Offset[2])
+	pt.TrackInstruction(ParseLine("G28"))
+	expectClose(t, "CurrentZ", 10, pt.CurrentZ)
+	expectClose(t, "Offset Z", 0, pt.Offset[2])
+}
+
+func Test_PositioningModeSetsExtrusionMode(t *testing.T) {
+	et := trackCommands([]string{
+		"G91",
+		"G1 E1",
+		"G1 E1",
+		"M82",
+		"G92 E0",
+		"G1 E3",
+	})
+	expectTotalExtrusion(t, &et, 5)
+}
@@ -2,19 +2,51 @@ package gcode
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
-	"os"
+	"io/ioutil"
 )
 
 var ErrEarlyExit = errors.New("early exit")
 
 type LineCallback func(Command, int) error
 
+// LineReader passes every line of a G-code source to callback, in order.
+// Processors that make more than one pass over their input (e.g. a preflight
+// followed by output) call it once per pass, so every call must replay the
+// same lines -- see FileLineReader and BufferLines. Lines are parsed with
+// ParseLineBytes, so their Params and Flags maps are nil.
+type LineReader func(callback LineCallback) error
+
 // if callback returns an error, reading will stop before EOF
 
 func ReadByLine(path string, callback LineCallback) (err error) {
-	infile, openErr := os.Open(path)
+	infile, openErr := OpenInput(path)
+	if openErr != nil {
+		err = openErr
+		return
+	}
+	defer func() {
+		if closeErr := infile.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}()
+	return ReadByLineFrom(infile, callback)
+}
+
+// ReadByLineFrom is equivalent to ReadByLine, but reads from any io.Reader
+// (e.g. stdin, a pipe, or G-code held in memory).
+func ReadByLineFrom(input io.Reader, callback LineCallback) error {
+	return readByLine(input, func(line []byte) Command {
+		return ParseLine(string(line))
+	}, callback)
+}
+
+// ReadArgsByLine is equivalent to ReadByLine, but parses lines with ParseLineBytes
+// (i.e. without the Params and Flags maps) to avoid allocations.
+func ReadArgsByLine(path string, callback LineCallback) (err error) {
+	infile, openErr := OpenInput(path)
 	if openErr != nil {
 		err = openErr
 		return
@@ -24,7 +56,16 @@ func ReadByLine(path string, callback LineCallback) (err error) {
 			err = closeErr
 		}
 	}()
-	reader := bufio.NewReader(infile)
+	return ReadArgsByLineFrom(infile, callback)
+}
+
+// ReadArgsByLineFrom is equivalent to ReadByLineFrom, but parses lines with ParseLineBytes.
+func ReadArgsByLineFrom(input io.Reader, callback LineCallback) error {
+	return readByLine(input, ParseLineBytes, callback)
+}
+
+func readByLine(input io.Reader, parse func(line []byte) Command, callback LineCallback) error {
+	reader := bufio.NewReader(input)
 	lineNumber := 0
 	for {
 		line, isPrefix, readErr := reader.ReadLine()
@@ -32,8 +73,7 @@ func ReadByLine(path string, callback LineCallback) (err error) {
 			break
 		}
 		if readErr != nil {
-			err = readErr
-			return
+			return readErr
 		}
 		if isPrefix {
 			var fragment []byte
@@ -43,21 +83,38 @@ func ReadByLine(path string, callback LineCallback) (err error) {
 					break
 				}
 				if readErr != nil {
-					err = readErr
-					return
+					return readErr
 				}
 				line = append(line, fragment...)
 			}
 		}
-		gcode := ParseLine(string(line))
+		gcode := parse(line)
 		cbErr := callback(gcode, lineNumber)
 		if cbErr == ErrEarlyExit {
 			break
 		} else if cbErr != nil {
-			err = cbErr
-			return
+			return cbErr
 		}
 		lineNumber++
 	}
 	return nil
 }
+
+// FileLineReader returns a LineReader that re-reads the file at path on every pass.
+func FileLineReader(path string) LineReader {
+	return func(callback LineCallback) error {
+		return ReadArgsByLine(path, callback)
+	}
+}
+
+// BufferLines reads input to EOF, and returns a LineReader that replays it from
+// memory. Use it to make more than one pass over non-seekable input.
+func BufferLines(input io.Reader) (LineReader, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return func(callback LineCallback) error {
+		return ReadArgsByLineFrom(bytes.NewReader(data), callback)
+	}, nil
+}
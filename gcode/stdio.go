@@ -0,0 +1,44 @@
+package gcode
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// StdioPath can be passed in place of an input or output path
+// to read from stdin or write to stdout instead.
+const StdioPath = "-"
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// OpenInput opens the file at path for reading, or stdin if path is StdioPath.
+func OpenInput(path string) (io.ReadCloser, error) {
+	if path == StdioPath {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// CreateOutput creates the file at path for writing, or uses stdout if path is StdioPath.
+func CreateOutput(path string) (io.WriteCloser, error) {
+	if path == StdioPath {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// OpenLineReader returns a LineReader that can make multiple passes over
+// the file at path. Stdin can only be read once, so it is buffered in memory.
+func OpenLineReader(path string) (LineReader, error) {
+	if path == StdioPath {
+		return BufferLines(os.Stdin)
+	}
+	return FileLineReader(path), nil
+}
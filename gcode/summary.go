@@ -67,3 +67,45 @@ func GetTimeString(timeEstimate float32) string {
 	}
 	return fmt.Sprintf("%ds", seconds)
 }
+
+// EstimateDuration returns how long a command takes, in seconds, moving along its path at
+// the commanded (or current) feedrate. Moves of the extruder alone use the E distance.
+func EstimateDuration(command Command, position *PositionTracker, extrusion *ExtrusionTracker, flavor Flavor) float32 {
+	if seconds, ok := flavor.DwellDuration(command); ok {
+		return seconds
+	}
+	if !command.IsLinearMove() && !command.IsArcMove() {
+		return 0
+	}
+	feedrate := position.CurrentFeedrate
+	if f, ok := command.Param("f"); ok {
+		feedrate = f
+	}
+	if feedrate <= 0 {
+		return 0
+	}
+	current := position.Position()
+	target := position.Target(command)
+	var distance float64
+	if command.IsArcMove() {
+		arc, err := NewArcTo(command, position.Plane, current, target)
+		if err != nil {
+			return 0
+		}
+		distance = float64(arc.Length())
+	} else {
+		dx := float64(target[0] - current[0])
+		dy := float64(target[1] - current[1])
+		dz := float64(target[2] - current[2])
+		distance = math.Sqrt(dx*dx + dy*dy + dz*dz)
+	}
+	if distance == 0 {
+		if e, ok := command.Param("e"); ok {
+			if !extrusion.RelativeExtrusion {
+				e -= extrusion.CurrentExtrusionValue
+			}
+			distance = math.Abs(float64(e))
+		}
+	}
+	return float32(distance) / (feedrate / 60)
+}
@@ -1,44 +1,214 @@
 package gcode
 
+// Heater identifies the heater(s) targeted by a temperature command.
+type Heater int
+
+const (
+	HeaterUnknown Heater = iota
+	HeaterExtruder
+	HeaterBed
+	HeaterChamber
+	HeaterAll
+)
+
+// ToolTemperature holds the heater set-points of a single tool (hotend).
+type ToolTemperature struct {
+	Active  float32 // set-point while the tool is selected
+	Standby float32 // set-point while another tool is selected (0 == keep the active set-point)
+	Reached bool    // true once a wait command (e.g. M109/M116) has covered the current set-point
+}
+
 type TemperatureTracker struct {
-	Extruder float32
-	Bed      float32
-	Chamber  float32
+	Extruder       float32 // set-point of the active tool
+	Bed            float32
+	Chamber        float32
+	ActiveTool     int
+	Tools          map[int]ToolTemperature
+	BedReached     bool   // true once a wait command (e.g. M190/M116) has covered the current bed set-point
+	ChamberReached bool   // true once a wait command (e.g. M191/M116) has covered the current chamber set-point
+	SharedHotend   bool   // all tools feed a single hotend (e.g. Palette), so T parameters and tool changes are ignored
+	Flavor         Flavor // recognises tool changes and heater waits (nil == DefaultFlavor)
+}
+
+// Tool returns the set-points of the given tool.
+func (tt *TemperatureTracker) Tool(tool int) ToolTemperature {
+	return tt.Tools[tt.resolveTool(tool)]
+}
+
+// HeaterTemperature returns the temperature the given tool's heater is currently
+// set to, which is its standby set-point (if any) while another tool is selected.
+func (tt *TemperatureTracker) HeaterTemperature(tool int) float32 {
+	tool = tt.resolveTool(tool)
+	temps := tt.Tools[tool]
+	if tool != tt.ActiveTool && temps.Standby > 0 {
+		return temps.Standby
+	}
+	return temps.Active
+}
+
+func (tt *TemperatureTracker) resolveTool(tool int) int {
+	if tt.SharedHotend || tool < 0 {
+		return 0
+	}
+	return tool
+}
+
+// SetPointTool returns the tool whose extruder set-point instruction sets, if any.
+func (tt *TemperatureTracker) SetPointTool(instruction Command) (int, bool) {
+	switch instruction.Command {
+	case "M104", "M109":
+		return tt.getTool(instruction, "t"), true
+	case "M568":
+		return tt.getTool(instruction, "p"), true
+	case "SET_HEATER_TEMPERATURE":
+		name, _ := instruction.Extended.Get("heater")
+		if heater, tool := parseKlipperHeater(name); heater == HeaterExtruder {
+			return tt.resolveTool(tool), true
+		}
+	}
+	return -1, false
+}
+
+// getTool returns the tool targeted by a command's T (or P, for M568) parameter,
+// defaulting to the active tool
+func (tt *TemperatureTracker) getTool(instruction Command, param string) int {
+	if t, ok := instruction.Param(param); ok {
+		return tt.resolveTool(int(t + 0.5))
+	}
+	return tt.resolveTool(tt.ActiveTool)
+}
+
+func (tt *TemperatureTracker) updateTool(tool int, update func(temps *ToolTemperature)) {
+	if tt.Tools == nil {
+		tt.Tools = make(map[int]ToolTemperature)
+	}
+	temps := tt.Tools[tool]
+	update(&temps)
+	tt.Tools[tool] = temps
+	if tool == tt.resolveTool(tt.ActiveTool) {
+		tt.Extruder = temps.Active
+	}
 }
 
 func (tt *TemperatureTracker) TrackInstruction(instruction Command) {
 	if len(instruction.Command) == 0 {
 		return
 	}
-	if instruction.Command == "M104" {
-		if temp, ok := instruction.Params["s"]; ok {
-			tt.Extruder = temp
+	flavor := FlavorOrDefault(tt.Flavor)
+	if isToolChange, tool := flavor.IsToolChange(instruction); isToolChange {
+		if tool >= 0 && !tt.SharedHotend {
+			tt.ActiveTool = tool
+			if temps, ok := tt.Tools[tool]; ok {
+				tt.Extruder = temps.Active
+			}
+			// otherwise, keep reporting the last set-point (e.g. multi-material
+			// printers with a single hotend never set per-tool temperatures)
 		}
-	} else if instruction.Command == "M109" {
-		if temp, ok := instruction.Params["s"]; ok {
-			tt.Extruder = temp
-		} else if temp, ok = instruction.Params["r"]; ok {
-			tt.Extruder = temp
+		return
+	}
+	if instruction.Command == "M104" || instruction.Command == "M109" {
+		temp, ok := instruction.Param("s")
+		if !ok && instruction.Command == "M109" {
+			temp, ok = instruction.Param("r")
 		}
-	} else if instruction.Command == "M140" {
-		if temp, ok := instruction.Params["s"]; ok {
-			tt.Bed = temp
+		if ok {
+			tt.updateTool(tt.getTool(instruction, "t"), func(temps *ToolTemperature) {
+				temps.Active = temp
+				temps.Reached = false
+			})
 		}
-	} else if instruction.Command == "M190" {
-		if temp, ok := instruction.Params["s"]; ok {
-			tt.Bed = temp
-		} else if temp, ok = instruction.Params["r"]; ok {
+	} else if instruction.Command == "M568" {
+		// RepRapFirmware/Duet tool temperatures (e.g. M568 P1 S210 R160)
+		tt.updateTool(tt.getTool(instruction, "p"), func(temps *ToolTemperature) {
+			if active, ok := instruction.Param("s"); ok && active != temps.Active {
+				temps.Active = active
+				temps.Reached = false
+			}
+			if standby, ok := instruction.Param("r"); ok {
+				temps.Standby = standby
+			}
+		})
+	} else if instruction.IsExtended() {
+		tt.trackExtended(instruction)
+	} else if instruction.Command == "M140" || instruction.Command == "M190" {
+		temp, ok := instruction.Param("s")
+		if !ok && instruction.Command == "M190" {
+			temp, ok = instruction.Param("r")
+		}
+		if ok {
 			tt.Bed = temp
+			tt.BedReached = false
 		}
-	} else if instruction.Command == "M141" {
-		if temp, ok := instruction.Params["s"]; ok {
-			tt.Chamber = temp
+	} else if instruction.Command == "M141" || instruction.Command == "M191" {
+		temp, ok := instruction.Param("s")
+		if !ok && instruction.Command == "M191" {
+			temp, ok = instruction.Param("r")
 		}
-	} else if instruction.Command == "M191" {
-		if temp, ok := instruction.Params["s"]; ok {
+		if ok {
 			tt.Chamber = temp
-		} else if temp, ok = instruction.Params["r"]; ok {
+			tt.ChamberReached = false
+		}
+	}
+	// set-and-wait commands (e.g. M109 S210) have now updated the set-point
+	if isWait, heater, tool := flavor.IsTemperatureWait(instruction); isWait {
+		tt.trackWait(heater, tool)
+	}
+}
+
+// trackWait marks the set-points covered by a heater wait as reached
+func (tt *TemperatureTracker) trackWait(heater Heater, tool int) {
+	switch heater {
+	case HeaterExtruder:
+		if tool < 0 {
+			tool = tt.ActiveTool
+		}
+		tt.updateTool(tt.resolveTool(tool), func(temps *ToolTemperature) {
+			temps.Reached = true
+		})
+	case HeaterBed:
+		tt.BedReached = true
+	case HeaterChamber:
+		tt.ChamberReached = true
+	case HeaterAll:
+		for tool := range tt.Tools {
+			tt.updateTool(tool, func(temps *ToolTemperature) {
+				temps.Reached = true
+			})
+		}
+		tt.BedReached = true
+		tt.ChamberReached = true
+	}
+}
+
+// trackExtended handles the Klipper equivalents of the temperature commands
+func (tt *TemperatureTracker) trackExtended(instruction Command) {
+	switch instruction.Command {
+	case "SET_HEATER_TEMPERATURE":
+		// e.g. SET_HEATER_TEMPERATURE HEATER=extruder1 TARGET=210 (no TARGET == off)
+		name, _ := instruction.Extended.Get("heater")
+		temp, _ := instruction.Extended.Float("target")
+		switch heater, tool := parseKlipperHeater(name); heater {
+		case HeaterExtruder:
+			tt.updateTool(tt.resolveTool(tool), func(temps *ToolTemperature) {
+				temps.Active = temp
+				temps.Reached = false
+			})
+		case HeaterBed:
+			tt.Bed = temp
+			tt.BedReached = false
+		case HeaterChamber:
 			tt.Chamber = temp
+			tt.ChamberReached = false
+		}
+	case "TURN_OFF_HEATERS":
+		for tool := range tt.Tools {
+			tt.updateTool(tool, func(temps *ToolTemperature) {
+				temps.Active = 0
+				temps.Standby = 0
+			})
 		}
+		tt.Extruder = 0
+		tt.Bed = 0
+		tt.Chamber = 0
 	}
 }
@@ -0,0 +1,107 @@
+package gcode
+
+import "testing"
+
+func trackTemperatures(tt *TemperatureTracker, lines []string) {
+	for _, command := range parseLines(lines) {
+		tt.TrackInstruction(command)
+	}
+}
+
+func expectTemperature(t *testing.T, name string, expected, actual float32) {
+	if expected != actual {
+		t.Errorf("expected %s = %f, got %f", name, expected, actual)
+	}
+}
+
+func Test_TemperaturePerTool(t *testing.T) {
+	var tt TemperatureTracker
+	trackTemperatures(&tt, []string{
+		"M104 T1 S200",
+		"M109 T0 S215",
+	})
+	expectTemperature(t, "Extruder", 215, tt.Extruder)
+	if !tt.Tool(0).Reached || tt.Tool(1).Reached {
+		t.Error("expected only T0 to have reached its set-point")
+	}
+	tt.TrackInstruction(ParseLine("T1"))
+	expectTemperature(t, "Extruder", 200, tt.Extruder)
+	// no T parameter == active tool
+	tt.TrackInstruction(ParseLine("M104 S205"))
+	expectTemperature(t, "T1 Active", 205, tt.Tool(1).Active)
+	expectTemperature(t, "T0 Active", 215, tt.Tool(0).Active)
+	if tool, ok := tt.SetPointTool(ParseLine("M104 T0 S190")); !ok || tool != 0 {
+		t.Errorf("expected M104 T0 to set T0, got %d", tool)
+	}
+	if tool, ok := tt.SetPointTool(ParseLine("M109 S190")); !ok || tool != 1 {
+		t.Errorf("expected M109 to set the active tool, got %d", tool)
+	}
+	tt.TrackInstruction(ParseLine("M116"))
+	if !tt.Tool(1).Reached {
+		t.Error("expected M116 to wait for all tools")
+	}
+}
+
+func Test_TemperatureStandby(t *testing.T) {
+	var tt TemperatureTracker
+	trackTemperatures(&tt, []string{
+		"M568 P0 S210 R150",
+		"M568 P1 S220 R160",
+		"T1",
+	})
+	expectTemperature(t, "Extruder", 220, tt.Extruder)
+	expectTemperature(t, "T0 heater", 150, tt.HeaterTemperature(0))
+	expectTemperature(t, "T1 heater", 220, tt.HeaterTemperature(1))
+}
+
+func Test_TemperatureSharedHotend(t *testing.T) {
+	tt := TemperatureTracker{SharedHotend: true}
+	trackTemperatures(&tt, []string{
+		"M104 S210",
+		"T3",
+		"M104 T2 S220",
+	})
+	expectTemperature(t, "Extruder", 220, tt.Extruder)
+}
+
+func Test_TemperatureKlipper(t *testing.T) {
+	var tt TemperatureTracker
+	trackTemperatures(&tt, []string{
+		"SET_HEATER_TEMPERATURE HEATER=extruder1 TARGET=230",
+		"SET_HEATER_TEMPERATURE HEATER=heater_bed TARGET=60",
+		"TEMPERATURE_WAIT SENSOR=heater_bed MINIMUM=58",
+		"ACTIVATE_EXTRUDER EXTRUDER=extruder1",
+	})
+	expectTemperature(t, "Extruder", 230, tt.Extruder)
+	expectTemperature(t, "Bed", 60, tt.Bed)
+	if tt.ActiveTool != 1 || !tt.BedReached || tt.Tool(1).Reached {
+		t.Errorf("unexpected state %+v", tt)
+	}
+	tt.TrackInstruction(ParseLine("TEMPERATURE_WAIT SENSOR=extruder1 MINIMUM=225"))
+	if !tt.Tool(1).Reached {
+		t.Error("expected T1 to have reached its set-point")
+	}
+}
+
+func Test_TemperatureFlavor(t *testing.T) {
+	tt := TemperatureTracker{Flavor: Sailfish{}}
+	trackTemperatures(&tt, []string{
+		"M104 S220 T1",
+		"M135 T1",
+		"M133 T1",
+	})
+	expectTemperature(t, "Extruder", 220, tt.Extruder)
+	if tt.ActiveTool != 1 || !tt.Tool(1).Reached {
+		t.Errorf("unexpected state %+v", tt)
+	}
+
+	tt = TemperatureTracker{Flavor: Bambu{}}
+	trackTemperatures(&tt, []string{
+		"M104 S220",
+		"T2",
+		"T255",
+	})
+	if tt.ActiveTool != 2 {
+		t.Errorf("expected T255 not to change tools, got active tool %d", tt.ActiveTool)
+	}
+}
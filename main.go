@@ -11,6 +11,7 @@ import (
 	"mosaicmfg.com/ps-postprocess/msf"
 	"mosaicmfg.com/ps-postprocess/ptp"
 	"mosaicmfg.com/ps-postprocess/sequences"
+	"mosaicmfg.com/ps-postprocess/simulator"
 	"mosaicmfg.com/ps-postprocess/ultimaker"
 	"mosaicmfg.com/ps-postprocess/zeros"
 )
@@ -23,6 +24,8 @@ func main() {
 	switch argv[0] {
 	case "msf":
 		msf.ConvertForPalette(argv[1:])
+	case "msf-inspect":
+		msf.Inspect(argv[1:])
 	case "ptp":
 		ptp.GenerateToolpath(argv[1:])
 	case "comments":
@@ -37,6 +40,8 @@ func main() {
 		sequences.ConvertSequences(argv[1:])
 	case "firstlayer":
 		firstlayer.UseFirstLayerSettings(argv[1:])
+	case "simulate":
+		simulator.Run(argv[1:])
 	default:
 		log.Fatalln(fmt.Sprintf("unknown command '%s'", argv[0]))
 	}
@@ -0,0 +1,66 @@
+package msf
+
+import (
+	"math"
+	"strings"
+)
+
+// hexToLab converts an sRGB hex color (e.g. "ff8000") to CIELAB, using a D65 white point
+func hexToLab(color string) (l, a, b float64, ok bool) {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) != 6 {
+		return 0, 0, 0, false
+	}
+	rgb, err := hexStringToInt(color)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	// sRGB to linear RGB
+	linearize := func(channel uint) float64 {
+		value := float64(channel) / 255
+		if value <= 0.04045 {
+			return value / 12.92
+		}
+		return math.Pow((value+0.055)/1.055, 2.4)
+	}
+	red := linearize((rgb >> 16) & 0xff)
+	green := linearize((rgb >> 8) & 0xff)
+	blue := linearize(rgb & 0xff)
+
+	// linear RGB to XYZ, normalized to the white point
+	x := (0.4124*red + 0.3576*green + 0.1805*blue) / 0.95047
+	y := 0.2126*red + 0.7152*green + 0.0722*blue
+	z := (0.0193*red + 0.1192*green + 0.9505*blue) / 1.08883
+
+	// XYZ to Lab
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz), true
+}
+
+// suggestTransitionLength estimates the transition length needed to go from one color
+// to another, based on how different they look. Covering a dark color with a lighter
+// one takes longer than the reverse.
+func suggestTransitionLength(fromColor, toColor string) float32 {
+	fromL, fromA, fromB, fromOK := hexToLab(fromColor)
+	toL, toA, toB, toOK := hexToLab(toColor)
+	if !fromOK || !toOK {
+		// unknown colors -- be conservative
+		return MaxSuggestedTransitionLength
+	}
+
+	// CIE76 color difference, where black to white is 100
+	distance := math.Sqrt((toL-fromL)*(toL-fromL) + (toA-fromA)*(toA-fromA) + (toB-fromB)*(toB-fromB))
+	ratio := distance / SuggestedTransitionColorDistance
+	if toL > fromL {
+		ratio *= 1 + (toL-fromL)/100
+	}
+	ratio = math.Min(ratio, 1)
+	return MinSuggestedTransitionLength + float32(ratio)*(MaxSuggestedTransitionLength-MinSuggestedTransitionLength)
+}
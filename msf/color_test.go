@@ -0,0 +1,56 @@
+package msf
+
+import "testing"
+
+func Test_suggestTransitionLength(t *testing.T) {
+	if length := suggestTransitionLength("ff0000", "ff0000"); length != MinSuggestedTransitionLength {
+		t.Fatalf("expected %f mm between identical colors, got %f", MinSuggestedTransitionLength, length)
+	}
+	if length := suggestTransitionLength("000000", "ffffff"); length != MaxSuggestedTransitionLength {
+		t.Fatalf("expected %f mm from black to white, got %f", MaxSuggestedTransitionLength, length)
+	}
+	if length := suggestTransitionLength("", "ffffff"); length != MaxSuggestedTransitionLength {
+		t.Fatalf("expected %f mm for an unknown color, got %f", MaxSuggestedTransitionLength, length)
+	}
+
+	// covering a dark color with a light one takes longer than the reverse
+	darkToLight := suggestTransitionLength("#202060", "#f0e040")
+	lightToDark := suggestTransitionLength("#f0e040", "#202060")
+	if darkToLight <= lightToDark {
+		t.Fatalf("expected dark-to-light (%f mm) to be longer than light-to-dark (%f mm)", darkToLight, lightToDark)
+	}
+	// similar colors need shorter transitions than different ones
+	if similar := suggestTransitionLength("ff0000", "e00010"); similar >= lightToDark {
+		t.Fatalf("expected similar colors (%f mm) to need less than different ones (%f mm)", similar, lightToDark)
+	}
+}
+
+func Test_TransitionLengthsAndTargets(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.MaterialMeta[1].Color = "ffffff"
+	palette.TransitionLengths[1][0] = 0
+	sixty, zero := float32(60), float32(0)
+	palette.TransitionTargets = [][]*float32{{nil, &zero}, {&sixty, nil}}
+
+	if length := palette.GetTransitionLength(0, 1); length != 30 {
+		t.Fatalf("expected the configured 30 mm transition, got %f", length)
+	}
+	if length := palette.GetTransitionLength(1, 0); length != MaxSuggestedTransitionLength {
+		t.Fatalf("expected a suggested %f mm transition, got %f", MaxSuggestedTransitionLength, length)
+	}
+	if target := palette.GetTransitionTarget(1, 0); target != 60 {
+		t.Fatalf("expected a 60%% target, got %f", target)
+	}
+	if target := palette.GetTransitionTarget(0, 1); target != 0 {
+		t.Fatalf("expected a 0%% target, got %f", target)
+	}
+	if target := palette.GetTransitionTarget(1, 1); target != palette.TransitionTarget {
+		t.Fatalf("expected the default %f%% target, got %f", palette.TransitionTarget, target)
+	}
+
+	// the slicer's tower has a fixed purge, so its lengths aren't suggested
+	palette.TransitionMethod = TransitionTower
+	if length := palette.GetTransitionLength(1, 0); length != 0 {
+		t.Fatalf("expected no suggested transition for a slicer tower, got %f", length)
+	}
+}
@@ -2,6 +2,20 @@ package msf
 
 import "mosaicmfg.com/ps-postprocess/gcode"
 
+// useRelativeXYZ rewrites the absolute X/Y/Z parameters of a generated move
+// as distances from the current position if the printer is in relative mode (G91)
+func useRelativeXYZ(state *State, command *gcode.Command) {
+	if !state.XYZF.RelativeXYZ {
+		return
+	}
+	current := state.XYZF.Position()
+	for axis, param := range [3]string{"x", "y", "z"} {
+		if value, ok := command.Param(param); ok {
+			command.SetParam(param, value-current[axis])
+		}
+	}
+}
+
 func resetEAxis(state *State) string {
 	if state.E.RelativeExtrusion {
 		// G92 not needed in relative mode
@@ -44,14 +58,14 @@ func getRetract(state *State, distance, feedrate float32) string {
 		},
 		Comment: "retract",
 	}
-	state.TimeEstimate += estimatePurgeTime(distance, feedrate)
+	state.TimeEstimate += getTimeEstimate(retract, state)
 	state.XYZF.TrackInstruction(retract)
 	state.E.TrackInstruction(retract)
 	return retract.String() + EOL
 }
 
-func getFirmwareRetract() string {
-	return "G10" + EOL
+func getFirmwareRetract(state *State) string {
+	return state.Palette.GetFlavor().FirmwareRetract() + EOL
 }
 
 func getRestart(state *State, distance, feedrate float32) string {
@@ -74,14 +88,14 @@ func getRestart(state *State, distance, feedrate float32) string {
 		},
 		Comment: "unretract",
 	}
-	state.TimeEstimate += estimatePurgeTime(distance, feedrate)
+	state.TimeEstimate += getTimeEstimate(restart, state)
 	state.XYZF.TrackInstruction(restart)
 	state.E.TrackInstruction(restart)
 	return restart.String() + EOL
 }
 
-func getFirmwareRestart() string {
-	return "G11" + EOL
+func getFirmwareRestart(state *State) string {
+	return state.Palette.GetFlavor().FirmwareUnretract() + EOL
 }
 
 func getFeedrateAdjust(state *State, feedrate float32) string {
@@ -110,7 +124,8 @@ func getZTravel(state *State, toZ float32, comment string) string {
 			"f": state.Palette.TravelSpeedZ,
 		},
 	}
-	state.TimeEstimate += estimateZMoveTime(state.XYZF.CurrentZ, toZ, state.Palette.TravelSpeedZ)
+	useRelativeXYZ(state, &zTravel)
+	state.TimeEstimate += getTimeEstimate(zTravel, state)
 	state.XYZF.TrackInstruction(zTravel)
 	return zTravel.String() + EOL
 }
@@ -128,7 +143,8 @@ func getXYTravel(state *State, toX, toY, feedrate float32, comment string) strin
 			"f": feedrate,
 		},
 	}
-	state.TimeEstimate += estimateMoveTime(state.XYZF.CurrentX, state.XYZF.CurrentY, toX, toY, feedrate)
+	useRelativeXYZ(state, &xyTravel)
+	state.TimeEstimate += getTimeEstimate(xyTravel, state)
 	state.XYZF.TrackInstruction(xyTravel)
 	return xyTravel.String() + EOL
 }
@@ -148,7 +164,7 @@ func getPurge(state *State, distance, feedrate float32) string {
 			"f": feedrate,
 		},
 	}
-	state.TimeEstimate += estimatePurgeTime(distance, feedrate)
+	state.TimeEstimate += getTimeEstimate(purge, state)
 	state.E.TrackInstruction(purge)
 	return purge.String() + EOL
 }
@@ -167,7 +183,8 @@ func getXYExtrusion(state *State, toX, toY, distance, feedrate float32) string {
 			"f": feedrate,
 		},
 	}
-	state.TimeEstimate += estimateMoveTime(state.XYZF.CurrentX, state.XYZF.CurrentY, toX, toY, feedrate)
+	useRelativeXYZ(state, &purge)
+	state.TimeEstimate += getTimeEstimate(purge, state)
 	state.XYZF.TrackInstruction(purge)
 	state.E.TrackInstruction(purge)
 	return purge.String() + EOL
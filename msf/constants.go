@@ -19,6 +19,7 @@ const (
 	TypeP2      Type = "palette-2"
 	TypeP3      Type = "palette-3"
 	TypeElement Type = "element"
+	TypeManual  Type = "manual" // no Palette -- pause for the user to swap filament at each tool change
 )
 
 type Model string
@@ -44,6 +45,56 @@ const (
 	TransitionTower TransitionMethod = 3 // pre-generated by PrusaSlicer
 )
 
+type PieceRepair string
+
+const (
+	PieceRepairNone        PieceRepair = ""             // fail if a piece is too short
+	PieceRepairExtendPurge PieceRepair = "extend-purge" // lengthen the piece by extending the purge that ends it
+	PieceRepairMerge       PieceRepair = "merge"        // print a too-short piece's features with the previous input
+)
+
+type PurgeLocationRotation string
+
+const (
+	PurgeLocationRoundRobin PurgeLocationRotation = ""           // use each side transition location in turn
+	PurgeLocationLeastUsed  PurgeLocationRotation = "least-used" // use the location with the least purged filament
+)
+
+type SpliceRisk string
+
+const (
+	SpliceRiskLow    SpliceRisk = "low"
+	SpliceRiskMedium SpliceRisk = "medium"
+	SpliceRiskHigh   SpliceRisk = "high"
+)
+
+type UsageCategory string
+
+const (
+	UsageModel           UsageCategory = "model"
+	UsageTower           UsageCategory = "tower"
+	UsageSideTransitions UsageCategory = "sideTransitions"
+	UsageBrimRaft        UsageCategory = "brimRaft"
+	UsageFirmwarePurge   UsageCategory = "firmwarePurge"
+	UsageUnprinted       UsageCategory = "unprinted" // made past the end of the print, to reach the extruder
+)
+
+type TowerShape string
+
+const (
+	TowerShapeRectangle TowerShape = "rectangle"
+	TowerShapeCylinder  TowerShape = "cylinder"
+)
+
+type TowerInfill string
+
+const (
+	TowerInfillDiagonal    TowerInfill = "diagonal"    // 45-degree zig-zag, mirrored every other layer
+	TowerInfillRectilinear TowerInfill = "rectilinear" // lines parallel to the tower's long axis
+	TowerInfillConcentric  TowerInfill = "concentric"  // loops following the tower's outline
+	TowerInfillSerpentine  TowerInfill = "serpentine"  // rectilinear lines joined into one continuous path
+)
+
 const (
 	MinSpliceLength        = float32(80)
 	MinSpliceLengthElement = float32(0)
@@ -56,8 +107,19 @@ const (
 	MinFirstSpliceLengthElement = MinSpliceLengthElement
 )
 
+const (
+	MinSuggestedTransitionLength     = float32(40)  // mm, for materials of the same color
+	MaxSuggestedTransitionLength     = float32(150) // mm
+	SuggestedTransitionColorDistance = 150          // CIE76 color difference that needs the maximum transition length
+)
+
 const BowdenDefault = float32(150)
 
+const DefaultExtruderGearDistance = float32(50) // mm of filament path from the extruder gears to the nozzle
+const DefaultMeltZoneLength = float32(20)       // mm of filament path from the top of the melt zone to the nozzle
+const SpliceStressMediumPasses = 3              // a splice pulled back once through the gears or melt zone
+const SpliceStressHighPasses = 7                // a splice pulled back three times
+
 const CutterToScrollWheel = float32(760)
 
 const PingExtrusionCounts = 600 // target extrusion between ping sequence pauses, in scroll wheel counts
@@ -68,3 +130,6 @@ const PingMinSpacing = 350      // minimum distance (extrusion) between ping sta
 
 const TowerPerimeterThreshold = 0.2 // tower layers 20% dense or less will be given perimeters
 const TowerPerimeterCount = 2       // generate this many tower perimeters
+const TowerPrintClearance = 2       // minimum gap between the tower and the print, in mm
+const TowerPlacementStep = 2        // grid spacing when searching for a tower position, in mm
+const TowerArcSegmentLength = 1     // maximum length of the segments approximating a cylindrical tower, in mm
@@ -1,13 +1,21 @@
 package msf
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 
+	"mosaicmfg.com/ps-postprocess/gcode"
 	"mosaicmfg.com/ps-postprocess/sequences"
 )
 
+var ErrNoPalette = errors.New("print only uses one input, so does not need Palette")
+
 // explaining outpath and msfpath:
 // - P1:            outpath == *.msf.gcode,  msfpath == *.msf
 // - P2 accessory:  outpath == *.maf.gcode,  msfpath == *.maf
@@ -15,10 +23,16 @@ import (
 // - P3 accessory:  outpath == *.gcode,      msfpath == *.json
 // - P3 connected:  outpath == *.gcode,      msfpath == *.json
 // - Element:       outpath == *.gcode,      msfpath == *.json
+// - Manual swaps:  outpath == *.gcode,      msfpath == *.json (swap list)
 
 func ConvertForPalette(argv []string) {
 	argc := len(argv)
 
+	if argc > 0 && argv[0] == "plan" {
+		PlanForPalette(argv[1:])
+		return
+	}
+
 	if argc < 6 {
 		log.Fatalln("expected 6 command-line arguments")
 	}
@@ -29,10 +43,25 @@ func ConvertForPalette(argv []string) {
 	localsPath := argv[4]            // JSON-stringified locals
 	perExtruderLocalsPath := argv[5] // JSON-stringified locals
 
+	// optional reports, e.g. --repairs=repairs.json
+	options := flag.NewFlagSet("msf", flag.ExitOnError)
+	repairsPath := options.String("repairs", "", "write a JSON report of piece repairs to this path")
+	spliceStressPath := options.String("splice-stress", "", "analyze splice stress and write a JSON report to this path")
+	usagePath := options.String("usage", "", "write a JSON report of filament usage by drive to this path")
+	if err := options.Parse(argv[6:]); err != nil {
+		log.Fatalln(err)
+	}
+	if options.NArg() > 0 {
+		log.Fatalln(fmt.Sprintf("unexpected argument '%s'", options.Arg(0)))
+	}
+
 	palette, err := LoadPaletteFromFile(palettepath)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if *spliceStressPath != "" {
+		palette.AnalyzeSpliceStress = true
+	}
 
 	locals := sequences.NewLocals()
 	if err := locals.LoadGlobal(localsPath); err != nil {
@@ -49,11 +78,15 @@ func ConvertForPalette(argv []string) {
 	// - splice lengths -- check early if any splices will be too short
 	// - number of pings
 	// - bounding box
-	preflightResults, err := preflight(inpath, &palette)
+	readLines, err := gcode.OpenLineReader(inpath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	preflightResults, err := _preflight(readLines, &palette)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	if preflightResults.totalDrivesUsed() <= 1 && !palette.TreatAsSingleMaterial {
+	if !preflightResults.needsPalette(&palette) {
 		fmt.Println("NO_PALETTE")
 		os.Exit(0)
 	}
@@ -67,8 +100,67 @@ func ConvertForPalette(argv []string) {
 	// - accessory pings (two pauses with precise-ish amount of E between them)
 	// - connected pings
 	// - print summary in footer
-	err = paletteOutput(inpath, outpath, msfpath, &palette, &preflightResults, locals)
+	outfile, err := gcode.CreateOutput(outpath)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	msfOut, err := paletteOutput(readLines, outfile, &palette, &preflightResults, locals)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := outfile.Close(); err != nil {
+		log.Fatalln(err)
+	}
+	if palette.Type != TypeP2 || !palette.ConnectedMode {
+		// everything but .mcf.gcode has a separate MSF file
+		msfStr, err := msfOut.CreateMSF()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := ioutil.WriteFile(msfpath, []byte(msfStr), 0644); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *repairsPath != "" {
+		bytes, err := json.MarshalIndent(msfOut.Repairs, "", "  ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := ioutil.WriteFile(*repairsPath, bytes, 0644); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *spliceStressPath != "" {
+		bytes, err := json.MarshalIndent(msfOut.SpliceStress, "", "  ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := ioutil.WriteFile(*spliceStressPath, bytes, 0644); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *usagePath != "" {
+		bytes, err := json.MarshalIndent(msfOut.Usage, "", "  ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := ioutil.WriteFile(*usagePath, bytes, 0644); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// ConvertForPaletteStream writes the G-code read by readLines to output with
+// all modifications for Palette applied, and returns the MSF data for the print.
+// readLines is called twice (once for preflight), so it must be able to replay its
+// input. If the print does not need Palette, ErrNoPalette is returned and nothing is written.
+func ConvertForPaletteStream(readLines gcode.LineReader, output io.Writer, palette *Palette, locals sequences.Locals) (MSF, error) {
+	preflightResults, err := _preflight(readLines, palette)
+	if err != nil {
+		return MSF{}, err
+	}
+	if !preflightResults.needsPalette(palette) {
+		return MSF{}, ErrNoPalette
+	}
+	return paletteOutput(readLines, output, palette, &preflightResults, locals)
 }
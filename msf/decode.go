@@ -0,0 +1,587 @@
+package msf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// drive number used by MSF1 to mark a ping in the splice list
+const msf1PingDrive = 0x64
+
+// LoadMSFFromFile reads an existing .msf, .maf, .mafx, .mcf.gcode or
+// Element/Palette 3 .json file back into an MSF value.
+func LoadMSFFromFile(path string) (MSF, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return MSF{}, err
+	}
+	return ParseMSF(string(bytes))
+}
+
+// ParseMSF decodes the contents of any MSF version. The format is detected
+// from the content rather than the file extension, as .json is shared by
+// Palette 3 and Element, and .mcf.gcode embeds MSF2 in a G-code file.
+//
+// The returned MSF has its own Palette, populated only with what the file
+// records (material metadata, algorithms, printer ID, etc.).
+func ParseMSF(content string) (MSF, error) {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "MSF") {
+		return parseMSF1(trimmed)
+	}
+	if strings.HasPrefix(trimmed, "{") {
+		return parseMSFJson([]byte(trimmed))
+	}
+	return parseMSF2(content)
+}
+
+func newDecodedMSF(palette *Palette) MSF {
+	palette.MaterialMeta = make([]Material, palette.GetInputCount())
+	return NewMSF(palette)
+}
+
+func (msf *MSF) addDecodedSplice(drive int, length float32) error {
+	if drive < 0 || drive >= len(msf.DrivesUsed) {
+		return fmt.Errorf("splice uses drive %d, but %s only has %d inputs", drive+1, msf.Palette.ProductName(), len(msf.DrivesUsed))
+	}
+	msf.DrivesUsed[drive] = true
+	msf.SpliceList = append(msf.SpliceList, Splice{
+		Drive:  drive,
+		Length: length,
+	})
+	return nil
+}
+
+func (msf *MSF) addDecodedHotSwap(hotSwap HotSwap) error {
+	for _, drive := range []int{hotSwap.FromDrive, hotSwap.ToDrive} {
+		if drive < 0 || drive >= len(msf.DrivesUsed) {
+			return fmt.Errorf("hot swap uses drive %d, but %s only has %d inputs", drive+1, msf.Palette.ProductName(), len(msf.DrivesUsed))
+		}
+	}
+	msf.DrivesUsed[hotSwap.ToDrive] = true
+	msf.HotSwapList = append(msf.HotSwapList, hotSwap)
+	return nil
+}
+
+// parseMSFHotSwap decodes the hex-encoded fields shared by MSF1 and MSF2 hot swaps
+func parseMSFHotSwap(fromDrive, toDrive, length string) (HotSwap, error) {
+	from, err := hexStringToInt(fromDrive)
+	if err != nil {
+		return HotSwap{}, err
+	}
+	to, err := hexStringToInt(toDrive)
+	if err != nil {
+		return HotSwap{}, err
+	}
+	swapLength, err := hexStringToFloat(length)
+	if err != nil {
+		return HotSwap{}, err
+	}
+	return HotSwap{
+		FromDrive: int(from),
+		ToDrive:   int(to),
+		Length:    swapLength,
+	}, nil
+}
+
+// splitMSF1Tuple splits "(a,b,c)" into its fields
+func splitMSF1Tuple(line string) ([]string, bool) {
+	if !strings.HasPrefix(line, "(") || !strings.HasSuffix(line, ")") {
+		return nil, false
+	}
+	return strings.Split(line[1:len(line)-1], ","), true
+}
+
+func parseMSF1(content string) (MSF, error) {
+	palette := &Palette{
+		Type:  TypeP1,
+		Model: ModelP,
+	}
+	msf := newDecodedMSF(palette)
+
+	var spliceCount, pingCount, hotSwapCount uint
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "MSF") {
+			continue
+		}
+		if fields, ok := splitMSF1Tuple(line); ok {
+			if len(fields) == 4 {
+				// algorithm: (<ingoing><outgoing>,<heat>,<compression>,<reverse>)
+				if len(fields[0]) != 2 {
+					return msf, fmt.Errorf("invalid MSF1 algorithm: %s", line)
+				}
+				heat, err := hexStringToFloat(fields[1])
+				if err != nil {
+					return msf, err
+				}
+				compression, err := hexStringToFloat(fields[2])
+				if err != nil {
+					return msf, err
+				}
+				palette.SpliceSettings = append(palette.SpliceSettings, SpliceSettings{
+					IngoingID:         fields[0][:1],
+					OutgoingID:        fields[0][1:],
+					HeatFactor:        heat,
+					CompressionFactor: compression,
+					Reverse:           fields[3] == "1",
+				})
+				continue
+			}
+			if len(fields) == 3 {
+				// hot swap: (<from drive>,<to drive>,<length>)
+				hotSwap, err := parseMSFHotSwap(fields[0], fields[1], fields[2])
+				if err != nil {
+					return msf, err
+				}
+				if err := msf.addDecodedHotSwap(hotSwap); err != nil {
+					return msf, err
+				}
+				continue
+			}
+			if len(fields) != 2 {
+				return msf, fmt.Errorf("invalid MSF1 line: %s", line)
+			}
+			drive, err := hexStringToInt(fields[0])
+			if err != nil {
+				return msf, err
+			}
+			length, err := hexStringToFloat(fields[1])
+			if err != nil {
+				return msf, err
+			}
+			if drive == msf1PingDrive {
+				msf.AddPing(length)
+			} else if err := msf.addDecodedSplice(int(drive), length); err != nil {
+				return msf, err
+			}
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return msf, fmt.Errorf("invalid MSF1 line: %s", line)
+		}
+		key, value := line[:colon], line[colon+1:]
+		var err error
+		switch key {
+		case "cu":
+			// <index><name>; for each drive
+			materials := strings.Split(strings.TrimSuffix(value, ";"), ";")
+			for drive := 0; drive < len(materials) && drive < len(palette.MaterialMeta); drive++ {
+				if len(materials[drive]) == 0 {
+					continue
+				}
+				index, err := strconv.Atoi(materials[drive][:1])
+				if err != nil {
+					return msf, err
+				}
+				palette.MaterialMeta[drive].Index = index
+				palette.MaterialMeta[drive].Name = materials[drive][1:]
+			}
+		case "ppm":
+			palette.pulsesPerMM, err = hexStringToFloat(value)
+		case "lo":
+			var loadingOffset uint
+			loadingOffset, err = hexStringToInt(value)
+			palette.LoadingOffset = int(loadingOffset)
+		case "ns":
+			spliceCount, err = hexStringToInt(value)
+		case "np":
+			pingCount, err = hexStringToInt(value)
+		case "nh":
+			hotSwapCount, err = hexStringToInt(value)
+		}
+		if err != nil {
+			return msf, err
+		}
+	}
+
+	if int(spliceCount) != len(msf.SpliceList) {
+		return msf, fmt.Errorf("MSF1 header lists %d splices, but %d were found", spliceCount, len(msf.SpliceList))
+	}
+	if int(pingCount) != len(msf.PingList) {
+		return msf, fmt.Errorf("MSF1 header lists %d pings, but %d were found", pingCount, len(msf.PingList))
+	}
+	if int(hotSwapCount) != len(msf.HotSwapList) {
+		return msf, fmt.Errorf("MSF1 header lists %d hot swaps, but %d were found", hotSwapCount, len(msf.HotSwapList))
+	}
+	return msf, nil
+}
+
+// parseMSF2 decodes a .maf file, or the O-commands of a .mcf.gcode file
+// (where connected pings are interleaved with the G-code itself)
+func parseMSF2(content string) (MSF, error) {
+	palette := &Palette{
+		Type:  TypeP2,
+		Model: ModelP2,
+	}
+	msf := newDecodedMSF(palette)
+
+	versionSeen := false
+	var spliceCount, pingCount, algorithmCount, hotSwapCount uint
+	for _, line := range strings.Split(content, "\n") {
+		if semicolon := strings.Index(line, ";"); semicolon >= 0 {
+			line = line[:semicolon]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || len(fields[0]) < 2 || fields[0][0] != 'O' {
+			continue
+		}
+		command := fields[0]
+		args := make([]string, 0, len(fields)-1)
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "D") {
+				return msf, fmt.Errorf("invalid MSF2 line: %s", line)
+			}
+			args = append(args, field[1:])
+		}
+		if command != "O9" && len(args) == 0 {
+			return msf, fmt.Errorf("invalid MSF2 line: %s", line)
+		}
+
+		switch command {
+		case "O1":
+			// only present in the header of .mcf.gcode files
+			palette.ConnectedMode = true
+			palette.Filename = args[0]
+		case "O21":
+			versionSeen = true
+		case "O22":
+			palette.PrinterID = args[0]
+		case "O26", "O27", "O28", "O29":
+			count, err := hexStringToInt(args[0])
+			if err != nil {
+				return msf, err
+			}
+			switch command {
+			case "O26":
+				spliceCount = count
+			case "O27":
+				pingCount = count
+			case "O28":
+				algorithmCount = count
+			case "O29":
+				hotSwapCount = count
+			}
+		case "O25":
+			for drive := 0; drive < len(args) && drive < len(palette.MaterialMeta); drive++ {
+				material := args[drive]
+				if len(material) == 0 {
+					continue
+				}
+				index, err := hexStringToInt(material[:1])
+				if err != nil {
+					return msf, err
+				}
+				if index == 0 {
+					continue
+				}
+				if len(material) < 7 {
+					return msf, fmt.Errorf("invalid MSF2 material: %s", material)
+				}
+				palette.MaterialMeta[drive].Index = int(index)
+				palette.MaterialMeta[drive].Color = material[1:7]
+				palette.MaterialMeta[drive].Name = material[7:]
+			}
+		case "O30":
+			if len(args) != 2 {
+				return msf, fmt.Errorf("invalid MSF2 splice: %s", line)
+			}
+			drive, err := hexStringToInt(args[0])
+			if err != nil {
+				return msf, err
+			}
+			length, err := hexStringToFloat(args[1])
+			if err != nil {
+				return msf, err
+			}
+			if err := msf.addDecodedSplice(int(drive), length); err != nil {
+				return msf, err
+			}
+		case "O31":
+			length, err := hexStringToFloat(args[0])
+			if err != nil {
+				return msf, err
+			}
+			var extrusion float32
+			if len(args) > 1 {
+				if extrusion, err = hexStringToFloat(args[1]); err != nil {
+					return msf, err
+				}
+			}
+			msf.AddPingWithExtrusion(length, extrusion)
+		case "O33":
+			if len(args) != 3 {
+				return msf, fmt.Errorf("invalid MSF2 hot swap: %s", line)
+			}
+			hotSwap, err := parseMSFHotSwap(args[0], args[1], args[2])
+			if err != nil {
+				return msf, err
+			}
+			if err := msf.addDecodedHotSwap(hotSwap); err != nil {
+				return msf, err
+			}
+		case "O32":
+			if len(args) != 4 || len(args[0]) != 2 {
+				return msf, fmt.Errorf("invalid MSF2 algorithm: %s", line)
+			}
+			factors := make([]int16, 3)
+			for i := range factors {
+				factor, err := hexStringToInt16(args[i+1])
+				if err != nil {
+					return msf, err
+				}
+				factors[i] = factor
+			}
+			ingoing, err := hexStringToInt(args[0][:1])
+			if err != nil {
+				return msf, err
+			}
+			outgoing, err := hexStringToInt(args[0][1:])
+			if err != nil {
+				return msf, err
+			}
+			palette.SpliceSettings = append(palette.SpliceSettings, SpliceSettings{
+				IngoingID:         strconv.Itoa(int(ingoing)),
+				OutgoingID:        strconv.Itoa(int(outgoing)),
+				HeatFactor:        float32(factors[0]),
+				CompressionFactor: float32(factors[1]),
+				CoolingFactor:     float32(factors[2]),
+			})
+		}
+	}
+
+	if !versionSeen {
+		return msf, errors.New("not an MSF file")
+	}
+	if int(spliceCount) != len(msf.SpliceList) {
+		return msf, fmt.Errorf("MSF2 header lists %d splices, but %d were found", spliceCount, len(msf.SpliceList))
+	}
+	if int(pingCount) != len(msf.PingList) {
+		return msf, fmt.Errorf("MSF2 header lists %d pings, but %d were found", pingCount, len(msf.PingList))
+	}
+	if int(algorithmCount) != len(palette.SpliceSettings) {
+		return msf, fmt.Errorf("MSF2 header lists %d algorithms, but %d were found", algorithmCount, len(palette.SpliceSettings))
+	}
+	if int(hotSwapCount) != len(msf.HotSwapList) {
+		return msf, fmt.Errorf("MSF2 header lists %d hot swaps, but %d were found", hotSwapCount, len(msf.HotSwapList))
+	}
+	return msf, nil
+}
+
+func parseMSFJson(content []byte) (MSF, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return MSF{}, err
+	}
+	if _, ok := fields["drives"]; ok {
+		return parseMSF3(content)
+	}
+	return parseElementMSF(content)
+}
+
+func parseMSF3(content []byte) (MSF, error) {
+	var decoded struct {
+		palette3Json
+		PingCount *int `json:"pingCount"`
+	}
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return MSF{}, err
+	}
+	palette := &Palette{
+		Type:          TypeP3,
+		Model:         ModelP3,
+		ConnectedMode: decoded.PingCount != nil,
+	}
+	if len(decoded.Drives) > 4 {
+		palette.Model = ModelP3Pro
+	}
+	msf := newDecodedMSF(palette)
+
+	for drive, filamentID := range decoded.Drives {
+		if drive < len(palette.MaterialMeta) {
+			palette.MaterialMeta[drive].FilamentID = filamentID
+		}
+	}
+	for _, splice := range decoded.Splices {
+		drive := -1
+		for i, filamentID := range decoded.Drives {
+			if filamentID == splice.ID {
+				drive = i
+				break
+			}
+		}
+		if drive < 0 {
+			return msf, fmt.Errorf("splice uses filament %d, which is not loaded in any drive", splice.ID)
+		}
+		if err := msf.addDecodedSplice(drive, splice.Length); err != nil {
+			return msf, err
+		}
+	}
+	if palette.ConnectedMode {
+		// connected mode files only record how many pings to expect,
+		// so their positions are unknown
+		for i := 0; i < *decoded.PingCount; i++ {
+			msf.AddPing(0)
+		}
+	} else {
+		for _, ping := range decoded.Pings {
+			msf.AddPingWithExtrusion(ping.Length, ping.Extrusion)
+		}
+	}
+	for _, hotSwap := range decoded.HotSwaps {
+		err := msf.addDecodedHotSwap(HotSwap{
+			FromDrive: hotSwap.FromDrive,
+			ToDrive:   hotSwap.ToDrive,
+			Length:    hotSwap.Length,
+		})
+		if err != nil {
+			return msf, err
+		}
+	}
+	for _, alg := range decoded.Algorithms {
+		palette.SpliceSettings = append(palette.SpliceSettings, SpliceSettings{
+			IngoingID:         strconv.Itoa(alg.IngoingID),
+			OutgoingID:        strconv.Itoa(alg.OutgoingID),
+			HeatFactor:        alg.Heat,
+			CompressionFactor: alg.Compression,
+			CoolingFactor:     alg.Cooling,
+		})
+	}
+	return msf, nil
+}
+
+func parseElementMSF(content []byte) (MSF, error) {
+	var decoded elementJson
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return MSF{}, err
+	}
+	palette := &Palette{
+		Type:  TypeElement,
+		Model: ModelElement,
+	}
+	msf := newDecodedMSF(palette)
+
+	// Element files don't record which drive each filament is loaded in,
+	// so assign drives in order of first use
+	drivesByID := make(map[int]int)
+	var cumulativeLength float32
+	for _, splice := range decoded.Splices {
+		drive, ok := drivesByID[splice.ID]
+		if !ok {
+			drive = len(drivesByID)
+			drivesByID[splice.ID] = drive
+			if drive < len(palette.MaterialMeta) {
+				palette.MaterialMeta[drive].FilamentID = splice.ID
+			}
+		}
+		cumulativeLength += splice.Length
+		if err := msf.addDecodedSplice(drive, cumulativeLength); err != nil {
+			return msf, err
+		}
+	}
+	return msf, nil
+}
+
+// checkEncodable returns an error if the MSF is missing data required by
+// the file format of the given Palette type
+func (msf *MSF) checkEncodable(paletteType Type) error {
+	missing := func(what string) error {
+		return fmt.Errorf("cannot write a %s file: %s", paletteType, what)
+	}
+	switch paletteType {
+	case TypeP1:
+		if msf.Palette.GetPulsesPerMM() == 0 {
+			return missing("pulses per mm are unknown")
+		}
+	case TypeP2:
+		for drive, used := range msf.DrivesUsed {
+			material := msf.Palette.MaterialMeta[drive]
+			if used && (material.Index == 0 || material.Color == "") {
+				return missing(fmt.Sprintf("material index and color of drive %d are unknown", drive+1))
+			}
+		}
+	case TypeP3, TypeElement:
+		for drive, used := range msf.DrivesUsed {
+			if used && msf.Palette.MaterialMeta[drive].FilamentID == 0 {
+				return missing(fmt.Sprintf("filament ID of drive %d is unknown", drive+1))
+			}
+		}
+	default:
+		return fmt.Errorf("unknown Palette type '%s'", paletteType)
+	}
+	if paletteType == TypeP3 && msf.Palette.ConnectedMode {
+		return nil
+	}
+	if paletteType != TypeElement {
+		for _, ping := range msf.PingList {
+			if ping.Length == 0 {
+				return missing("ping positions are unknown")
+			}
+		}
+	}
+	return nil
+}
+
+// ConvertTo returns a copy of the MSF that will be encoded in the file format
+// of the given Palette type, if the MSF contains all the data that format requires.
+// Conversions always produce accessory mode files, except between connected Palette 3 files.
+func (msf *MSF) ConvertTo(paletteType Type) (MSF, error) {
+	palette := *msf.Palette
+	if paletteType != palette.Type {
+		palette.ConnectedMode = false
+	} else if paletteType == TypeP2 {
+		// the .mcf.gcode header can't be written without its G-code
+		palette.ConnectedMode = false
+	}
+	palette.Type = paletteType
+	switch paletteType {
+	case TypeP1:
+		palette.Model = ModelP
+	case TypeP2:
+		palette.Model = ModelP2
+	case TypeP3:
+		palette.Model = ModelP3
+		for drive, used := range msf.DrivesUsed {
+			if used && drive >= 4 {
+				palette.Model = ModelP3Pro
+			}
+		}
+	case TypeElement:
+		palette.Model = ModelElement
+	}
+	if msf.Palette.Type == paletteType {
+		palette.Model = msf.Palette.Model
+	}
+	palette.MaterialMeta = make([]Material, palette.GetInputCount())
+	copy(palette.MaterialMeta, msf.Palette.MaterialMeta)
+
+	converted := NewMSF(&palette)
+	converted.SpliceList = append(converted.SpliceList, msf.SpliceList...)
+	converted.PingList = append(converted.PingList, msf.PingList...)
+	for _, splice := range msf.SpliceList {
+		if splice.Drive >= len(converted.DrivesUsed) {
+			return converted, fmt.Errorf("cannot write a %s file: drive %d is not available", paletteType, splice.Drive+1)
+		}
+		converted.DrivesUsed[splice.Drive] = true
+	}
+	if len(msf.HotSwapList) > 0 && !palette.SupportsHotSwaps() {
+		return converted, fmt.Errorf("cannot write a %s file: hot swaps are not supported", paletteType)
+	}
+	for _, hotSwap := range msf.HotSwapList {
+		if hotSwap.ToDrive >= len(converted.DrivesUsed) {
+			return converted, fmt.Errorf("cannot write a %s file: drive %d is not available", paletteType, hotSwap.ToDrive+1)
+		}
+		converted.DrivesUsed[hotSwap.ToDrive] = true
+	}
+	converted.HotSwapList = append(converted.HotSwapList, msf.HotSwapList...)
+	if err := converted.checkEncodable(paletteType); err != nil {
+		return converted, err
+	}
+	return converted, nil
+}
@@ -0,0 +1,149 @@
+package msf
+
+import (
+	"strings"
+	"testing"
+)
+
+func getTestMSF(palette *Palette) MSF {
+	msf := NewMSF(palette)
+	msf.DrivesUsed[0] = true
+	msf.DrivesUsed[1] = true
+	msf.SpliceList = []Splice{
+		{Drive: 0, Length: 150.25},
+		{Drive: 1, Length: 260.5},
+		{Drive: 0, Length: 400.125},
+	}
+	msf.PingList = []Ping{
+		{Length: 120.5, Extrusion: 20},
+		{Length: 380.75, Extrusion: 19.5},
+	}
+	return msf
+}
+
+func Test_ParseMSFRoundTrip(t *testing.T) {
+	types := []Type{TypeP1, TypeP2, TypeP3, TypeElement}
+	for _, paletteType := range types {
+		palette := getTestPalette(30)
+		palette.Type = paletteType
+		palette.Model = ModelP3
+		palette.PrintValue = 2500
+		palette.CalibrationLength = 100
+		palette.LoadingOffset = 1234
+		palette.MaterialMeta[1].Index = 2
+		palette.MaterialMeta[1].FilamentID = 2
+		palette.MaterialMeta[1].Color = "ff8000"
+		palette.SpliceSettings = []SpliceSettings{
+			{IngoingID: "1", OutgoingID: "2", HeatFactor: 3, CompressionFactor: -2, CoolingFactor: 1},
+			{IngoingID: "2", OutgoingID: "1", HeatFactor: 1, CompressionFactor: 2},
+		}
+		msf := getTestMSF(&palette)
+		if palette.SupportsHotSwaps() {
+			msf.DrivesUsed[2] = true
+			msf.HotSwapList = []HotSwap{{FromDrive: 0, ToDrive: 2, Length: 300}}
+		}
+		if paletteType == TypeP1 {
+			// MSF1 pings don't record extrusion
+			for i := range msf.PingList {
+				msf.PingList[i].Extrusion = 0
+			}
+		}
+		encoded, err := msf.CreateMSF()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := ParseMSF(encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", paletteType, err)
+		}
+		if decoded.Palette.Type != paletteType {
+			t.Fatalf("%s: decoded as %s", paletteType, decoded.Palette.Type)
+		}
+		if len(decoded.SpliceList) != len(msf.SpliceList) {
+			t.Fatalf("%s: expected %d splices, got %d", paletteType, len(msf.SpliceList), len(decoded.SpliceList))
+		}
+		for i, splice := range decoded.SpliceList {
+			if splice != msf.SpliceList[i] {
+				t.Errorf("%s: expected splice %v, got %v", paletteType, msf.SpliceList[i], splice)
+			}
+		}
+		if paletteType == TypeElement {
+			continue
+		}
+		if len(decoded.PingList) != len(msf.PingList) {
+			t.Fatalf("%s: expected %d pings, got %d", paletteType, len(msf.PingList), len(decoded.PingList))
+		}
+		for i, ping := range decoded.PingList {
+			if ping != msf.PingList[i] {
+				t.Errorf("%s: expected ping %v, got %v", paletteType, msf.PingList[i], ping)
+			}
+		}
+		if len(decoded.HotSwapList) != 1 || decoded.HotSwapList[0] != msf.HotSwapList[0] {
+			t.Errorf("%s: expected hot swaps %v, got %v", paletteType, msf.HotSwapList, decoded.HotSwapList)
+		}
+
+		// re-encoding the decoded file should reproduce it exactly
+		converted, err := decoded.ConvertTo(paletteType)
+		if err != nil {
+			t.Fatalf("%s: %s", paletteType, err)
+		}
+		reencoded, err := converted.CreateMSF()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reencoded != encoded {
+			t.Errorf("%s: re-encoded file differs\nexpected:\n%s\ngot:\n%s", paletteType, encoded, reencoded)
+		}
+	}
+}
+
+func Test_ParseMSFCounts(t *testing.T) {
+	for _, paletteType := range []Type{TypeP1, TypeP2} {
+		palette := getTestPalette(30)
+		palette.Type = paletteType
+		msf := getTestMSF(&palette)
+		encoded, err := msf.CreateMSF()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// drop the last splice
+		lines := strings.Split(encoded, EOL)
+		for i := len(lines) - 1; i >= 0; i-- {
+			if strings.HasPrefix(lines[i], "O30") || strings.HasPrefix(lines[i], "(0") {
+				lines = append(lines[:i], lines[i+1:]...)
+				break
+			}
+		}
+		if _, err := ParseMSF(strings.Join(lines, EOL)); err == nil || !strings.Contains(err.Error(), "splices") {
+			t.Errorf("%s: expected a splice count error, got %v", paletteType, err)
+		}
+	}
+}
+
+func Test_ConvertMSF(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.MaterialMeta[1].FilamentID = 2
+	msf := getTestMSF(&palette)
+	encoded, err := msf.CreateMSF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ParseMSF(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Palette 3 files have filament IDs, so can become Element files...
+	element, err := decoded.ConvertTo(TypeElement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := element.CreateMSF(); err != nil {
+		t.Fatal(err)
+	}
+	// ...but don't record the pulses per mm needed by MSF1
+	if _, err := decoded.ConvertTo(TypeP1); err == nil {
+		t.Fatal("expected conversion to MSF1 to fail")
+	}
+}
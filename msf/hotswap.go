@@ -0,0 +1,90 @@
+package msf
+
+import (
+	"errors"
+	"fmt"
+)
+
+type HotSwap struct {
+	FromDrive int
+	ToDrive   int
+	Length    float32 // position in the output where the swap happens, mm
+}
+
+// nextHotSwapDrive returns the equivalent input of drive with the most filament
+// remaining, or -1 if every equivalent input is empty
+func (msf *MSF) nextHotSwapDrive(drive int, consumed []float32, exhausted []bool) int {
+	next := -1
+	var nextAvailable float32
+	for _, other := range msf.Palette.GetEquivalentInputs(drive) {
+		if exhausted[other] {
+			continue
+		}
+		available := msf.Palette.GetSpoolLength(other) - consumed[other]
+		if available > nextAvailable {
+			next = other
+			nextAvailable = available
+		}
+	}
+	return next
+}
+
+// ScheduleHotSwaps plans a swap to an equivalent input for each drive that would
+// run out of filament partway through the print. It must be called once the splice
+// list is complete. Splices keep referring to the drive originally assigned to them.
+func (msf *MSF) ScheduleHotSwaps() error {
+	msf.HotSwapList = msf.HotSwapList[:0]
+	if !msf.Palette.SupportsHotSwaps() {
+		return nil
+	}
+
+	numInputs := len(msf.DrivesUsed)
+	consumed := make([]float32, numInputs)
+	exhausted := make([]bool, numInputs)
+	active := make([]int, numInputs) // physical drive currently feeding each drive in the splice list
+	for drive := range active {
+		active[drive] = drive
+	}
+	minPieceLength := msf.Palette.GetSpliceMinLength()
+
+	pieceStart := float32(0)
+	for _, splice := range msf.SpliceList {
+		pieceEnd := splice.Length
+		for {
+			physical := active[splice.Drive]
+			available := msf.Palette.GetSpoolLength(physical) - consumed[physical]
+			if pieceEnd-pieceStart <= available {
+				consumed[physical] += pieceEnd - pieceStart
+				break
+			}
+
+			// swap early rather than create a piece that is too short to splice
+			swapAt := pieceStart + available
+			if pieceEnd-swapAt < minPieceLength {
+				swapAt = pieceEnd - minPieceLength
+			}
+			if swapAt-pieceStart < minPieceLength {
+				swapAt = pieceStart
+			}
+
+			next := msf.nextHotSwapDrive(physical, consumed, exhausted)
+			if next < 0 {
+				message := "Not Enough Filament\n"
+				message += fmt.Sprintf("Drive %d would run out of filament %.2f mm into the print, and no equivalent input has any filament remaining.", physical+1, pieceStart+available)
+				return errors.New(message)
+			}
+			consumed[physical] += swapAt - pieceStart
+			exhausted[physical] = true
+			active[splice.Drive] = next
+			msf.DrivesUsed[next] = true
+			msf.HotSwapList = append(msf.HotSwapList, HotSwap{
+				FromDrive: physical,
+				ToDrive:   next,
+				Length:    swapAt,
+			})
+			pieceStart = swapAt
+		}
+		pieceStart = pieceEnd
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package msf
+
+import "testing"
+
+func Test_ScheduleHotSwaps(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.SpoolLengths = []float32{300, 0, 1000}
+	palette.EquivalentInputs = [][]int{{0, 2}}
+	msf := getTestMSF(&palette)
+	msf.SpliceList = append(msf.SpliceList, Splice{Drive: 1, Length: 500}, Splice{Drive: 0, Length: 900})
+
+	if err := msf.ScheduleHotSwaps(); err != nil {
+		t.Fatal(err)
+	}
+	// drive 1 uses 150.25 + 139.625 mm in its first two pieces, leaving 10.125 mm:
+	// too short to splice in the third piece, so swap at its start instead
+	expected := HotSwap{FromDrive: 0, ToDrive: 2, Length: 500}
+	if len(msf.HotSwapList) != 1 || msf.HotSwapList[0] != expected {
+		t.Fatalf("expected hot swaps [%v], got %v", expected, msf.HotSwapList)
+	}
+	if !msf.DrivesUsed[2] {
+		t.Fatal("expected hot swap drive to be marked as used")
+	}
+
+	palette.EquivalentInputs = nil
+	if err := msf.ScheduleHotSwaps(); err == nil {
+		t.Fatal("expected an error when no equivalent input is available")
+	}
+}
@@ -0,0 +1,179 @@
+package msf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+type inspectDrive struct {
+	Drive       int     `json:"drive"` // 1-indexed
+	Used        bool    `json:"used"`
+	Index       int     `json:"index,omitempty"`
+	FilamentID  int     `json:"filamentId,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Color       string  `json:"color,omitempty"`
+	TotalLength float32 `json:"totalLength"` // mm
+}
+
+type inspectSplice struct {
+	Drive       int     `json:"drive"`       // 1-indexed
+	Length      float32 `json:"length"`      // mm, cumulative
+	PieceLength float32 `json:"pieceLength"` // mm
+}
+
+type inspectPing struct {
+	Length    float32 `json:"length"`              // mm
+	Extrusion float32 `json:"extrusion,omitempty"` // mm
+}
+
+type inspectHotSwap struct {
+	FromDrive int     `json:"fromDrive"` // 1-indexed
+	ToDrive   int     `json:"toDrive"`   // 1-indexed
+	Length    float32 `json:"length"`    // mm
+}
+
+type inspectAlgorithm struct {
+	Ingoing     string  `json:"ingoing"`
+	Outgoing    string  `json:"outgoing"`
+	Heat        float32 `json:"heat"`
+	Compression float32 `json:"compression"`
+	Cooling     float32 `json:"cooling"`
+	Reverse     bool    `json:"reverse"`
+}
+
+type inspectReport struct {
+	Type          Type               `json:"type"`
+	Model         Model              `json:"model"`
+	ConnectedMode bool               `json:"connectedMode"`
+	PrinterID     string             `json:"printerId,omitempty"`
+	Filename      string             `json:"filename,omitempty"`
+	PulsesPerMM   float32            `json:"pulsesPerMM,omitempty"`
+	LoadingOffset int                `json:"loadingOffset,omitempty"`
+	TotalLength   float32            `json:"totalLength"` // mm
+	Drives        []inspectDrive     `json:"drives"`
+	Splices       []inspectSplice    `json:"splices"`
+	Pings         []inspectPing      `json:"pings"`
+	HotSwaps      []inspectHotSwap   `json:"hotSwaps"`
+	Algorithms    []inspectAlgorithm `json:"algorithms"`
+}
+
+func (msf *MSF) inspect() inspectReport {
+	palette := msf.Palette
+	report := inspectReport{
+		Type:          palette.Type,
+		Model:         palette.Model,
+		ConnectedMode: palette.ConnectedMode,
+		PrinterID:     palette.PrinterID,
+		Filename:      palette.Filename,
+		PulsesPerMM:   palette.GetPulsesPerMM(),
+		LoadingOffset: palette.LoadingOffset,
+		TotalLength:   msf.GetTotalFilamentLength(),
+		Drives:        make([]inspectDrive, 0, len(msf.DrivesUsed)),
+		Splices:       make([]inspectSplice, 0, len(msf.SpliceList)),
+		Pings:         make([]inspectPing, 0, len(msf.PingList)),
+		HotSwaps:      make([]inspectHotSwap, 0, len(msf.HotSwapList)),
+		Algorithms:    make([]inspectAlgorithm, 0, len(palette.SpliceSettings)),
+	}
+
+	lengths := msf.GetFilamentLengthsByDrive()
+	for drive, used := range msf.DrivesUsed {
+		material := palette.MaterialMeta[drive]
+		report.Drives = append(report.Drives, inspectDrive{
+			Drive:       drive + 1,
+			Used:        used,
+			Index:       material.Index,
+			FilamentID:  material.FilamentID,
+			Name:        material.Name,
+			Color:       material.Color,
+			TotalLength: lengths[drive],
+		})
+	}
+
+	var lastSpliceLength float32
+	for _, splice := range msf.SpliceList {
+		report.Splices = append(report.Splices, inspectSplice{
+			Drive:       splice.Drive + 1,
+			Length:      splice.Length,
+			PieceLength: splice.Length - lastSpliceLength,
+		})
+		lastSpliceLength = splice.Length
+	}
+
+	for _, ping := range msf.PingList {
+		report.Pings = append(report.Pings, inspectPing{
+			Length:    ping.Length,
+			Extrusion: ping.Extrusion,
+		})
+	}
+
+	for _, hotSwap := range msf.HotSwapList {
+		report.HotSwaps = append(report.HotSwaps, inspectHotSwap{
+			FromDrive: hotSwap.FromDrive + 1,
+			ToDrive:   hotSwap.ToDrive + 1,
+			Length:    hotSwap.Length,
+		})
+	}
+
+	for _, alg := range palette.SpliceSettings {
+		report.Algorithms = append(report.Algorithms, inspectAlgorithm{
+			Ingoing:     alg.IngoingID,
+			Outgoing:    alg.OutgoingID,
+			Heat:        alg.HeatFactor,
+			Compression: alg.CompressionFactor,
+			Cooling:     alg.CoolingFactor,
+			Reverse:     alg.Reverse,
+		})
+	}
+
+	return report
+}
+
+// Inspect prints the contents of an existing MSF file as JSON,
+// and optionally re-encodes it as a different MSF version:
+//
+//	msf-inspect <msfpath>
+//	msf-inspect <msfpath> <outpath> <palette|palette-2|palette-3|element>
+func Inspect(argv []string) {
+	argc := len(argv)
+
+	if argc != 1 && argc != 3 {
+		log.Fatalln("expected 1 or 3 command-line arguments")
+	}
+	msfpath := argv[0]
+
+	msf, err := LoadMSFFromFile(msfpath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if argc == 1 {
+		bytes, err := json.MarshalIndent(msf.inspect(), "", "  ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if _, err := os.Stdout.Write(append(bytes, '\n')); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	outpath := argv[1]
+	converted, err := msf.ConvertTo(Type(argv[2]))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	msfStr, err := converted.CreateMSF()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if outpath == "-" {
+		_, err = os.Stdout.WriteString(msfStr)
+	} else {
+		err = ioutil.WriteFile(outpath, []byte(msfStr), 0644)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
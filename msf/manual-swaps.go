@@ -0,0 +1,91 @@
+package msf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FilamentSwap is a filament change made by hand, for printers without Palette
+type FilamentSwap struct {
+	Layer             int     `json:"layer"` // 0-indexed
+	Tool              int     `json:"tool"`  // input to load
+	Name              string  `json:"name"`
+	Color             string  `json:"color"`
+	Time              float32 `json:"time"`              // s, estimated time into the print
+	TimeSinceLastSwap float32 `json:"timeSinceLastSwap"` // s
+}
+
+// FilamentSwapList is written instead of an MSF file for manual filament swaps
+type FilamentSwapList struct {
+	Swaps     []FilamentSwap `json:"swaps"`     // the first is the filament loaded before printing
+	PrintTime float32        `json:"printTime"` // s, estimated
+}
+
+// hasSameColor returns true if two tools are loaded with filament of the same known color
+func (p Palette) hasSameColor(toolA, toolB int) bool {
+	if toolA >= len(p.MaterialMeta) || toolB >= len(p.MaterialMeta) {
+		return false
+	}
+	colorA := strings.ToLower(strings.TrimPrefix(p.MaterialMeta[toolA].Color, "#"))
+	colorB := strings.ToLower(strings.TrimPrefix(p.MaterialMeta[toolB].Color, "#"))
+	return colorA != "" && colorA == colorB
+}
+
+func (msf *MSF) addFilamentSwap(tool int, elapsed float32, state *State) {
+	swap := FilamentSwap{
+		Layer: state.CurrentLayer,
+		Tool:  tool,
+		Time:  elapsed,
+	}
+	if swap.Layer < 0 {
+		// loaded before the first layer change
+		swap.Layer = 0
+	}
+	if tool < len(msf.Palette.MaterialMeta) {
+		swap.Name = msf.Palette.MaterialMeta[tool].Name
+		swap.Color = msf.Palette.MaterialMeta[tool].Color
+	}
+	if count := len(msf.SwapList.Swaps); count > 0 {
+		swap.TimeSinceLastSwap = elapsed - msf.SwapList.Swaps[count-1].Time
+	}
+	msf.SwapList.Swaps = append(msf.SwapList.Swaps, swap)
+}
+
+// filamentChange records a manual swap to tool, elapsed seconds into the print,
+// and returns the sequence that pauses the printer for it
+func filamentChange(tool int, elapsed float32, state *State) (string, error) {
+	state.MSF.addFilamentSwap(tool, elapsed, state)
+	sequence := fmt.Sprintf("; Filament swap %d: load input %d%s", len(state.MSF.SwapList.Swaps)-1, tool+1, EOL)
+	if state.Palette.FilamentChangeScript == nil {
+		flavor := state.Palette.GetFlavor()
+		command := flavor.FilamentChange()
+		if command == "" {
+			command = flavor.Pause()
+		}
+		return sequence + command + EOL, nil
+	}
+	locals := state.Locals.Prepare(tool, map[string]float64{
+		"layer":                   float64(state.CurrentLayer),
+		"currentPrintTemperature": float64(state.Temperature.Extruder),
+		"currentBedTemperature":   float64(state.Temperature.Bed),
+		"currentX":                float64(state.XYZF.CurrentX),
+		"currentY":                float64(state.XYZF.CurrentY),
+		"currentZ":                float64(state.XYZF.CurrentZ),
+		"previousTool":            float64(state.CurrentTool),
+		"nextTool":                float64(tool),
+	})
+	script, err := evaluateScript(state.Palette.FilamentChangeScript, locals, state)
+	if err != nil {
+		return "", err
+	}
+	return sequence + script, nil
+}
+
+func (msf *MSF) createSwapList() (string, error) {
+	bytes, err := json.MarshalIndent(msf.SwapList, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
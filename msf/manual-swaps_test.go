@@ -0,0 +1,68 @@
+package msf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
+
+func Test_ManualFilamentSwaps(t *testing.T) {
+	palette := getTestPalette(50)
+	palette.Type = TypeManual
+	palette.TransitionMethod = None
+	palette.Flavor = gcode.Marlin{}
+	palette.MaterialMeta = []Material{
+		{Name: "Red PLA", Color: "ff0000"},
+		{Name: "Other Red PLA", Color: "#FF0000"},
+		{Name: "Blue PLA", Color: "0000ff"},
+	}
+	printContent := `
+;START_OF_PRINT
+T0
+;LAYER_CHANGE
+;Z:0.2
+G1 X0 Y0 Z0.2 F6000
+G1 X60 Y0 E10 F600
+T1
+G1 X0 Y0 E20 F600
+;LAYER_CHANGE
+;Z:0.4
+G1 Z0.4
+T2
+G1 X60 Y0 E30 F600
+`
+	var output bytes.Buffer
+	msfOut, err := ConvertForPaletteStream(getTestLineReader(printContent), &output, &palette, sequences.NewLocals())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the two reds are merged, so only the swap to blue pauses the print
+	if count := strings.Count(output.String(), "M600"); count != 1 {
+		t.Fatalf("expected 1 filament change, got %d", count)
+	}
+	swaps := msfOut.SwapList.Swaps
+	if len(swaps) != 2 {
+		t.Fatalf("expected 2 swaps (including the initial load), got %d", len(swaps))
+	}
+	if swaps[0].Tool != 0 || swaps[1].Tool != 2 || swaps[1].Layer != 1 || swaps[1].Name != "Blue PLA" {
+		t.Errorf("unexpected swap list %+v", swaps)
+	}
+	// 120 mm at 10 mm/s on the first layer
+	if swaps[1].TimeSinceLastSwap < 11.9 || swaps[1].TimeSinceLastSwap > 12.1 {
+		t.Errorf("expected 12s between swaps, got %f", swaps[1].TimeSinceLastSwap)
+	}
+
+	// firmwares without a filament change command pause instead
+	palette.Flavor = gcode.Bambu{}
+	output.Reset()
+	if _, err := ConvertForPaletteStream(getTestLineReader(printContent), &output, &palette, sequences.NewLocals()); err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(output.String(), gcode.Bambu{}.Pause()); count != 1 {
+		t.Errorf("expected 1 pause, got %d", count)
+	}
+}
@@ -30,19 +30,42 @@ type Algorithm struct {
 	Reverse           bool
 }
 
+// PieceViolation describes a piece that is shorter than Palette's minimum
+type PieceViolation struct {
+	Splice      int     `json:"splice"` // 1-indexed
+	Drive       int     `json:"drive"`
+	Position    float32 `json:"position"`    // mm, cumulative length of the splice ending the piece
+	PieceLength float32 `json:"pieceLength"` // mm
+	MinLength   float32 `json:"minLength"`   // mm
+}
+
 type MSF struct {
-	Palette    *Palette
-	DrivesUsed []bool
-	SpliceList []Splice
-	PingList   []Ping
+	Palette      *Palette
+	DrivesUsed   []bool
+	SpliceList   []Splice
+	PingList     []Ping
+	HotSwapList  []HotSwap
+	Repairs      []RepairRecord
+	SwapList     FilamentSwapList   // manual filament swaps, without Palette
+	SpliceStress SpliceStressReport // only if analyzed
+	Usage        UsageReport        // filament used by each drive
+
+	// when true, pieces that are too short are recorded in Violations instead of returning an error
+	collectViolations bool
+	Violations        []PieceViolation
 }
 
 func NewMSF(paletteData *Palette) MSF {
 	return MSF{
-		Palette:    paletteData,
-		DrivesUsed: make([]bool, paletteData.GetInputCount()),
-		SpliceList: make([]Splice, 0),
-		PingList:   make([]Ping, 0),
+		Palette:     paletteData,
+		DrivesUsed:  make([]bool, paletteData.GetInputCount()),
+		SpliceList:  make([]Splice, 0),
+		PingList:    make([]Ping, 0),
+		HotSwapList: make([]HotSwap, 0),
+		Repairs:     make([]RepairRecord, 0),
+		SwapList: FilamentSwapList{
+			Swaps: make([]FilamentSwap, 0),
+		},
 	}
 }
 
@@ -70,7 +93,9 @@ func (msf *MSF) addSplice(splice Splice) error {
 		if len(msf.SpliceList) == 0 {
 			// first splice
 			minLength := msf.Palette.GetFirstSpliceMinLength()
-			if splice.Length < minLength-5 {
+			if splice.Length < minLength-5 && msf.collectViolations {
+				msf.addViolation(splice, splice.Length, minLength)
+			} else if splice.Length < minLength-5 {
 				message := "First Piece Too Short\n"
 				message += fmt.Sprintf("The first piece created by %s would be %.2f mm long, but must be at least %.2f mm.", msf.Palette.ProductName(), splice.Length, minLength)
 				if enforcePieceLengths {
@@ -83,7 +108,9 @@ func (msf *MSF) addSplice(splice Splice) error {
 			// all others
 			spliceDelta := splice.Length - msf.SpliceList[len(msf.SpliceList)-1].Length
 			minSpliceLength := msf.Palette.GetSpliceMinLength()
-			if spliceDelta < minSpliceLength-5 {
+			if spliceDelta < minSpliceLength-5 && msf.collectViolations {
+				msf.addViolation(splice, spliceDelta, minSpliceLength)
+			} else if spliceDelta < minSpliceLength-5 {
 				fmt.Printf("piece too short on splice %d\n", len(msf.SpliceList)+1)
 				message := "Piece Too Short\n"
 				message += fmt.Sprintf("Canvas attempted to create a splice that was %.2f mm long, but %s's minimum splice length is %.2f mm.", spliceDelta, msf.Palette.ProductName(), minSpliceLength)
@@ -100,6 +127,16 @@ func (msf *MSF) addSplice(splice Splice) error {
 	return nil
 }
 
+func (msf *MSF) addViolation(splice Splice, pieceLength, minLength float32) {
+	msf.Violations = append(msf.Violations, PieceViolation{
+		Splice:      len(msf.SpliceList) + 1,
+		Drive:       splice.Drive,
+		Position:    splice.Length,
+		PieceLength: pieceLength,
+		MinLength:   minLength,
+	})
+}
+
 func (msf *MSF) AddSplice(drive int, length float32) error {
 	return msf.addSplice(Splice{
 		Drive:  drive,
@@ -108,7 +145,7 @@ func (msf *MSF) AddSplice(drive int, length float32) error {
 }
 
 func (msf *MSF) AddLastSplice(drive int, finalLength float32) error {
-	if msf.Palette.Type == TypeElement {
+	if msf.Palette.Type == TypeElement || msf.Palette.Type == TypeManual {
 		return msf.addSplice(Splice{
 			Drive:  drive,
 			Length: finalLength,
@@ -188,6 +225,30 @@ func (msf *MSF) GetOutputAlgorithmsList() []Algorithm {
 	}
 	algs := make([]Algorithm, 0)
 
+	addAlgorithm := func(ingoingIndex, outgoingIndex int) {
+		if algIsPresent[ingoingIndex-1][outgoingIndex-1] {
+			return
+		}
+		ingoingId := strconv.Itoa(ingoingIndex)
+		outgoingId := strconv.Itoa(outgoingIndex)
+		for _, spliceSettings := range msf.Palette.SpliceSettings {
+			if spliceSettings.IngoingID == ingoingId &&
+				spliceSettings.OutgoingID == outgoingId {
+				alg := Algorithm{
+					Ingoing:           ingoingIndex,
+					Outgoing:          outgoingIndex,
+					HeatFactor:        spliceSettings.HeatFactor,
+					CompressionFactor: spliceSettings.CompressionFactor,
+					CoolingFactor:     spliceSettings.CoolingFactor,
+					Reverse:           spliceSettings.Reverse,
+				}
+				algs = append(algs, alg)
+				break
+			}
+		}
+		algIsPresent[ingoingIndex-1][outgoingIndex-1] = true
+	}
+
 	firstSplice := true
 	outgoingExt := 0
 	var ingoingExt int
@@ -196,28 +257,7 @@ func (msf *MSF) GetOutputAlgorithmsList() []Algorithm {
 	for _, splice := range msf.SpliceList {
 		ingoingExt = splice.Drive
 		if !firstSplice {
-			ingoingIndex := msf.Palette.MaterialMeta[ingoingExt].Index
-			outgoingIndex := msf.Palette.MaterialMeta[outgoingExt].Index
-			ingoingId := strconv.Itoa(ingoingIndex)
-			outgoingId := strconv.Itoa(outgoingIndex)
-			if !algIsPresent[ingoingIndex-1][outgoingIndex-1] {
-				for _, spliceSettings := range msf.Palette.SpliceSettings {
-					if spliceSettings.IngoingID == ingoingId &&
-						spliceSettings.OutgoingID == outgoingId {
-						alg := Algorithm{
-							Ingoing:           ingoingIndex,
-							Outgoing:          outgoingIndex,
-							HeatFactor:        spliceSettings.HeatFactor,
-							CompressionFactor: spliceSettings.CompressionFactor,
-							CoolingFactor:     spliceSettings.CoolingFactor,
-							Reverse:           spliceSettings.Reverse,
-						}
-						algs = append(algs, alg)
-						break
-					}
-				}
-				algIsPresent[ingoingIndex-1][outgoingIndex-1] = true
-			}
+			addAlgorithm(msf.Palette.MaterialMeta[ingoingExt].Index, msf.Palette.MaterialMeta[outgoingExt].Index)
 		}
 		outgoingExt = ingoingExt
 		firstSplice = false
@@ -228,27 +268,14 @@ func (msf *MSF) GetOutputAlgorithmsList() []Algorithm {
 	for drive := 0; drive < numInputs; drive++ {
 		if msf.DrivesUsed[drive] {
 			materialIndex := msf.Palette.MaterialMeta[drive].Index
-			materialId := strconv.Itoa(materialIndex)
-			if !algIsPresent[materialIndex-1][materialIndex-1] {
-				for _, spliceSettings := range msf.Palette.SpliceSettings {
-					if spliceSettings.IngoingID == materialId &&
-						spliceSettings.OutgoingID == materialId {
-						alg := Algorithm{
-							Ingoing:           materialIndex,
-							Outgoing:          materialIndex,
-							HeatFactor:        spliceSettings.HeatFactor,
-							CompressionFactor: spliceSettings.CompressionFactor,
-							CoolingFactor:     spliceSettings.CoolingFactor,
-							Reverse:           spliceSettings.Reverse,
-						}
-						algs = append(algs, alg)
-						break
-					}
-				}
-				algIsPresent[materialIndex-1][materialIndex-1] = true
-			}
+			addAlgorithm(materialIndex, materialIndex)
 		}
 	}
+
+	// hot swaps between equivalent inputs loaded with different material profiles
+	for _, hotSwap := range msf.HotSwapList {
+		addAlgorithm(msf.Palette.MaterialMeta[hotSwap.ToDrive].Index, msf.Palette.MaterialMeta[hotSwap.FromDrive].Index)
+	}
 	sort.Slice(algs, func(i, j int) bool {
 		a := algs[i]
 		b := algs[j]
@@ -267,6 +294,9 @@ func (msf *MSF) GetMSF2Header() string {
 	printLength := msf.GetTotalFilamentLength()
 	intLength := uint(math.Ceil(float64(printLength)))
 	header += "O1 D" + msfFilename + " D" + intToHexString(intLength, 8) + EOL
+	// always M0, regardless of the printer's flavor: Palette (or the hub relaying
+	// the print) waits for this exact command after O1 and resumes the print once
+	// filament is loaded, so it never reaches the firmware as a user pause
 	header += "M0" + EOL
 	return header
 }
@@ -331,8 +361,8 @@ func (msf *MSF) createMSF1() string {
 	str += "ns:" + intToHexString(uint(len(msf.SpliceList)), 4) + EOL
 	// number of pings
 	str += "np:" + intToHexString(uint(len(msf.PingList)), 4) + EOL
-	// number of hot swaps (always 0)
-	str += "nh:" + intToHexString(0, 4) + EOL
+	// number of hot swaps
+	str += "nh:" + intToHexString(uint(len(msf.HotSwapList)), 4) + EOL
 	// number of algorithms
 	str += "na:" + intToHexString(uint(len(algorithmList)), 4) + EOL
 
@@ -346,6 +376,12 @@ func (msf *MSF) createMSF1() string {
 		str += "(64," + floatToHexString(ping.Length) + ")" + EOL
 	}
 
+	// hot swap list
+	for _, hotSwap := range msf.HotSwapList {
+		str += "(" + intToHexString(uint(hotSwap.FromDrive), 2) + "," + intToHexString(uint(hotSwap.ToDrive), 2) + ","
+		str += floatToHexString(hotSwap.Length) + ")" + EOL
+	}
+
 	// algorithm list
 	for _, alg := range algorithmList {
 		str += "(" + strconv.Itoa(alg.Ingoing) + strconv.Itoa(alg.Outgoing) + ","
@@ -406,8 +442,8 @@ func (msf *MSF) createMSF2() string {
 	// number of algorithms
 	str += "O28 D" + intToHexString(uint(len(algorithmList)), 4) + EOL
 
-	// number of hot swaps (0 for now)
-	str += "O29 D0000" + EOL
+	// number of hot swaps
+	str += "O29 D" + intToHexString(uint(len(msf.HotSwapList)), 4) + EOL
 
 	// splice data
 	for _, splice := range msf.SpliceList {
@@ -431,7 +467,12 @@ func (msf *MSF) createMSF2() string {
 		str += EOL
 	}
 
-	// hot swap data (nonexistent for now)
+	// hot swap data
+	for _, hotSwap := range msf.HotSwapList {
+		str += "O33 D" + intToHexString(uint(hotSwap.FromDrive), 1)
+		str += " D" + intToHexString(uint(hotSwap.ToDrive), 1)
+		str += " D" + floatToHexString(hotSwap.Length) + EOL
+	}
 
 	return str
 }
@@ -480,6 +521,15 @@ func (msf *MSF) createMSF3() (string, error) {
 		json.Pings = append(json.Pings, jsonPing)
 	}
 
+	// hot swap data
+	for _, hotSwap := range msf.HotSwapList {
+		json.HotSwaps = append(json.HotSwaps, palette3HotSwap{
+			FromDrive: hotSwap.FromDrive,
+			ToDrive:   hotSwap.ToDrive,
+			Length:    hotSwap.Length,
+		})
+	}
+
 	// algorithm data
 	for _, alg := range algorithmList {
 		iid, err := strconv.Atoi(alg.IngoingID)
@@ -539,5 +589,8 @@ func (msf *MSF) CreateMSF() (string, error) {
 	if msf.Palette.Type == TypeP3 {
 		return msf.createMSF3()
 	}
+	if msf.Palette.Type == TypeManual {
+		return msf.createSwapList()
+	}
 	return msf.createElementMSF()
 }
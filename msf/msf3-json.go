@@ -20,12 +20,19 @@ type palette3Ping struct {
 	Extrusion float32 `json:"extrusion,omitempty"` // exclude if 0 to save space
 }
 
+type palette3HotSwap struct {
+	FromDrive int     `json:"fromDrive"`
+	ToDrive   int     `json:"toDrive"`
+	Length    float32 `json:"length"`
+}
+
 type palette3Json struct {
 	Version    string              `json:"version"`
 	Drives     []int               `json:"drives"`
 	Splices    []palette3Splice    `json:"splices"`
 	Pings      []palette3Ping      `json:"pings"`
 	Algorithms []palette3Algorithm `json:"algorithms"`
+	HotSwaps   []palette3HotSwap   `json:"hotSwaps,omitempty"` // exclude if empty for older firmware
 }
 
 type palette3JsonConnected struct {
@@ -34,6 +41,7 @@ type palette3JsonConnected struct {
 	Splices    []palette3Splice    `json:"splices"`
 	PingCount  int                 `json:"pingCount"`
 	Algorithms []palette3Algorithm `json:"algorithms"`
+	HotSwaps   []palette3HotSwap   `json:"hotSwaps,omitempty"` // exclude if empty for older firmware
 }
 
 type elementSplice struct {
@@ -56,6 +64,7 @@ func (p *palette3Json) marshal(connected bool) (string, error) {
 			Splices:    p.Splices,
 			PingCount:  len(p.Pings),
 			Algorithms: p.Algorithms,
+			HotSwaps:   p.HotSwaps,
 		}
 		bytes, err := json.MarshalIndent(pc, "", "  ")
 		if err != nil {
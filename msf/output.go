@@ -4,18 +4,16 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"strings"
 
 	"mosaicmfg.com/ps-postprocess/gcode"
-	"mosaicmfg.com/ps-postprocess/ptp"
 	"mosaicmfg.com/ps-postprocess/sequences"
 )
 
 func _paletteOutput(
-	readerFn func(callback gcode.LineCallback) error,
+	readerFn gcode.LineReader,
 	writer *bufio.Writer,
 	msfOut *MSF,
 	palette *Palette,
@@ -25,6 +23,7 @@ func _paletteOutput(
 	// initialize state
 	state := NewState(palette)
 	state.MSF = msfOut
+	msfOut.Repairs = append(msfOut.Repairs, preflight.repairs...)
 	state.TowerBoundingBox = preflight.towerBoundingBox
 	state.TransitionNextPositions = preflight.transitionNextPositions
 	locals.Global["totalTime"] = float64(preflight.timeEstimate)
@@ -34,6 +33,8 @@ func _paletteOutput(
 	// account for a firmware purge (not part of G-code) once
 	state.E.TotalExtrusion += palette.FirmwarePurge
 	state.TimeEstimate = preflight.timeEstimate
+	usage := newUsageTracker()
+	featureUsage := UsageModel
 
 	if len(preflight.pingStarts) > 0 {
 		state.NextPingStart = preflight.pingStarts[0]
@@ -42,14 +43,23 @@ func _paletteOutput(
 	}
 
 	if palette.TransitionMethod == CustomTower {
-		tower, needsTower := GenerateTower(palette, preflight)
+		tower, needsTower, err := GenerateTower(palette, preflight)
+		if err != nil {
+			return err
+		}
 		if !needsTower {
 			log.Fatalln("should not have generated a tower!")
 		}
 		state.Tower = &tower
 	}
 
+	slicedTime := float32(0) // time spent on the sliced G-code, by our own estimate
+	layerSlicedTimes := make([]float32, 0)
+	layerInsertedTimes := make([]float32, 0)
+	layerSlicedStart := float32(0)
+	layerInsertedStart := state.TimeEstimate
 	didFinalSplice := false             // used to prevent calling msfOut.AddLastSplice multiple times
+	toolChangeIndex := 0                // used to look up tool overrides from piece repairs
 	upcomingSparseLayer := false        // used for special-case wipe sequence handling
 	upcomingDoubledSparseLayer := false // used for special-case layer change handling
 	travelToFirstLayerPointSeen := false
@@ -59,6 +69,7 @@ func _paletteOutput(
 		if err := writeLine(writer, "; Sparse tower layer"); err != nil {
 			return err
 		}
+		state.Tower.trackUsage(&usage, state.E.TotalExtrusion)
 		retractDistance := palette.RetractDistance[state.CurrentTool]
 		retractFeedrate := palette.RetractFeedrate[state.CurrentTool]
 		if retractDistance != 0 {
@@ -68,7 +79,7 @@ func _paletteOutput(
 				}
 			}
 		} else if palette.UseFirmwareRetraction {
-			retract := getFirmwareRetract()
+			retract := getFirmwareRetract(&state)
 			if err := writeLines(writer, retract); err != nil {
 				return err
 			}
@@ -94,6 +105,44 @@ func _paletteOutput(
 		return writeLines(writer, layerPaths)
 	}
 
+	// estimate the time into the print, including any paths we've inserted
+	elapsedTime := func() float32 {
+		return slicedTime + state.TimeEstimate - preflight.timeEstimate
+	}
+
+	// the start sequence is counted as part of the first layer
+	endLayerTime := func() {
+		if state.CurrentLayer < 0 {
+			return
+		}
+		layerSlicedTimes = append(layerSlicedTimes, slicedTime-layerSlicedStart)
+		layerInsertedTimes = append(layerInsertedTimes, state.TimeEstimate-layerInsertedStart)
+		layerSlicedStart = slicedTime
+		layerInsertedStart = state.TimeEstimate
+	}
+
+	// estimate the total print time, and the time for each layer so far. The sliced G-code's
+	// time is taken from the slicer's own estimate, if it gave one, and divided between layers
+	// in proportion to our estimate.
+	getTimeEstimates := func() (float32, []float32) {
+		totalTime := state.TimeEstimate
+		slicedScale := float32(1)
+		if preflight.timeEstimate == 0 {
+			totalTime += slicedTime
+		} else if slicedTime > 0 {
+			slicedScale = preflight.timeEstimate / slicedTime
+		}
+		layerTimes := make([]float32, 0, len(layerSlicedTimes)+1)
+		for layer, layerSlicedTime := range layerSlicedTimes {
+			layerTimes = append(layerTimes, layerSlicedTime*slicedScale+layerInsertedTimes[layer])
+		}
+		if state.CurrentLayer >= 0 {
+			// the layer in progress
+			layerTimes = append(layerTimes, (slicedTime-layerSlicedStart)*slicedScale+state.TimeEstimate-layerInsertedStart)
+		}
+		return totalTime, layerTimes
+	}
+
 	restorePathType := func() error {
 		if state.CurrentPathTypeLine != "" {
 			if err := writeLine(writer, state.CurrentPathTypeLine); err != nil {
@@ -108,14 +157,18 @@ func _paletteOutput(
 		return nil
 	}
 
+	dialect := gcode.Dialect{}
+
 	err := readerFn(func(line gcode.Command, lineNumber int) error {
+		annotation := dialect.ParseAnnotation(line)
 		if lineNumber == preflight.printSummaryStart {
 			if err := msfOut.AddLastSplice(state.CurrentTool, state.E.TotalExtrusion); err != nil {
 				return err
 			}
 			didFinalSplice = true // make sure not to do this again at EOF
 			// insert our (more accurate) print summary
-			summary := getPrintSummary(msfOut, state.TimeEstimate)
+			totalTime, layerTimes := getTimeEstimates()
+			summary := getPrintSummary(msfOut, totalTime, layerTimes)
 			if err := writeLines(writer, summary); err != nil {
 				return err
 			}
@@ -131,21 +184,24 @@ func _paletteOutput(
 		} else if line.IsLinearMove() && line.Comment == "retract" && state.E.LastExtrudeWasRetract {
 			// avoid double-retraction after toolchange
 			return nil
-		} else if ptp.IsPathTypeComment(line) {
+		} else if annotation.Kind == gcode.AnnotationFeature {
 			state.CurrentPathTypeLine = line.Raw
-		} else if ptp.IsWidthComment(line) {
+			featureUsage = getFeatureUsage(annotation.Feature)
+		} else if annotation.Kind == gcode.AnnotationWidth {
 			state.CurrentWidthLine = line.Raw
 		} else {
 			// update state
+			slicedTime += getTimeEstimate(line, &state)
+			usage.set(featureUsage, state.E.TotalExtrusion)
 			state.E.TrackInstruction(line)
 			state.XYZF.TrackInstruction(line)
 			state.Temperature.TrackInstruction(line)
 		}
-		if state.NeedsPostTransitionZAdjust && line.IsLinearMove() {
-			_, hasX := line.Params["x"]
-			_, hasY := line.Params["y"]
-			_, hasZ := line.Params["z"]
-			eParam, hasE := line.Params["e"]
+		if state.NeedsPostTransitionZAdjust && (line.IsLinearMove() || line.IsArcMove()) {
+			_, hasX := line.Param("x")
+			_, hasY := line.Param("y")
+			_, hasZ := line.Param("z")
+			eParam, hasE := line.Param("e")
 			isPrintLine := (hasX || hasY) && hasE
 			isRestart := !(hasX || hasY || hasZ) && hasE && state.E.CurrentRetraction+eParam == 0
 			if isPrintLine || isRestart {
@@ -174,13 +230,14 @@ func _paletteOutput(
 			// and when print settings have been restored but before the first linear move
 			if upcomingDoubledSparseLayer &&
 				palette.TransitionMethod == CustomTower &&
-				line.IsTravelToFirstLayerPoint() && !travelToFirstLayerPointSeen {
+				annotation.Kind == gcode.AnnotationTravelToFirstLayerPoint && !travelToFirstLayerPointSeen {
 				if !state.Tower.IsComplete() &&
 					state.CurrentLayer == state.Tower.CurrentLayerIndex &&
 					!state.Tower.CurrentLayerIsDense() {
 					if err := writeLine(writer, "; Doubled sparse tower layer"); err != nil {
 						return err
 					}
+					state.Tower.trackUsage(&usage, state.E.TotalExtrusion)
 					layerPaths, err := state.Tower.GetNextSegment(&state, false)
 					if err != nil {
 						return err
@@ -259,8 +316,13 @@ func _paletteOutput(
 			}
 		} else if upcomingDoubledSparseLayer && line.IsSetPosition() {
 			return nil
-		} else if isToolChange, tool := line.IsToolChange(); isToolChange {
+		} else if isToolChange, tool := palette.GetFlavor().IsToolChange(line); isToolChange {
 			if state.PastStartSequence {
+				toolChangeIndex++
+				if override, ok := preflight.toolOverrides[toolChangeIndex]; ok {
+					// too-short piece merged into the previous one
+					tool = override
+				}
 				if state.FirstToolChange {
 					state.FirstToolChange = false
 					var toolChangeLine string
@@ -283,6 +345,10 @@ func _paletteOutput(
 					if err := writeLine(writer, fmt.Sprintf("; Printing with input %d", state.CurrentTool)); err != nil {
 						return err
 					}
+					if palette.Type == TypeManual {
+						// the filament to load before starting the print
+						msfOut.addFilamentSwap(tool, elapsedTime(), &state)
+					}
 				} else if tool != state.CurrentTool && !palette.TreatAsSingleMaterial {
 					comment := fmt.Sprintf("; Printing with input %d", tool)
 					if err := writeLine(writer, comment); err != nil {
@@ -300,12 +366,23 @@ func _paletteOutput(
 						}
 						state.CurrentTool = tool
 					} else {
+						if palette.Type == TypeManual {
+							swap, err := filamentChange(tool, elapsedTime(), &state)
+							if err != nil {
+								return err
+							}
+							if err := writeLines(writer, swap); err != nil {
+								return err
+							}
+						}
 						if palette.TransitionMethod == CustomTower {
 							if err := writeLine(writer, "; Dense tower segment"); err != nil {
 								return err
 							}
+							state.Tower.trackUsage(&usage, state.E.TotalExtrusion)
 							currentTransition := state.Tower.GetCurrentTransitionInfo()
-							spliceOffset := currentTransition.TransitionLength * (palette.TransitionTarget / 100)
+							transitionTarget := palette.GetTransitionTarget(currentTransition.To, currentTransition.From)
+							spliceOffset := currentTransition.TransitionLength * (transitionTarget / 100)
 							// if purge length is more than transition length, the extra purge is there
 							// to ensure minimum piece lengths are maintained, so the difference between
 							// the two should be included on the end of the previous tool's splice
@@ -328,13 +405,14 @@ func _paletteOutput(
 							if err := msfOut.AddSplice(state.CurrentTool, spliceLength); err != nil {
 								return err
 							}
+							state.PreviousTool = state.CurrentTool
 							state.CurrentTool = tool
 							state.CurrentlyTransitioning = true
 							ptpComment := getPtpStartComment(
 								ptpPurgeLength,
 								ptpTransitionLength,
 								ptpOffset,
-								palette.TransitionTarget,
+								transitionTarget,
 							)
 							if err := writeLines(writer, ptpComment); err != nil {
 								return err
@@ -354,7 +432,7 @@ func _paletteOutput(
 						} else {
 							currentTransition := preflight.transitions[len(msfOut.SpliceList)]
 							currentPurgeLength := currentTransition.PurgeLength
-							spliceOffset := currentTransition.TransitionLength * (palette.TransitionTarget / 100)
+							spliceOffset := currentTransition.TransitionLength * (palette.GetTransitionTarget(currentTransition.To, currentTransition.From) / 100)
 							spliceLength := state.E.TotalExtrusion + spliceOffset - currentTransition.UsableInfill
 							if palette.TransitionMethod == SideTransitions {
 								extra := msfOut.GetRequiredExtraSpliceLength(spliceLength)
@@ -366,9 +444,11 @@ func _paletteOutput(
 							if err := msfOut.AddSplice(state.CurrentTool, spliceLength); err != nil {
 								return err
 							}
+							state.PreviousTool = state.CurrentTool
 							state.CurrentTool = tool
 							state.CurrentlyTransitioning = true
 							if palette.TransitionMethod == SideTransitions {
+								usage.set(UsageSideTransitions, state.E.TotalExtrusion)
 								transition, err := sideTransition(currentPurgeLength, &state)
 								if err != nil {
 									return err
@@ -411,7 +491,8 @@ func _paletteOutput(
 				}
 			}
 			return writeLine(writer, line.Raw)
-		} else if line.Raw == ";LAYER_CHANGE" {
+		} else if annotation.Kind == gcode.AnnotationLayerChange {
+			endLayerTime()
 			state.CurrentLayer++
 			// After the first layer change, insert tower g-code for the last layer before writing layer change line to file.
 			if palette.TransitionMethod == CustomTower {
@@ -427,7 +508,7 @@ func _paletteOutput(
 				}
 			}
 			return writeLine(writer, line.Raw)
-		} else if upcomingSparseLayer && line.Raw == ";WIPE_END" {
+		} else if upcomingSparseLayer && annotation.Kind == gcode.AnnotationWipeEnd {
 			// insert ;WIPE_END before the sparse layer
 			if err := writeLine(writer, line.Raw); err != nil {
 				return err
@@ -448,12 +529,11 @@ func _paletteOutput(
 		} else if line.Raw == ";END OF LAYER CHANGE SEQUENCE" {
 			travelToFirstLayerPointSeen = false
 			return writeLine(writer, line.Raw)
-		} else if palette.TransitionMethod == TransitionTower &&
-			strings.HasPrefix(line.Comment, "TYPE:") {
+		} else if palette.TransitionMethod == TransitionTower && annotation.Kind == gcode.AnnotationFeature {
 			if err := writeLine(writer, line.Raw); err != nil {
 				return err
 			}
-			startingWipeTower := line.Comment == "TYPE:Wipe tower"
+			startingWipeTower := annotation.Feature == gcode.FeatureWipeTower
 			if !state.OnWipeTower && startingWipeTower {
 				// start of the actual transition being printed
 			} else if state.OnWipeTower && !startingWipeTower {
@@ -481,6 +561,19 @@ func _paletteOutput(
 		}
 		didFinalSplice = true
 	}
+	if err := msfOut.ScheduleHotSwaps(); err != nil {
+		return err
+	}
+	if totalLength := msfOut.GetTotalFilamentLength(); totalLength > state.E.TotalExtrusion {
+		usage.set(UsageUnprinted, state.E.TotalExtrusion)
+		usage.finish(totalLength)
+	} else {
+		usage.finish(state.E.TotalExtrusion)
+	}
+	msfOut.Usage = msfOut.getUsageReport(usage)
+	if palette.Type == TypeManual {
+		msfOut.SwapList.PrintTime = elapsedTime()
+	}
 	if palette.Type == TypeP2 && palette.ConnectedMode {
 		// .mcf.gcode -- append footer
 		if err := writeLines(writer, msfOut.GetMSF2Footer()); err != nil {
@@ -491,45 +584,50 @@ func _paletteOutput(
 	return nil
 }
 
-func paletteOutput(inpath, outpath, msfpath string, palette *Palette, preflight *msfPreflight, locals sequences.Locals) error {
-	outfile, createErr := os.Create(outpath)
-	if createErr != nil {
-		return createErr
-	}
-	writer := bufio.NewWriter(outfile)
+// paletteOutput writes the modified G-code to output, and returns the MSF data
+// describing the splices and pings it contains
+func paletteOutput(readLines gcode.LineReader, output io.Writer, palette *Palette, preflight *msfPreflight, locals sequences.Locals) (MSF, error) {
 	msfOut := NewMSF(palette)
+	connectedP2 := palette.Type == TypeP2 && palette.ConnectedMode
 
-	readerFn := func(callback gcode.LineCallback) error {
-		return gcode.ReadByLine(inpath, callback)
+	if !connectedP2 && !palette.needsSpliceStressPass() {
+		writer := bufio.NewWriter(output)
+		if err := _paletteOutput(readLines, writer, &msfOut, palette, preflight, locals); err != nil {
+			return msfOut, err
+		}
+		return msfOut, writer.Flush()
 	}
 
-	err := _paletteOutput(readerFn, writer, &msfOut, palette, preflight, locals)
+	// .mcf.gcode -- the header can only be created once the whole print has been processed,
+	// and the splice stress pass needs every splice, so write the body to a temporary file until then
+	body, err := createTempOutput(output)
 	if err != nil {
-		return err
+		return msfOut, err
 	}
-
-	// finalize outfile now
-	if err := writer.Flush(); err != nil {
-		return err
+	defer os.Remove(body.Name())
+	defer body.Close()
+	writer := bufio.NewWriter(body)
+	if err := _paletteOutput(readLines, writer, &msfOut, palette, preflight, locals); err != nil {
+		return msfOut, err
 	}
-	if err := outfile.Close(); err != nil {
-		return err
+	if err := writer.Flush(); err != nil {
+		return msfOut, err
 	}
-	if palette.Type == TypeP2 && palette.ConnectedMode {
-		// .mcf.gcode -- prepend header instead of writing to separate file
-		header := msfOut.GetMSF2Header()
-		if err := prependFile(outpath, header); err != nil {
-			return err
-		}
-	} else {
-		msfStr, err := msfOut.CreateMSF()
-		if err != nil {
-			return err
+	if connectedP2 {
+		if _, err := io.WriteString(output, msfOut.GetMSF2Header()); err != nil {
+			return msfOut, err
 		}
-		if err := ioutil.WriteFile(msfpath, []byte(msfStr), 0644); err != nil {
-			return err
+	}
+	if palette.needsSpliceStressPass() {
+		writer := bufio.NewWriter(output)
+		if err := spliceStressPass(gcode.FileLineReader(body.Name()), writer, &msfOut); err != nil {
+			return msfOut, err
 		}
+		return msfOut, writer.Flush()
 	}
-
-	return nil
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return msfOut, err
+	}
+	_, err = io.Copy(output, body)
+	return msfOut, err
 }
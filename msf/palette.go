@@ -2,6 +2,7 @@ package msf
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"strings"
@@ -11,11 +12,13 @@ import (
 )
 
 type Material struct {
-	ID         string `json:"id"`
-	Index      int    `json:"index"`
-	FilamentID int    `json:"filamentId"`
-	Name       string `json:"name"`
-	Color      string `json:"color"`
+	ID         string  `json:"id"`
+	Index      int     `json:"index"`
+	FilamentID int     `json:"filamentId"`
+	Name       string  `json:"name"`
+	Color      string  `json:"color"`
+	Density    float32 `json:"density"` // g/cm^3, if known
+	Price      float32 `json:"price"`   // per kg, if known
 }
 
 type SpliceSettings struct {
@@ -50,19 +53,24 @@ type Palette struct {
 
 	// transitions
 	TransitionMethod    TransitionMethod `json:"TransitionMethod"`
-	TransitionLengths   [][]float32      `json:"transitionLengths"` // mm
+	TransitionLengths   [][]float32      `json:"transitionLengths"` // mm, [to][from] (0 == suggest from colors, for generated transitions)
 	TransitionTarget    float32          `json:"transitionTarget"`  // 0..100
+	TransitionTargets   [][]*float32     `json:"transitionTargets"` // 0..100, [to][from] (null == use TransitionTarget)
 	InfillTransitioning bool             `json:"infillTransitioning"`
+	PurgeFeatures       []string         `json:"purgeFeatures"` // feature types to purge into (empty == internal infill)
+	PieceRepair         PieceRepair      `json:"pieceRepair"`   // how to handle pieces that are too short
 
 	// transition tower generation
 	TowerSize                 [2]float32 `json:"towerSize"`
 	TowerPosition             [2]float32 `json:"towerPosition"`             // center of tower
+	TowerAutoPlace            bool       `json:"towerAutoPlace"`            // move the tower if it would overlap the print
 	TowerMinDensity           float32    `json:"towerMinDensity"`           // 0..100
 	TowerMinFirstLayerDensity float32    `json:"towerMinFirstLayerDensity"` // 0..100
 	TowerMaxDensity           float32    `json:"towerMaxDensity"`           // 0..100
 	TowerMinBrims             int        `json:"towerMinBrims"`
 	TowerSpeed                []float32  `json:"towerSpeed"`               // mm/s
 	FirstLayerTowerSpeed      []float32  `json:"firstLayerTowerSpeed"`     // mm/s
+	MaxVolumetricFlow         []float32  `json:"maxVolumetricFlow"`        // mm3/s, per material (0 == unlimited)
 	TowerExtrusionWidth       float32    `json:"towerExtrusionWidth"`      // mm
 	TowerExtrusionMultiplier  float32    `json:"towerExtrusionMultiplier"` // unitless
 	TowerFirstLayerPerimeters bool       `json:"towerFirstLayerPerimeters"`
@@ -80,6 +88,10 @@ type Palette struct {
 	ZLift                     []float32  `json:"zLift"`   // mm
 	ZOffset                   float32    `json:"zOffset"` // mm
 
+	// transition tower shape and infill
+	TowerShape  TowerShape  `json:"towerShape"`
+	TowerInfill TowerInfill `json:"towerInfill"`
+
 	// side transition scripting
 	PreSideTransitionSequence  string `json:"preSideTransitionSequence"`
 	SideTransitionSequence     string `json:"sideTransitionSequence"`
@@ -97,10 +109,36 @@ type Palette struct {
 	SideTransitionEdge       gcode.Direction `json:"sideTransitionEdge"`
 	SideTransitionEdgeOffset float32         `json:"sideTransitionEdgeOffset"` // mm
 
+	// side transition purge locations
+	SideTransitionLocations     []SideTransitionLocation `json:"sideTransitionLocations"` // rotate between these instead
+	PurgeLocationRotation       PurgeLocationRotation    `json:"purgeLocationRotation"`
+	PurgeLocationCapacity       float32                  `json:"purgeLocationCapacity"` // mm of filament per location (0 == unlimited)
+	EmptyPurgeLocationsSequence string                   `json:"emptyPurgeLocationsSequence"`
+	EmptyPurgeLocationsScript   printerscript.Tree
+
+	// manual filament swaps
+	FilamentChangeSequence string `json:"filamentChangeSequence"` // empty == firmware's filament change command
+	FilamentChangeScript   printerscript.Tree
+
+	// splice stress
+	ExtruderGearDistance      float32 `json:"extruderGearDistance"` // mm (0 == default)
+	MeltZoneLength            float32 `json:"meltZoneLength"`       // mm (0 == default)
+	AnalyzeSpliceStress       bool    `json:"analyzeSpliceStress"`
+	SuppressSpliceRetractions bool    `json:"suppressSpliceRetractions"` // skip retractions that would pull a splice back through the gears
+
 	// pings
 	PingOffTowerDistance float32 `json:"pingOffTowerDistance"` // mm
 	JogPauses            bool    `json:"jogPauses"`
 
+	// hot swaps
+	SpoolLengths     []float32 `json:"spoolLengths"`     // mm, per drive (0 == unknown)
+	EquivalentInputs [][]int   `json:"equivalentInputs"` // groups of drives loaded with the same material
+
+	// firmware
+	FirmwareFlavor string       `json:"firmwareFlavor"` // e.g. marlin, reprap, klipper (empty == generic)
+	Flavor         gcode.Flavor `json:"-"`
+	HomePosition   [3]float32   `json:"homePosition"` // mm, machine XYZ after homing with G28
+
 	// P2/P3
 	ClearBufferCommand string `json:"clearBufferCommand"`
 	ConnectedMode      bool   `json:"connectedMode"`
@@ -111,6 +149,7 @@ type Palette struct {
 	LoadingOffset     int     `json:"loadingOffset"`     // scroll wheel counts
 	PrintValue        int     `json:"printValue"`        // scroll wheel counts
 	CalibrationLength float32 `json:"calibrationLength"` // mm
+	pulsesPerMM       float32 // set when decoded from an MSF1 file, which stores the ratio directly
 }
 
 func LoadPaletteFromFile(path string) (Palette, error) {
@@ -130,6 +169,57 @@ func LoadPaletteFromFile(path string) (Palette, error) {
 		palette.BowdenTubeLength = BowdenDefault
 	}
 
+	flavor, err := gcode.FlavorByName(palette.FirmwareFlavor)
+	if err != nil {
+		return palette, err
+	}
+	palette.Flavor = flavor
+	if palette.UseFirmwareRetraction && flavor.FirmwareRetract() == "" {
+		return palette, fmt.Errorf("%s firmware does not support firmware retraction", flavor.Name())
+	}
+
+	if palette.TowerShape == "" {
+		palette.TowerShape = TowerShapeRectangle
+	}
+	if palette.TowerInfill == "" {
+		palette.TowerInfill = TowerInfillDiagonal
+	}
+	if palette.Type == TypeManual && palette.InfillTransitioning {
+		return palette, fmt.Errorf("infill transitioning is not possible with manual filament swaps")
+	}
+	for i, feature := range palette.PurgeFeatures {
+		palette.PurgeFeatures[i] = gcode.NormalizeFeature(feature)
+	}
+
+	switch palette.TowerShape {
+	case TowerShapeRectangle, TowerShapeCylinder:
+	default:
+		return palette, fmt.Errorf("unknown tower shape '%s'", palette.TowerShape)
+	}
+	switch palette.TowerInfill {
+	case TowerInfillDiagonal, TowerInfillRectilinear, TowerInfillConcentric, TowerInfillSerpentine:
+	default:
+		return palette, fmt.Errorf("unknown tower infill pattern '%s'", palette.TowerInfill)
+	}
+
+	switch palette.PieceRepair {
+	case PieceRepairNone, PieceRepairExtendPurge, PieceRepairMerge:
+	default:
+		return palette, fmt.Errorf("unknown piece repair strategy '%s'", palette.PieceRepair)
+	}
+	if palette.PieceRepair == PieceRepairExtendPurge && palette.TransitionMethod == TransitionTower {
+		// the slicer's tower has a fixed amount of purge for each transition
+		return palette, fmt.Errorf("purges cannot be extended when the slicer generates the transition tower")
+	}
+
+	for _, group := range palette.EquivalentInputs {
+		for _, drive := range group {
+			if drive < 0 || drive >= palette.GetInputCount() {
+				return palette, fmt.Errorf("equivalent input %d does not exist", drive+1)
+			}
+		}
+	}
+
 	// lex and parse scripts just once now, and re-use the parse trees when evaluating
 	palette.PreSideTransitionSequence = printerscript.Normalize(palette.PreSideTransitionSequence)
 	if len(strings.TrimSpace(palette.PreSideTransitionSequence)) > 0 {
@@ -155,6 +245,25 @@ func LoadPaletteFromFile(path string) (Palette, error) {
 		}
 		palette.PostSideTransitionScript = tree
 	}
+	palette.EmptyPurgeLocationsSequence = printerscript.Normalize(palette.EmptyPurgeLocationsSequence)
+	if len(strings.TrimSpace(palette.EmptyPurgeLocationsSequence)) > 0 {
+		tree, err := printerscript.LexAndParse(palette.EmptyPurgeLocationsSequence)
+		if err != nil {
+			return palette, err
+		}
+		palette.EmptyPurgeLocationsScript = tree
+	}
+	palette.FilamentChangeSequence = printerscript.Normalize(palette.FilamentChangeSequence)
+	if len(strings.TrimSpace(palette.FilamentChangeSequence)) > 0 {
+		tree, err := printerscript.LexAndParse(palette.FilamentChangeSequence)
+		if err != nil {
+			return palette, err
+		}
+		palette.FilamentChangeScript = tree
+	}
+	if err := validatePurgeLocations(palette); err != nil {
+		return palette, err
+	}
 
 	return palette, nil
 }
@@ -166,12 +275,30 @@ func (p Palette) ProductName() string {
 	return "Palette"
 }
 
+// GetFlavor returns the firmware flavor of the printer.
+func (p Palette) GetFlavor() gcode.Flavor {
+	return gcode.FlavorOrDefault(p.Flavor)
+}
+
+// IsPurgeFeature returns true if transitions may purge into a feature type, when infill transitioning
+func (p Palette) IsPurgeFeature(feature string) bool {
+	if len(p.PurgeFeatures) == 0 {
+		return feature == gcode.FeatureInternalInfill
+	}
+	for _, purgeFeature := range p.PurgeFeatures {
+		if feature == purgeFeature {
+			return true
+		}
+	}
+	return false
+}
+
 func (p Palette) SupportsPings() bool {
-	return p.Type != TypeElement
+	return p.Type != TypeElement && p.Type != TypeManual
 }
 
 func (p Palette) GetInputCount() int {
-	if p.Type == TypeElement {
+	if p.Type == TypeElement || p.Type == TypeManual {
 		return 8
 	}
 	if p.Type == TypeP3 && p.Model == ModelP3Pro {
@@ -244,10 +371,17 @@ func (p Palette) GetSpliceMinLength() float32 {
 	if p.Type == TypeElement {
 		return MinSpliceLengthElement
 	}
+	if p.Type == TypeManual {
+		// filament is swapped by hand, so pieces can be any length
+		return 0
+	}
 	return MinSpliceLength
 }
 
 func (p Palette) GetPulsesPerMM() float32 {
+	if p.pulsesPerMM > 0 {
+		return p.pulsesPerMM
+	}
 	if p.PrintValue == 0 || p.CalibrationLength == 0 {
 		return 0
 	}
@@ -273,11 +407,103 @@ func (p Palette) GetEffectiveLoadingOffset() float32 {
 	return (float32(p.LoadingOffset) / ppm) + CutterToScrollWheel
 }
 
+// SupportsHotSwaps returns true if the MSF format for this Palette
+// can instruct it to swap between equivalent inputs mid-print.
+func (p Palette) SupportsHotSwaps() bool {
+	return p.Type != TypeElement && p.Type != TypeManual
+}
+
+// GetSpoolLength returns the length of filament loaded in a drive,
+// or +Inf if unknown.
+func (p Palette) GetSpoolLength(drive int) float32 {
+	if drive < len(p.SpoolLengths) && p.SpoolLengths[drive] > 0 {
+		return p.SpoolLengths[drive]
+	}
+	return posInf
+}
+
+// GetEquivalentInputs returns the other drives loaded with
+// the same material as drive, in order of preference.
+func (p Palette) GetEquivalentInputs(drive int) []int {
+	for _, group := range p.EquivalentInputs {
+		for _, member := range group {
+			if member != drive {
+				continue
+			}
+			equivalents := make([]int, 0, len(group)-1)
+			for _, other := range group {
+				if other != drive {
+					equivalents = append(equivalents, other)
+				}
+			}
+			return equivalents
+		}
+	}
+	return nil
+}
+
 func (p Palette) GetTransitionLength(toTool, fromTool int) float32 {
-	return p.TransitionLengths[toTool][fromTool]
+	var length float32
+	if toTool < len(p.TransitionLengths) && fromTool < len(p.TransitionLengths[toTool]) {
+		length = p.TransitionLengths[toTool][fromTool]
+	}
+	if length == 0 && (p.TransitionMethod == CustomTower || p.TransitionMethod == SideTransitions) {
+		// only suggest lengths for transitions we generate -- the slicer's tower
+		// already decided how much to purge
+		return p.GetSuggestedTransitionLength(toTool, fromTool)
+	}
+	return length
 }
 
-func (p Palette) GetTowerPrintSpeed(toTool, fromTool, layerIndex int) float32 {
+// GetSuggestedTransitionLength estimates a transition length from the colors of the two materials
+func (p Palette) GetSuggestedTransitionLength(toTool, fromTool int) float32 {
+	var toColor, fromColor string
+	if toTool < len(p.MaterialMeta) {
+		toColor = p.MaterialMeta[toTool].Color
+	}
+	if fromTool < len(p.MaterialMeta) {
+		fromColor = p.MaterialMeta[fromTool].Color
+	}
+	return suggestTransitionLength(fromColor, toColor)
+}
+
+// GetTransitionTarget returns how far into a transition the splice should be, as a percentage
+func (p Palette) GetTransitionTarget(toTool, fromTool int) float32 {
+	if p.Type == TypeManual {
+		// the filament is swapped before purging starts
+		return 0
+	}
+	if toTool < len(p.TransitionTargets) && fromTool < len(p.TransitionTargets[toTool]) &&
+		p.TransitionTargets[toTool][fromTool] != nil {
+		return *p.TransitionTargets[toTool][fromTool]
+	}
+	return p.TransitionTarget
+}
+
+// GetMaxVolumetricFlow returns the most filament a tool's material can melt,
+// in mm3/s, or +Inf if unlimited.
+func (p Palette) GetMaxVolumetricFlow(tool int) float32 {
+	if tool < len(p.MaxVolumetricFlow) && p.MaxVolumetricFlow[tool] > 0 {
+		return p.MaxVolumetricFlow[tool]
+	}
+	return posInf
+}
+
+func (p Palette) getTransitionVolumetricFlow(toTool, fromTool int) float32 {
+	// both materials pass through the hot end during a transition
+	toFlow := p.GetMaxVolumetricFlow(toTool)
+	fromFlow := p.GetMaxVolumetricFlow(fromTool)
+	if toFlow < fromFlow {
+		return toFlow
+	}
+	return fromFlow
+}
+
+func (p Palette) getTowerCrossSection(extrusionWidth, layerThickness float32) float32 {
+	return getExtrusionVolume(extrusionWidth, layerThickness, 1) * (p.TowerExtrusionMultiplier / 100)
+}
+
+func (p Palette) GetTowerPrintSpeed(toTool, fromTool, layerIndex int, extrusionWidth, layerThickness float32) float32 {
 	// use the slower of the two material settings for this transition
 	toSpeed := p.TowerSpeed[toTool]
 	fromSpeed := p.TowerSpeed[fromTool]
@@ -285,8 +511,24 @@ func (p Palette) GetTowerPrintSpeed(toTool, fromTool, layerIndex int) float32 {
 		toSpeed = p.FirstLayerTowerSpeed[toTool]
 		fromSpeed = p.FirstLayerTowerSpeed[fromTool]
 	}
+	speed := fromSpeed
 	if toSpeed < fromSpeed {
-		return toSpeed * 60
+		speed = toSpeed
 	}
-	return fromSpeed * 60
+	// don't extrude faster than the hot end can melt
+	flow := p.getTransitionVolumetricFlow(toTool, fromTool)
+	crossSection := p.getTowerCrossSection(extrusionWidth, layerThickness)
+	return clampToVolumetricFlow(speed, flow, crossSection) * 60
+}
+
+func (p Palette) GetSparseTowerPrintSpeed(tool int, extrusionWidth, layerThickness float32) float32 {
+	flow := p.GetMaxVolumetricFlow(tool)
+	crossSection := p.getTowerCrossSection(extrusionWidth, layerThickness)
+	return clampToVolumetricFlow(p.TowerSpeed[tool], flow, crossSection) * 60
+}
+
+// GetSideTransitionPurgeSpeed returns the feedrate of side transition purges, in mm/min
+func (p Palette) GetSideTransitionPurgeSpeed(toTool, fromTool int) float32 {
+	flow := p.getTransitionVolumetricFlow(toTool, fromTool)
+	return clampToVolumetricFlow(p.SideTransitionPurgeSpeed, flow, filamentPiRSquared) * 60
 }
@@ -4,20 +4,35 @@ import (
 	"fmt"
 	"math"
 	"mosaicmfg.com/ps-postprocess/gcode"
+	"strings"
 )
 
-func getDwellPause(durationMS int) string {
+// estimatePauseTime adds the duration of a pause's lines to the time estimate.
+// Pauses end where they started, so the lines are tracked on a copy of the position.
+func estimatePauseTime(sequence string, state *State) {
+	position := state.XYZF
+	flavor := state.Palette.GetFlavor()
+	for _, line := range strings.Split(strings.TrimSuffix(sequence, EOL), EOL) {
+		command := gcode.ParseLine(line)
+		state.TimeEstimate += gcode.EstimateDuration(command, &position, &state.E, flavor)
+		position.TrackInstruction(command)
+	}
+}
+
+func getDwellPause(durationMS int, state *State) string {
+	flavor := state.Palette.GetFlavor()
 	sequence := ""
 	for durationMS > 0 {
 		if durationMS > 4000 {
-			sequence += "G4 P4000" + EOL
+			sequence += flavor.Dwell(4000) + EOL
 			sequence += "G1" + EOL
 			durationMS -= 4000
 		} else {
-			sequence += fmt.Sprintf("G4 P%d%s", durationMS, EOL)
+			sequence += flavor.Dwell(durationMS) + EOL
 			durationMS = 0
 		}
 	}
+	estimatePauseTime(sequence, state)
 	return sequence
 }
 
@@ -41,11 +56,18 @@ func getJogPause(durationMS int, direction gcode.Direction, state *State) string
 		x2 += durationMM
 	}
 
+	if state.XYZF.RelativeXYZ {
+		// jog out by the offset, then back by the same amount
+		x2, y2 = x2-x1, y2-y1
+		x1, y1 = -x2, -y2
+	}
+
 	sequence := ""
 	for i := 0; i < totalJogs; i++ {
 		sequence += fmt.Sprintf("G1 X%.3f Y%.3f F%d%s", x2, y2, feedrate, EOL)
 		sequence += fmt.Sprintf("G1 X%.3f Y%.3f F%d%s", x1, y1, feedrate, EOL)
 	}
+	estimatePauseTime(sequence, state)
 
 	return sequence
 }
@@ -74,7 +96,7 @@ func getTowerPause(durationMS int, state *State) string {
 		retractFeedrate := state.Palette.RetractFeedrate[state.CurrentTool]
 		sequence += getRetract(state, retractDistance, retractFeedrate)
 	} else if state.Palette.UseFirmwareRetraction {
-		sequence += getFirmwareRetract()
+		sequence += getFirmwareRetract(state)
 	}
 	currentF := state.XYZF.CurrentFeedrate
 	currentX := state.XYZF.CurrentX
@@ -94,9 +116,8 @@ func getTowerPause(durationMS int, state *State) string {
 	if state.Palette.JogPauses {
 		sequence += getTowerJogPause(durationMS, state)
 	} else {
-		sequence += getDwellPause(durationMS)
+		sequence += getDwellPause(durationMS, state)
 	}
-	state.TimeEstimate += float32(durationMS / 1000)
 	if state.Palette.PingOffTowerDistance > 0 {
 		// move back onto the tower after pausing
 		sequence += getXYTravel(state, currentX, currentY, currentF, "")
@@ -105,7 +126,7 @@ func getTowerPause(durationMS int, state *State) string {
 		restartFeedrate := state.Palette.RestartFeedrate[state.CurrentTool]
 		sequence += getRestart(state, restartDistance, restartFeedrate)
 	} else if state.Palette.UseFirmwareRetraction {
-		sequence += getFirmwareRestart()
+		sequence += getFirmwareRestart(state)
 	}
 	return sequence
 }
@@ -174,23 +195,21 @@ func doSideTransitionInPlaceAccessoryPing(state *State) (string, float32) {
 	if state.Palette.JogPauses {
 		sequence += getSideTransitionInPlaceJogPause(Ping1PauseLength, state)
 	} else {
-		sequence += getDwellPause(Ping1PauseLength)
+		sequence += getDwellPause(Ping1PauseLength, state)
 	}
-	state.TimeEstimate += float32(Ping1PauseLength / 1000)
 
 	// extrusion between pauses
 	pingStartExtrusion := state.E.TotalExtrusion
 	purgeLength := state.PingExtrusion
-	sequence += getPurge(state, purgeLength, state.Palette.SideTransitionPurgeSpeed*60)
+	sequence += getPurge(state, purgeLength, state.Palette.GetSideTransitionPurgeSpeed(state.CurrentTool, state.PreviousTool))
 
 	// second pause
 	sequence += fmt.Sprintf("; Ping %d pause 2%s", len(state.MSF.PingList)+1, EOL)
 	if state.Palette.JogPauses {
 		sequence += getSideTransitionInPlaceJogPause(Ping2PauseLength, state)
 	} else {
-		sequence += getDwellPause(Ping2PauseLength)
+		sequence += getDwellPause(Ping2PauseLength, state)
 	}
-	state.TimeEstimate += float32(Ping2PauseLength / 1000)
 	state.MSF.AddPingWithExtrusion(pingStartExtrusion, purgeLength)
 	state.LastPingStart = pingStartExtrusion
 
@@ -198,8 +217,8 @@ func doSideTransitionInPlaceAccessoryPing(state *State) (string, float32) {
 }
 
 func getSideTransitionOnEdgeJogPauseDirection(state *State) gcode.Direction {
-	if state.Palette.SideTransitionEdge == gcode.North ||
-		state.Palette.SideTransitionEdge == gcode.South {
+	if state.SideTransitionLocation.Edge == gcode.North ||
+		state.SideTransitionLocation.Edge == gcode.South {
 		if state.XYZF.CurrentX-state.Palette.PrintBedMinX >
 			state.Palette.PrintBedMaxX-state.XYZF.CurrentX {
 			return gcode.West
@@ -225,9 +244,8 @@ func doSideTransitionOnEdgeAccessoryPing(state *State) (string, float32) {
 	if state.Palette.JogPauses {
 		sequence += getSideTransitionOnEdgeJogPause(Ping1PauseLength, jogPauseDirection, state)
 	} else {
-		sequence += getDwellPause(Ping1PauseLength)
+		sequence += getDwellPause(Ping1PauseLength, state)
 	}
-	state.TimeEstimate += float32(Ping1PauseLength / 1000)
 
 	// extrusion between pauses
 	pingStartExtrusion := state.E.TotalExtrusion
@@ -279,9 +297,8 @@ func doSideTransitionOnEdgeAccessoryPing(state *State) (string, float32) {
 	if state.Palette.JogPauses {
 		sequence += getSideTransitionOnEdgeJogPause(Ping2PauseLength, jogPauseDirection, state)
 	} else {
-		sequence += getDwellPause(Ping2PauseLength)
+		sequence += getDwellPause(Ping2PauseLength, state)
 	}
-	state.TimeEstimate += float32(Ping2PauseLength / 1000)
 	state.MSF.AddPingWithExtrusion(pingStartExtrusion, purgeLength)
 	state.LastPingStart = pingStartExtrusion
 
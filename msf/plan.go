@@ -0,0 +1,161 @@
+package msf
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
+
+type planTowerLayer struct {
+	TopZ        float32 `json:"topZ"`      // mm
+	Thickness   float32 `json:"thickness"` // mm
+	Density     float32 `json:"density"`   // 0..1
+	Transitions int     `json:"transitions"`
+}
+
+type planTower struct {
+	BoundingBox gcode.BoundingBox `json:"boundingBox"`
+	Shape       TowerShape        `json:"shape"`
+	Infill      TowerInfill       `json:"infill"`
+	Width       float32           `json:"width"`  // mm, X
+	Depth       float32           `json:"depth"`  // mm, Y
+	Height      float32           `json:"height"` // mm, Z
+	BrimCount   int               `json:"brimCount"`
+	Layers      []planTowerLayer  `json:"layers"`
+}
+
+type planViolation struct {
+	PieceViolation
+	Layer int `json:"layer"` // layer of the transition creating the splice, or -1 for the end of the print
+}
+
+type planReport struct {
+	NeedsPalette    bool            `json:"needsPalette"`
+	Transitions     []Transition    `json:"transitions"`
+	Tower           *planTower      `json:"tower,omitempty"` // only for generated towers
+	FilamentByDrive []float32       `json:"filamentByDrive"` // mm
+	TotalFilament   float32         `json:"totalFilament"`   // mm
+	PingCount       int             `json:"pingCount"`
+	HotSwapCount    int             `json:"hotSwapCount"`
+	Violations      []planViolation `json:"violations"`
+	Repairs         []RepairRecord  `json:"repairs"`
+}
+
+// plan runs the same preflight, tower generation and splice logic as a conversion,
+// discarding the G-code and collecting every piece that would be too short
+// rather than stopping at the first.
+func plan(readLines gcode.LineReader, palette *Palette, locals sequences.Locals) (planReport, error) {
+	report := planReport{
+		Transitions: make([]Transition, 0),
+		Violations:  make([]planViolation, 0),
+		Repairs:     make([]RepairRecord, 0),
+	}
+
+	preflightResults, err := _preflight(readLines, palette)
+	if err != nil {
+		return report, err
+	}
+	if !preflightResults.needsPalette(palette) {
+		return report, nil
+	}
+	report.NeedsPalette = true
+	report.Transitions = append(report.Transitions, preflightResults.transitions...)
+
+	if palette.TransitionMethod == CustomTower {
+		tower, needsTower, err := GenerateTower(palette, &preflightResults)
+		if err != nil {
+			return report, err
+		}
+		if needsTower {
+			report.Tower = &planTower{
+				BoundingBox: tower.BoundingBox,
+				Width:       tower.BoundingBox.Max[0] - tower.BoundingBox.Min[0],
+				Depth:       tower.BoundingBox.Max[1] - tower.BoundingBox.Min[1],
+				Height:      tower.BoundingBox.Max[2] - tower.BoundingBox.Min[2],
+				BrimCount:   tower.BrimCount,
+				Layers:      make([]planTowerLayer, 0, len(tower.Layers)),
+			}
+			for _, layer := range tower.Layers {
+				report.Tower.Layers = append(report.Tower.Layers, planTowerLayer{
+					TopZ:        layer.TopZ,
+					Thickness:   layer.Thickness,
+					Density:     layer.Density,
+					Transitions: len(layer.Transitions),
+				})
+			}
+		}
+	}
+
+	msfOut := NewMSF(palette)
+	msfOut.collectViolations = true
+	writer := bufio.NewWriter(ioutil.Discard)
+	if err := _paletteOutput(readLines, writer, &msfOut, palette, &preflightResults, locals); err != nil {
+		return report, err
+	}
+
+	report.FilamentByDrive = msfOut.GetFilamentLengthsByDrive()
+	report.TotalFilament = msfOut.GetTotalFilamentLength()
+	report.PingCount = len(msfOut.PingList)
+	report.HotSwapCount = len(msfOut.HotSwapList)
+	report.Repairs = append(report.Repairs, msfOut.Repairs...)
+	for _, violation := range msfOut.Violations {
+		// each transition creates one splice, and the last splice ends the print
+		layer := -1
+		if spliceIndex := violation.Splice - 1; spliceIndex < len(preflightResults.transitions) {
+			layer = preflightResults.transitions[spliceIndex].Layer
+		}
+		report.Violations = append(report.Violations, planViolation{
+			PieceViolation: violation,
+			Layer:          layer,
+		})
+	}
+	return report, nil
+}
+
+// PlanForPalette performs a dry run of ConvertForPalette, and prints
+// a JSON report of the transitions, tower and splices it would create.
+func PlanForPalette(argv []string) {
+	argc := len(argv)
+
+	if argc < 4 {
+		log.Fatalln("expected 4 command-line arguments")
+	}
+	inpath := argv[0]                // unmodified G-code file
+	palettepath := argv[1]           // serialized Palette data
+	localsPath := argv[2]            // JSON-stringified locals
+	perExtruderLocalsPath := argv[3] // JSON-stringified locals
+
+	palette, err := LoadPaletteFromFile(palettepath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	locals := sequences.NewLocals()
+	if err := locals.LoadGlobal(localsPath); err != nil {
+		log.Fatalln(err)
+	}
+	if err := locals.LoadPerExtruder(perExtruderLocalsPath); err != nil {
+		log.Fatalln(err)
+	}
+
+	readLines, err := gcode.OpenLineReader(inpath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	report, err := plan(readLines, &palette, locals)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	bytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := os.Stdout.Write(append(bytes, '\n')); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,50 @@
+package msf
+
+import (
+	"path"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
+
+func Test_Plan(t *testing.T) {
+	palette, err := LoadPaletteFromFile(path.Join("test-files", "2", "palette.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readLines := gcode.FileLineReader(path.Join("test-files", "2", "print.gcode"))
+	report, err := plan(readLines, &palette, sequences.NewLocals())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.NeedsPalette {
+		t.Fatal("expected print to need Palette")
+	}
+	if len(report.Transitions) == 0 {
+		t.Fatal("expected transitions")
+	}
+	if report.Tower == nil || len(report.Tower.Layers) == 0 {
+		t.Fatal("expected a tower")
+	}
+	if len(report.Violations) != 0 {
+		t.Fatalf("expected no violations, got %v", report.Violations)
+	}
+}
+
+func Test_CollectPieceViolations(t *testing.T) {
+	palette := getTestPalette(30)
+	msf := NewMSF(&palette)
+	msf.collectViolations = true
+	for _, length := range []float32{200, 250, 400, 420} {
+		if err := msf.AddSplice(0, length); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(msf.Violations) != 2 {
+		t.Fatalf("expected 2 violations, got %d", len(msf.Violations))
+	}
+	if v := msf.Violations[1]; v.Splice != 4 || v.PieceLength != 20 {
+		t.Fatalf("unexpected violation %v", v)
+	}
+}
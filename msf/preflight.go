@@ -2,7 +2,6 @@ package msf
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 
 	"mosaicmfg.com/ps-postprocess/gcode"
@@ -12,13 +11,17 @@ import (
 const maxZPrecision = 5
 
 type Transition struct {
-	Layer            int
-	From             int
-	To               int
-	TotalExtrusion   float32 // total non-tower extrusion at start of transition
-	TransitionLength float32 // actual transition length as specified by user
-	PurgeLength      float32 // amount of filament to extrude
-	UsableInfill     float32 // subtract this amount from the splice length
+	Layer            int                `json:"layer"`
+	From             int                `json:"from"`
+	To               int                `json:"to"`
+	TotalExtrusion   float32            `json:"totalExtrusion"`       // total non-tower extrusion at start of transition
+	TransitionLength float32            `json:"transitionLength"`     // actual transition length as specified by user
+	PurgeLength      float32            `json:"purgeLength"`          // amount of filament to extrude
+	UsableInfill     float32            `json:"usableInfill"`         // purge absorbed by features printed before the transition
+	AbsorbedBy       map[string]float32 `json:"absorbedBy,omitempty"` // share of UsableInfill printed as each feature type
+
+	line         int     // line number of the tool change
+	spliceLength float32 // estimated splice length, used for piece length checks in preflight
 }
 
 func (t Transition) String() string {
@@ -47,9 +50,14 @@ type msfPreflight struct {
 	// used for postprocess-generated towers
 	layerTopZs         []float32            // printing height of each layer (i.e. the Z value of the top of these paths)
 	layerThicknesses   []float32            // thickness of each layer in mm (i.e. layerTopZs[n] - layerTopZs[n-1])
+	layerFootprints    []gcode.BoundingBox  // XY extents of the print's extrusions on each layer
 	transitionsByLayer map[int][]Transition // array of Transition per layer
 	transitions        []Transition         // same data as transitionsByLayer but flattened into 1D
 
+	// used for piece repairs
+	toolOverrides map[int]int // tool to use instead, by index of tool change after the start sequence
+	repairs       []RepairRecord
+
 	// used for side transition custom scripts
 	transitionNextPositions             []SideTransitionLookahead
 	timeEstimate                        float32 // seconds
@@ -67,6 +75,10 @@ func (mp *msfPreflight) totalDrivesUsed() int {
 	return total
 }
 
+func (mp *msfPreflight) needsPalette(palette *Palette) bool {
+	return mp.totalDrivesUsed() > 1 || palette.TreatAsSingleMaterial
+}
+
 type SideTransitionLookahead struct {
 	X       float32 // X position of the next print line after the transition
 	Y       float32 // Y position of the next print line after the transition
@@ -75,7 +87,33 @@ type SideTransitionLookahead struct {
 	MovedZ  bool    // true iff Z movement was seen during lookahead process
 }
 
-func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palette) (msfPreflight, error) {
+// purgeRunSegment is one feature of a run of features that transitions can purge into
+type purgeRunSegment struct {
+	feature string
+	startE  float32 // total extrusion at the start of the feature
+}
+
+// getAbsorbedBy divides the last `usable` mm of extrusion in a purge run between its feature types
+func getAbsorbedBy(purgeRun []purgeRunSegment, endE, usable float32) map[string]float32 {
+	if usable <= 0 {
+		return nil
+	}
+	absorbedBy := make(map[string]float32)
+	for i := len(purgeRun) - 1; i >= 0 && usable > 0; i-- {
+		amount := endE - purgeRun[i].startE
+		if amount > usable {
+			amount = usable
+		}
+		if amount > 0 {
+			absorbedBy[purgeRun[i].feature] += amount
+			usable -= amount
+		}
+		endE = purgeRun[i].startE
+	}
+	return absorbedBy
+}
+
+func _preflight(readerFn gcode.LineReader, palette *Palette) (msfPreflight, error) {
 	results := msfPreflight{
 		drivesUsed:                          make([]bool, palette.GetInputCount()),
 		pingStarts:                          make([]float32, 0),
@@ -85,6 +123,8 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 		totalLayers:                         -1,
 		transitionsByLayer:                  make(map[int][]Transition),
 		transitions:                         make([]Transition, 0),
+		toolOverrides:                       make(map[int]int),
+		repairs:                             make([]RepairRecord, 0),
 		lastFanCommandLineBeforeLayerChange: -1,
 	}
 
@@ -102,29 +142,135 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 	lastTransitionSpliceLength := float32(0)
 
 	// calculate available infill per transition
-	currentInfillStartE := float32(-1) // < 0 indicates not to use this value
+	purgeRun := make([]purgeRunSegment, 0) // features that can be purged into, printed since any other feature
 
 	lastFanCommandLine := -1
 
+	// for undoing the last transition when merging short pieces
+	toolChangeIndex := 0
+	firstTool := 0
+	lastTransitionToolChangeIndex := 0
+	previousTransitionSpliceLength := float32(0)
+	previousTransitionLayer := 0
+	droppedTransitions := false
+
+	// getTransition determines the purge and splice lengths of a transition from the current position,
+	// and returns how much the purge had to be extended to meet the minimum piece length
+	getTransition := func(from, to, lineNumber int) (Transition, float32) {
+		var transitionLength float32
+		var spliceOffset float32
+		var purgeLength float32
+		var spliceLength float32
+		var usableInfill float32
+		var shortfall float32
+
+		if palette.Type == TypeElement {
+			spliceLength = state.E.TotalExtrusion
+		} else {
+			transitionLength = palette.GetTransitionLength(to, from)
+			spliceOffset = transitionLength * (palette.GetTransitionTarget(to, from) / 100)
+			purgeLength = transitionLength
+			spliceLength = state.E.TotalExtrusion + spliceOffset
+			// start by subtracting usable infill from splice and purge length
+			if len(purgeRun) > 0 && palette.InfillTransitioning {
+				usableInfill = state.E.TotalExtrusion - purgeRun[0].startE
+				if usableInfill < 0 {
+					usableInfill = 0
+				}
+				purgeLength -= usableInfill
+				spliceLength -= usableInfill
+			}
+			// safety check to ensure minimum piece lengths
+			deltaE := spliceLength - lastTransitionSpliceLength
+			// try to account for any sparse layers that will be added between the last
+			// dense layer and this one (note: sparse layer extrusion may be more than this).
+			// Extended purges must guarantee the minimum piece length, so they don't rely on it
+			if palette.TransitionMethod == CustomTower && palette.PieceRepair != PieceRepairExtendPurge &&
+				results.totalLayers > lastTransitionLayer+1 {
+				sparseLayers := results.totalLayers - (lastTransitionLayer + 1)
+				sparseLayerExtrusionEstimate := state.PingExtrusion * float32(sparseLayers)
+				deltaE += sparseLayerExtrusionEstimate
+			}
+			if deltaE < minSpliceLength {
+				extra := minSpliceLength - deltaE
+				shortfall = extra
+				purgeLength += extra
+				spliceLength += extra
+				if palette.InfillTransitioning {
+					usableInfill -= extra
+					if usableInfill < 0 {
+						purgeLength -= usableInfill
+						spliceLength -= usableInfill
+						usableInfill = 0
+					}
+				}
+			}
+		}
+
+		return Transition{
+			Layer:            results.totalLayers,
+			From:             from,
+			To:               to,
+			TotalExtrusion:   state.E.TotalExtrusion,
+			TransitionLength: transitionLength,
+			PurgeLength:      purgeLength,
+			UsableInfill:     usableInfill,
+			AbsorbedBy:       getAbsorbedBy(purgeRun, state.E.TotalExtrusion, usableInfill),
+			line:             lineNumber,
+			spliceLength:     spliceLength,
+		}, shortfall
+	}
+
+	// top Z of extrusions in each layer, for slicers that don't annotate it
+	extrusionTopZs := make([]float32, 0)
+	dialect := gcode.Dialect{}
+
 	err := readerFn(func(line gcode.Command, lineNumber int) error {
+		lastX, lastY := state.XYZF.CurrentX, state.XYZF.CurrentY
 		state.E.TrackInstruction(line)
 		state.XYZF.TrackInstruction(line)
-		if line.IsLinearMove() {
-			if x, ok := line.Params["x"]; ok {
-				results.boundingBox.ExpandX(x)
-			}
-			if y, ok := line.Params["y"]; ok {
-				results.boundingBox.ExpandY(y)
+		annotation := dialect.ParseAnnotation(line)
+		if layer := results.totalLayers; layer >= 0 && layer < len(extrusionTopZs) &&
+			(line.IsLinearMove() || line.IsArcMove()) && line.HasParam("e") &&
+			state.XYZF.CurrentZ > extrusionTopZs[layer] {
+			extrusionTopZs[layer] = state.XYZF.CurrentZ
+		}
+		if layer := results.totalLayers; palette.TransitionMethod == CustomTower && state.PastStartSequence &&
+			layer >= 0 && (line.IsLinearMove() || line.IsArcMove()) && line.HasParam("e") &&
+			(line.HasParam("x") || line.HasParam("y")) {
+			// keep track of each layer's footprint, so the tower can avoid it
+			footprint := &results.layerFootprints[layer]
+			if arc := state.XYZF.LastArc; arc != nil {
+				footprint.ExpandArcXY(*arc)
+			} else {
+				footprint.ExpandX(lastX)
+				footprint.ExpandY(lastY)
+				footprint.ExpandX(state.XYZF.CurrentX)
+				footprint.ExpandY(state.XYZF.CurrentY)
 			}
-			if z, ok := line.Params["z"]; ok {
-				results.boundingBox.ExpandZ(z)
+		}
+		if line.IsLinearMove() || line.IsArcMove() {
+			if arc := state.XYZF.LastArc; arc != nil {
+				// include the full sweep of the arc, not just its endpoint
+				results.boundingBox.ExpandArc(*arc)
+			} else {
+				// use the tracked position, in case the move was relative
+				if _, ok := line.Param("x"); ok {
+					results.boundingBox.ExpandX(state.XYZF.CurrentX)
+				}
+				if _, ok := line.Param("y"); ok {
+					results.boundingBox.ExpandY(state.XYZF.CurrentY)
+				}
+				if _, ok := line.Param("z"); ok {
+					results.boundingBox.ExpandZ(state.XYZF.CurrentZ)
+				}
 			}
 			if palette.TransitionMethod == SideTransitions && state.CurrentlyTransitioning {
 				continueLookahead := true
 				if transitionNextPosition.MovedXY {
 					// had X/Y (and maybe Z) movement, and now we're extruding
 					//  - commit the most recent XYZ values, but ignore the ones in this command
-					if _, ok := line.Params["e"]; ok {
+					if _, ok := line.Param("e"); ok {
 						continueLookahead = false
 						results.transitionNextPositions = append(results.transitionNextPositions, transitionNextPosition)
 						transitionNextPosition = SideTransitionLookahead{}
@@ -132,28 +278,32 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 					}
 				}
 				if continueLookahead {
-					if x, ok := line.Params["x"]; ok {
-						transitionNextPosition.X = x
+					if _, ok := line.Param("x"); ok {
+						transitionNextPosition.X = state.XYZF.CurrentX
 						transitionNextPosition.MovedXY = true
 					}
-					if y, ok := line.Params["y"]; ok {
-						transitionNextPosition.Y = y
+					if _, ok := line.Param("y"); ok {
+						transitionNextPosition.Y = state.XYZF.CurrentY
 						transitionNextPosition.MovedXY = true
 					}
-					if z, ok := line.Params["z"]; ok {
-						transitionNextPosition.Z = z
+					if _, ok := line.Param("z"); ok {
+						transitionNextPosition.Z = state.XYZF.CurrentZ
 						transitionNextPosition.MovedZ = true
 					}
 				}
 			} else if state.OnWipeTower {
-				if _, ok := line.Params["e"]; ok {
+				if _, ok := line.Param("e"); ok {
 					// extrusion on wipe tower -- update bounding box
 					if state.E.CurrentRetraction == 0 {
-						if x, ok := line.Params["x"]; ok {
-							results.towerBoundingBox.ExpandX(x)
-						}
-						if y, ok := line.Params["y"]; ok {
-							results.towerBoundingBox.ExpandY(y)
+						if arc := state.XYZF.LastArc; arc != nil {
+							results.towerBoundingBox.ExpandArcXY(*arc)
+						} else {
+							if _, ok := line.Param("x"); ok {
+								results.towerBoundingBox.ExpandX(state.XYZF.CurrentX)
+							}
+							if _, ok := line.Param("y"); ok {
+								results.towerBoundingBox.ExpandY(state.XYZF.CurrentY)
+							}
 						}
 					}
 				}
@@ -181,68 +331,71 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 					}
 				}
 			}
-		} else if isToolChange, tool := line.IsToolChange(); isToolChange {
+		} else if isToolChange, tool := palette.GetFlavor().IsToolChange(line); isToolChange {
 			if state.PastStartSequence {
+				toolChangeIndex++
 				if state.FirstToolChange {
 					state.FirstToolChange = false
 					state.CurrentTool = tool
+					firstTool = tool
 					results.drivesUsed[state.CurrentTool] = true
+				} else if palette.Type == TypeManual && palette.hasSameColor(state.CurrentTool, tool) {
+					// the loaded filament is already the right color -- no need to swap
+					results.toolOverrides[toolChangeIndex] = state.CurrentTool
 				} else {
-					var transitionLength float32
-					var spliceOffset float32
-					var purgeLength float32
-					var spliceLength float32
-					var usableInfill float32
-
-					if palette.Type == TypeElement {
-						spliceLength = state.E.TotalExtrusion
-					} else {
-						transitionLength = palette.GetTransitionLength(tool, state.CurrentTool)
-						spliceOffset = transitionLength * (palette.TransitionTarget / 100)
-						purgeLength = transitionLength
-						spliceLength = state.E.TotalExtrusion + spliceOffset
-						// start by subtracting usable infill from splice and purge length
-						if currentInfillStartE >= 0 && palette.InfillTransitioning {
-							usableInfill = state.E.TotalExtrusion - currentInfillStartE
-							if usableInfill < 0 {
-								usableInfill = 0
-							}
-							purgeLength -= usableInfill
-							spliceLength -= usableInfill
+					tInfo, extra := getTransition(state.CurrentTool, tool, lineNumber)
+					lastTransitionIndex := len(results.transitions) - 1
+					if extra > 0 && palette.PieceRepair == PieceRepairMerge && palette.Type != TypeElement &&
+						lastTransitionIndex >= 0 && results.transitions[lastTransitionIndex].To == state.CurrentTool {
+						// the segment printed since the last transition is too short to splice,
+						// so print it with the previous tool instead
+						dropped := results.transitions[lastTransitionIndex]
+						results.transitions = results.transitions[:lastTransitionIndex]
+						layerTransitions := results.transitionsByLayer[dropped.Layer]
+						if len(layerTransitions) > 1 {
+							results.transitionsByLayer[dropped.Layer] = layerTransitions[:len(layerTransitions)-1]
+						} else {
+							delete(results.transitionsByLayer, dropped.Layer)
 						}
-						// safety check to ensure minimum piece lengths
-						deltaE := spliceLength - lastTransitionSpliceLength
-						// try to account for any sparse layers that will be added between the last
-						// dense layer and this one (note: sparse layer extrusion may be more than this)
-						if palette.TransitionMethod == CustomTower && results.totalLayers > lastTransitionLayer+1 {
-							sparseLayers := results.totalLayers - (lastTransitionLayer + 1)
-							sparseLayerExtrusionEstimate := state.PingExtrusion * float32(sparseLayers)
-							deltaE += sparseLayerExtrusionEstimate
+						results.toolOverrides[lastTransitionToolChangeIndex] = dropped.From
+						if len(results.transitionNextPositions) > len(results.transitions) {
+							results.transitionNextPositions = results.transitionNextPositions[:len(results.transitions)]
 						}
-						if deltaE < minSpliceLength {
-							extra := minSpliceLength - deltaE
-							purgeLength += extra
-							spliceLength += extra
-							if palette.InfillTransitioning {
-								usableInfill -= extra
-								if usableInfill < 0 {
-									purgeLength -= usableInfill
-									spliceLength -= usableInfill
-									usableInfill = 0
-								}
-							}
+						state.CurrentlyTransitioning = false
+						transitionNextPosition = SideTransitionLookahead{}
+						lastTransitionSpliceLength = previousTransitionSpliceLength
+						lastTransitionLayer = previousTransitionLayer
+						state.CurrentTool = dropped.From
+						results.repairs = append(results.repairs, RepairRecord{
+							Strategy:    PieceRepairMerge,
+							Layer:       dropped.Layer,
+							Drive:       dropped.To,
+							PrintedWith: dropped.From,
+							StartLine:   dropped.line,
+							EndLine:     lineNumber,
+							PieceLength: minSpliceLength - extra,
+						})
+						droppedTransitions = true
+						if tool == state.CurrentTool {
+							// back to the previous tool -- no transition needed at all
+							results.toolOverrides[toolChangeIndex] = tool
+							return nil
 						}
+						tInfo, _ = getTransition(state.CurrentTool, tool, lineNumber)
+					} else if extra > 0 && palette.PieceRepair == PieceRepairExtendPurge {
+						// getTransition already extended the purge that ends the short piece
+						results.repairs = append(results.repairs, RepairRecord{
+							Strategy:    PieceRepairExtendPurge,
+							Layer:       tInfo.Layer,
+							Drive:       tInfo.From,
+							PrintedWith: tInfo.From,
+							StartLine:   lineNumber,
+							EndLine:     lineNumber,
+							PieceLength: minSpliceLength - extra,
+							Extra:       extra,
+						})
 					}
 
-					tInfo := Transition{
-						Layer:            results.totalLayers,
-						From:             state.CurrentTool,
-						To:               tool,
-						TotalExtrusion:   state.E.TotalExtrusion,
-						TransitionLength: transitionLength,
-						PurgeLength:      purgeLength,
-						UsableInfill:     usableInfill,
-					}
 					results.transitions = append(results.transitions, tInfo)
 					if _, ok := results.transitionsByLayer[results.totalLayers]; ok {
 						results.transitionsByLayer[results.totalLayers] = append(results.transitionsByLayer[results.totalLayers], tInfo)
@@ -252,8 +405,11 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 					transitionCount++
 					// we haven't actually inserted the purge paths yet, so state.E.TotalExtrusion is
 					// missing purgeLength mm -- account for this by subtracting from last splice length
-					lastTransitionSpliceLength = spliceLength - purgeLength
+					previousTransitionSpliceLength = lastTransitionSpliceLength
+					previousTransitionLayer = lastTransitionLayer
+					lastTransitionSpliceLength = tInfo.spliceLength - tInfo.PurgeLength
 					lastTransitionLayer = results.totalLayers
+					lastTransitionToolChangeIndex = toolChangeIndex
 					state.CurrentTool = tool
 					if palette.TransitionMethod != CustomTower {
 						state.CurrentlyTransitioning = true
@@ -267,36 +423,38 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 			}
 		} else if line.Raw == ";START_OF_PRINT" {
 			state.PastStartSequence = true
-		} else if line.Raw == ";LAYER_CHANGE" {
+		} else if annotation.Kind == gcode.AnnotationLayerChange {
 			results.totalLayers++
-			results.layerTopZs = append(results.layerTopZs, 0)
+			// (some slicers include the layer's Z in the layer change)
+			results.layerTopZs = append(results.layerTopZs, roundTo(annotation.Value, maxZPrecision))
 			results.layerThicknesses = append(results.layerThicknesses, 0)
+			results.layerFootprints = append(results.layerFootprints, gcode.NewBoundingBox())
+			extrusionTopZs = append(extrusionTopZs, 0)
 			if lastFanCommandLine >= 0 && lastFanCommandLine == lineNumber-1 {
 				results.lastFanCommandLineBeforeLayerChange = lastFanCommandLine
 			}
 		} else if palette.TransitionMethod == CustomTower &&
-			strings.HasPrefix(line.Raw, ";Z:") {
-			if topZ, err := strconv.ParseFloat(line.Raw[3:], 64); err == nil {
-				results.layerTopZs[results.totalLayers] = roundTo(float32(topZ), maxZPrecision)
-			}
+			annotation.Kind == gcode.AnnotationLayerZ && results.totalLayers >= 0 {
+			results.layerTopZs[results.totalLayers] = roundTo(annotation.Value, maxZPrecision)
 		} else if palette.TransitionMethod == CustomTower &&
-			strings.HasPrefix(line.Raw, ";HEIGHT:") {
-			if thickness, err := strconv.ParseFloat(line.Raw[8:], 64); err == nil {
-				thickness32 := roundTo(float32(thickness), maxZPrecision)
-				if thickness32 > results.layerThicknesses[results.totalLayers] {
-					results.layerThicknesses[results.totalLayers] = thickness32
-				}
+			annotation.Kind == gcode.AnnotationLayerHeight && results.totalLayers >= 0 {
+			thickness32 := roundTo(annotation.Value, maxZPrecision)
+			if thickness32 > results.layerThicknesses[results.totalLayers] {
+				results.layerThicknesses[results.totalLayers] = thickness32
 			}
 		} else if (palette.TransitionMethod == TransitionTower || palette.InfillTransitioning) &&
-			strings.HasPrefix(line.Comment, "TYPE:") {
-			if line.Comment == "TYPE:Internal infill" {
-				// changed to infill -- initialize accumulated value
-				currentInfillStartE = state.E.TotalExtrusion
+			annotation.Kind == gcode.AnnotationFeature {
+			if palette.IsPurgeFeature(annotation.Feature) {
+				// changed to a feature that can be purged into -- extend the accumulated run
+				purgeRun = append(purgeRun, purgeRunSegment{
+					feature: annotation.Feature,
+					startE:  state.E.TotalExtrusion,
+				})
 			} else {
-				// changed to non-infill -- reset accumulated value
-				currentInfillStartE = -1
+				// changed to a visible feature -- reset accumulated run
+				purgeRun = purgeRun[:0]
 			}
-			startingWipeTower := line.Comment == "TYPE:Wipe tower"
+			startingWipeTower := annotation.Feature == gcode.FeatureWipeTower
 			if !state.OnWipeTower && startingWipeTower {
 				// start of the actual transition being printed
 			} else if state.OnWipeTower && !startingWipeTower {
@@ -308,14 +466,12 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 				}
 			}
 			state.OnWipeTower = startingWipeTower
-		} else if results.timeEstimate == 0 &&
-			strings.HasPrefix(line.Comment, "estimated printing time (normal mode) = ") {
-			timeEstimate, err := gcode.ParseTimeString(line.Comment)
-			if err != nil {
-				return err
+		} else if results.timeEstimate == 0 && annotation.Kind == gcode.AnnotationTimeEstimate {
+			results.timeEstimate = annotation.Value
+			if strings.HasPrefix(line.Comment, "estimated printing time") {
+				// PrusaSlicer's summary is at the end of the file
+				results.printSummaryStart = lineNumber + 2
 			}
-			results.timeEstimate = timeEstimate
-			results.printSummaryStart = lineNumber + 2
 		} else if line.IsFanCommand() {
 			lastFanCommandLine = lineNumber
 		}
@@ -327,6 +483,31 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 	}
 	results.totalLayers++ // switch from 0-indexing to a true count
 
+	if droppedTransitions {
+		// drives that only printed merged pieces are no longer used
+		results.drivesUsed = make([]bool, palette.GetInputCount())
+		results.drivesUsed[firstTool] = true
+		for _, transition := range results.transitions {
+			results.drivesUsed[transition.To] = true
+		}
+	}
+
+	if palette.TransitionMethod == CustomTower {
+		// fill in layer heights that the slicer didn't annotate (e.g. Cura)
+		for i := 0; i < results.totalLayers && i < len(extrusionTopZs); i++ {
+			if results.layerTopZs[i] == 0 {
+				results.layerTopZs[i] = roundTo(extrusionTopZs[i], maxZPrecision)
+			}
+			if results.layerThicknesses[i] == 0 {
+				thickness := results.layerTopZs[i]
+				if i > 0 {
+					thickness -= results.layerTopZs[i-1]
+				}
+				results.layerThicknesses[i] = roundTo(thickness, maxZPrecision)
+			}
+		}
+	}
+
 	// invariant assertions
 	if palette.TransitionMethod == CustomTower {
 		if layerThicknesses := len(results.layerThicknesses); layerThicknesses != results.totalLayers {
@@ -360,8 +541,5 @@ func _preflight(readerFn func(callback gcode.LineCallback) error, palette *Palet
 }
 
 func preflight(inpath string, palette *Palette) (msfPreflight, error) {
-	readerFn := func(callback gcode.LineCallback) error {
-		return gcode.ReadByLine(inpath, callback)
-	}
-	return _preflight(readerFn, palette)
+	return _preflight(gcode.FileLineReader(inpath), palette)
 }
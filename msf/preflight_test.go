@@ -0,0 +1,45 @@
+package msf
+
+import (
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+)
+
+func Test_PurgeIntoFeatures(t *testing.T) {
+	palette := getTestPalette(100)
+	palette.InfillTransitioning = true
+	printContent := `
+;START_OF_PRINT
+T0
+G1 Z0
+;LAYER_CHANGE
+;Z:0.2
+;HEIGHT:0.2
+G1 X0 Y0 Z0.2 F1800
+;TYPE:External perimeter
+G1 E200 F2400
+;TYPE:Internal infill
+G1 E230 F2400
+;TYPE:Support material
+G1 E250 F2400
+G92 E0
+T1
+;TYPE:External perimeter
+G1 E200 F2400
+`
+	// only internal infill by default, which was interrupted by support
+	expected := []Transition{
+		{Layer: 0, From: 0, To: 1, TotalExtrusion: 250, TransitionLength: 100, PurgeLength: 100},
+	}
+	testTowerPreflight(t, &palette, printContent, expected)
+
+	palette.PurgeFeatures = []string{gcode.FeatureInternalInfill, gcode.FeatureSupportMaterial}
+	expected[0].PurgeLength = 50
+	expected[0].UsableInfill = 50
+	results := testTowerPreflight(t, &palette, printContent, expected)
+	absorbedBy := results.transitions[0].AbsorbedBy
+	if absorbedBy[gcode.FeatureInternalInfill] != 30 || absorbedBy[gcode.FeatureSupportMaterial] != 20 {
+		t.Fatalf("expected 30 mm absorbed by infill and 20 mm by support, got %v", absorbedBy)
+	}
+}
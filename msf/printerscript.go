@@ -6,33 +6,7 @@ import (
 )
 
 func getTimeEstimate(command gcode.Command, state *State) float32 {
-	if command.IsLinearMove() {
-		feedrate := state.XYZF.CurrentFeedrate
-		if f, ok := command.Params["f"]; ok {
-			feedrate = f
-		}
-		nextX := state.XYZF.CurrentX
-		if x, ok := command.Params["x"]; ok {
-			nextX = x
-		}
-		nextY := state.XYZF.CurrentY
-		if y, ok := command.Params["y"]; ok {
-			nextY = y
-		}
-		return estimateMoveTime(state.XYZF.CurrentX, state.XYZF.CurrentY, nextX, nextY, feedrate)
-	}
-	if command.Command == "G4" {
-		if ms, ok := command.Params["p"]; ok {
-			// e.g. G4 P5000
-			return ms / 1000
-		}
-		if s, ok := command.Params["s"]; ok {
-			// e.g. G4 S5
-			return s
-		}
-		return 0
-	}
-	return 0
+	return gcode.EstimateDuration(command, &state.XYZF, &state.E, state.Palette.GetFlavor())
 }
 
 func evaluateScript(script printerscript.Tree, locals map[string]float64, state *State) (string, error) {
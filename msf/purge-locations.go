@@ -0,0 +1,123 @@
+package msf
+
+import (
+	"fmt"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+)
+
+type SideTransitionLocation struct {
+	X          float32         `json:"x"`          // mm, for side transitions in place
+	Y          float32         `json:"y"`          // mm, for side transitions in place
+	Edge       gcode.Direction `json:"edge"`       // for side transitions on the edge of the bed
+	EdgeOffset float32         `json:"edgeOffset"` // mm, for side transitions on the edge of the bed
+}
+
+// getDefaultSideTransitionLocation returns the single location used if no list is given
+func (p Palette) getDefaultSideTransitionLocation() SideTransitionLocation {
+	return SideTransitionLocation{
+		X:          p.SideTransitionX,
+		Y:          p.SideTransitionY,
+		Edge:       p.SideTransitionEdge,
+		EdgeOffset: p.SideTransitionEdgeOffset,
+	}
+}
+
+func (state *State) hasRoomAtPurgeLocation(index int, purgeLength float32) bool {
+	capacity := state.Palette.PurgeLocationCapacity
+	return capacity <= 0 || state.PurgeLocationUsage[index]+purgeLength <= capacity
+}
+
+// nextPurgeLocation returns the index of the location to use for the next side transition,
+// or -1 if none of them have room for it
+func (state *State) nextPurgeLocation(purgeLength float32) int {
+	count := len(state.Palette.SideTransitionLocations)
+	if state.Palette.PurgeLocationRotation == PurgeLocationLeastUsed {
+		next := 0
+		for index, usage := range state.PurgeLocationUsage {
+			if usage < state.PurgeLocationUsage[next] {
+				next = index
+			}
+		}
+		if !state.hasRoomAtPurgeLocation(next, purgeLength) {
+			return -1
+		}
+		return next
+	}
+	// round-robin, skipping any locations that are full
+	for i := 1; i <= count; i++ {
+		next := (state.PurgeLocationIndex + i) % count
+		if state.hasRoomAtPurgeLocation(next, purgeLength) {
+			return next
+		}
+	}
+	return -1
+}
+
+// selectPurgeLocation picks where the next side transition will purge, and if every location
+// is full, outputs the user's script for emptying them first
+func selectPurgeLocation(purgeLength float32, state *State) (string, error) {
+	if len(state.Palette.SideTransitionLocations) == 0 {
+		return "", nil
+	}
+	if state.PurgeLocationUsage == nil {
+		state.PurgeLocationUsage = make([]float32, len(state.Palette.SideTransitionLocations))
+		state.PurgeLocationIndex = -1
+	}
+
+	sequence := ""
+	next := state.nextPurgeLocation(purgeLength)
+	if next < 0 {
+		// every location is full
+		sequence += ";TYPE:Custom" + EOL
+		locals := state.Locals.Prepare(state.CurrentTool, map[string]float64{
+			"layer":                   float64(state.CurrentLayer),
+			"currentPrintTemperature": float64(state.Temperature.Extruder),
+			"currentBedTemperature":   float64(state.Temperature.Bed),
+			"currentX":                float64(state.XYZF.CurrentX),
+			"currentY":                float64(state.XYZF.CurrentY),
+			"currentZ":                float64(state.XYZF.CurrentZ),
+			"purgeLocationCount":      float64(len(state.Palette.SideTransitionLocations)),
+		})
+		emptyBins, err := evaluateScript(state.Palette.EmptyPurgeLocationsScript, locals, state)
+		if err != nil {
+			return "", err
+		}
+		sequence += emptyBins
+		for i := range state.PurgeLocationUsage {
+			state.PurgeLocationUsage[i] = 0
+		}
+		next = state.nextPurgeLocation(purgeLength)
+		if next < 0 {
+			// a single transition is bigger than a location can hold -- use one anyway
+			next = 0
+		}
+	}
+
+	state.PurgeLocationIndex = next
+	state.PurgeLocationUsage[next] += purgeLength
+	state.SideTransitionLocation = state.Palette.SideTransitionLocations[next]
+	return sequence, nil
+}
+
+// withPurgeLocationLocals adds the current side transition location to a script's locals
+func withPurgeLocationLocals(state *State, locals map[string]float64) map[string]float64 {
+	locals["purgeLocation"] = float64(state.PurgeLocationIndex)
+	locals["purgeX"] = float64(state.SideTransitionLocation.X)
+	locals["purgeY"] = float64(state.SideTransitionLocation.Y)
+	locals["purgeEdge"] = float64(state.SideTransitionLocation.Edge)
+	locals["purgeEdgeOffset"] = float64(state.SideTransitionLocation.EdgeOffset)
+	return locals
+}
+
+func validatePurgeLocations(p Palette) error {
+	switch p.PurgeLocationRotation {
+	case PurgeLocationRoundRobin, PurgeLocationLeastUsed:
+	default:
+		return fmt.Errorf("unknown purge location rotation '%s'", p.PurgeLocationRotation)
+	}
+	if p.PurgeLocationCapacity > 0 && len(p.SideTransitionLocations) > 0 && p.EmptyPurgeLocationsScript == nil {
+		return fmt.Errorf("a script to empty the purge locations is needed when their capacity is limited")
+	}
+	return nil
+}
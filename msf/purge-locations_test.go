@@ -0,0 +1,60 @@
+package msf
+
+import (
+	"strings"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/printerscript"
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
+
+func Test_SelectPurgeLocation(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.TransitionMethod = SideTransitions
+	palette.SideTransitionLocations = []SideTransitionLocation{
+		{X: 10, Y: 10},
+		{X: 10, Y: 30},
+		{X: 10, Y: 50},
+	}
+	palette.PurgeLocationCapacity = 100
+	script, err := printerscript.LexAndParse(`"M0 ; empty purge bins"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	palette.EmptyPurgeLocationsScript = script
+
+	state := NewState(&palette)
+	state.Locals = sequences.NewLocals()
+	purgeLengths := []float32{60, 60, 60, 30, 60, 60}
+	expectedLocations := []int{0, 1, 2, 0, 1, 2}
+	expectedEmpties := []bool{false, false, false, false, true, false}
+	for i, purgeLength := range purgeLengths {
+		sequence, err := selectPurgeLocation(purgeLength, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.PurgeLocationIndex != expectedLocations[i] {
+			t.Errorf("purge %d: expected location %d, got %d", i, expectedLocations[i], state.PurgeLocationIndex)
+		}
+		if emptied := strings.Contains(sequence, "M0"); emptied != expectedEmpties[i] {
+			t.Errorf("purge %d: expected emptying bins == %t", i, expectedEmpties[i])
+		}
+		if state.SideTransitionLocation != palette.SideTransitionLocations[state.PurgeLocationIndex] {
+			t.Errorf("purge %d: side transition location not updated", i)
+		}
+	}
+
+	palette.PurgeLocationRotation = PurgeLocationLeastUsed
+	palette.PurgeLocationCapacity = 0
+	state = NewState(&palette)
+	purgeLengths = []float32{60, 20, 30, 10}
+	expectedLocations = []int{0, 1, 2, 1}
+	for i, purgeLength := range purgeLengths {
+		if _, err := selectPurgeLocation(purgeLength, &state); err != nil {
+			t.Fatal(err)
+		}
+		if state.PurgeLocationIndex != expectedLocations[i] {
+			t.Errorf("purge %d: expected location %d, got %d", i, expectedLocations[i], state.PurgeLocationIndex)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package msf
+
+// RepairRecord describes a change made to the print
+// to avoid creating a piece that is too short
+type RepairRecord struct {
+	Strategy    PieceRepair `json:"strategy"`
+	Layer       int         `json:"layer"`
+	Drive       int         `json:"drive"`       // drive of the too-short piece
+	PrintedWith int         `json:"printedWith"` // drive printing the piece's features after the repair
+	StartLine   int         `json:"startLine"`   // first line of G-code affected
+	EndLine     int         `json:"endLine"`     // last line of G-code affected
+	PieceLength float32     `json:"pieceLength"` // mm, before the repair
+	Extra       float32     `json:"extra"`       // mm of filament added to the piece
+}
@@ -0,0 +1,111 @@
+package msf
+
+import (
+	"bufio"
+	"io/ioutil"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
+
+func Test_MergeShortPieces(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.PieceRepair = PieceRepairMerge
+	printContent := `
+;START_OF_PRINT
+T0
+G1 Z0
+;LAYER_CHANGE
+;Z:0.2
+;HEIGHT:0.2
+G1 X0 Y0 Z0.2 F1800
+G1 E200 F2400
+G92 E0
+T1
+G1 E5 F2400
+G92 E0
+T2
+G1 E200 F2400
+`
+	expectedTransitions := []Transition{
+		{
+			Layer:            0,
+			From:             0,
+			To:               2,
+			TotalExtrusion:   205,
+			TransitionLength: 30,
+			PurgeLength:      30,
+			UsableInfill:     0,
+		},
+	}
+	preflightResults := testTowerPreflight(t, &palette, printContent, expectedTransitions)
+	if len(preflightResults.repairs) != 1 {
+		t.Fatalf("expected 1 repair, got %d", len(preflightResults.repairs))
+	}
+	if repair := preflightResults.repairs[0]; repair.Drive != 1 || repair.PrintedWith != 0 {
+		t.Fatalf("unexpected repair %v", repair)
+	}
+	if preflightResults.drivesUsed[1] {
+		t.Fatal("expected merged drive to be unused")
+	}
+
+	msfOut := NewMSF(&palette)
+	writer := bufio.NewWriter(ioutil.Discard)
+	if err := _paletteOutput(getTestLineReader(printContent), writer, &msfOut, &palette, &preflightResults, sequences.NewLocals()); err != nil {
+		t.Fatal(err)
+	}
+	if len(msfOut.SpliceList) != 2 || msfOut.SpliceList[0].Drive != 0 || msfOut.SpliceList[1].Drive != 2 {
+		t.Fatalf("unexpected splices %v", msfOut.SpliceList)
+	}
+	if len(msfOut.Repairs) != 1 {
+		t.Fatalf("expected repairs to be copied to MSF, got %v", msfOut.Repairs)
+	}
+}
+
+func Test_ExtendShortPieces(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.PieceRepair = PieceRepairExtendPurge
+	printContent := `
+;START_OF_PRINT
+T0
+G1 Z0
+;LAYER_CHANGE
+;Z:0.2
+;HEIGHT:0.2
+G1 X0 Y0 Z0.2 F1800
+G1 E200 F2400
+G92 E0
+T1
+G1 E5 F2400
+G92 E0
+T2
+G1 E200 F2400
+`
+	preflightResults, err := _preflight(getTestLineReader(printContent), &palette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preflightResults.repairs) != 1 {
+		t.Fatalf("expected 1 repair, got %v", preflightResults.repairs)
+	}
+	repair := preflightResults.repairs[0]
+	if repair.Strategy != PieceRepairExtendPurge || repair.Drive != 1 || repair.Extra <= 0 {
+		t.Fatalf("unexpected repair %v", repair)
+	}
+	if purge := preflightResults.transitions[1].PurgeLength; purge < 30+repair.Extra {
+		t.Fatalf("expected the purge to be extended by %f, got %f", repair.Extra, purge)
+	}
+
+	// the extended purge is printed in the tower, so the short piece can be spliced
+	msfOut := NewMSF(&palette)
+	writer := bufio.NewWriter(ioutil.Discard)
+	if err := _paletteOutput(getTestLineReader(printContent), writer, &msfOut, &palette, &preflightResults, sequences.NewLocals()); err != nil {
+		t.Fatal(err)
+	}
+	if len(msfOut.SpliceList) != 3 || msfOut.SpliceList[1].Drive != 1 {
+		t.Fatalf("unexpected splices %v", msfOut.SpliceList)
+	}
+	if len(msfOut.Repairs) != 1 {
+		t.Fatalf("expected repairs to be copied to MSF, got %v", msfOut.Repairs)
+	}
+}
@@ -10,20 +10,20 @@ func getSideTransitionStartPosition(state *State) (x, y float32) {
 	if state.Palette.SideTransitionJog {
 		x = state.XYZF.CurrentX
 		y = state.XYZF.CurrentY
-		switch state.Palette.SideTransitionEdge {
+		switch state.SideTransitionLocation.Edge {
 		case gcode.North:
-			y = state.Palette.PrintBedMaxY + state.Palette.SideTransitionEdgeOffset
+			y = state.Palette.PrintBedMaxY + state.SideTransitionLocation.EdgeOffset
 		case gcode.South:
-			y = state.Palette.PrintBedMinY - state.Palette.SideTransitionEdgeOffset
+			y = state.Palette.PrintBedMinY - state.SideTransitionLocation.EdgeOffset
 		case gcode.West:
-			x = state.Palette.PrintBedMinX - state.Palette.SideTransitionEdgeOffset
+			x = state.Palette.PrintBedMinX - state.SideTransitionLocation.EdgeOffset
 		case gcode.East:
-			x = state.Palette.PrintBedMaxX + state.Palette.SideTransitionEdgeOffset
+			x = state.Palette.PrintBedMaxX + state.SideTransitionLocation.EdgeOffset
 		}
 	} else {
 		// side transition in place
-		x = state.Palette.SideTransitionX
-		y = state.Palette.SideTransitionY
+		x = state.SideTransitionLocation.X
+		y = state.SideTransitionLocation.Y
 	}
 	return
 }
@@ -33,7 +33,7 @@ func moveToSideTransition(transitionLength float32, state *State, startX, startY
 	if state.Palette.PreSideTransitionScript != nil {
 		// user script instead of built-in logic
 		sequence += ";TYPE:Custom" + EOL
-		locals := state.Locals.Prepare(state.CurrentTool, map[string]float64{
+		locals := state.Locals.Prepare(state.CurrentTool, withPurgeLocationLocals(state, map[string]float64{
 			"layer":                   float64(state.CurrentLayer),
 			"currentPrintTemperature": float64(state.Temperature.Extruder),
 			"currentBedTemperature":   float64(state.Temperature.Bed),
@@ -44,7 +44,7 @@ func moveToSideTransition(transitionLength float32, state *State, startX, startY
 			"nextY":                   float64(startY),
 			"nextZ":                   float64(state.XYZF.CurrentZ),
 			"transitionLength":        float64(transitionLength),
-		})
+		}))
 		return evaluateScript(state.Palette.PreSideTransitionScript, locals, state)
 	}
 
@@ -54,7 +54,7 @@ func moveToSideTransition(transitionLength float32, state *State, startX, startY
 		// un-retract
 		sequence += getRestart(state, state.E.CurrentRetraction, state.Palette.RestartFeedrate[state.CurrentTool])
 	} else if state.Palette.UseFirmwareRetraction {
-		sequence += getFirmwareRestart()
+		sequence += getFirmwareRestart(state)
 	}
 
 	return sequence, nil
@@ -77,7 +77,7 @@ func leaveSideTransition(transitionLength float32, state *State, retractDistance
 	if state.Palette.PostSideTransitionScript != nil {
 		// user script instead of built-in logic
 		sequence += ";TYPE:Custom" + EOL
-		locals := state.Locals.Prepare(state.CurrentTool, map[string]float64{
+		locals := state.Locals.Prepare(state.CurrentTool, withPurgeLocationLocals(state, map[string]float64{
 			"layer":                   float64(state.CurrentLayer),
 			"currentPrintTemperature": float64(state.Temperature.Extruder),
 			"currentBedTemperature":   float64(state.Temperature.Bed),
@@ -88,7 +88,7 @@ func leaveSideTransition(transitionLength float32, state *State, retractDistance
 			"nextY":                   float64(upcomingXYZ.Y),
 			"nextZ":                   float64(upcomingXYZ.Z),
 			"transitionLength":        float64(transitionLength),
-		})
+		}))
 		sequence, err := evaluateScript(state.Palette.PostSideTransitionScript, locals, state)
 		if err != nil {
 			return "", err
@@ -101,7 +101,7 @@ func leaveSideTransition(transitionLength float32, state *State, retractDistance
 		// restore any retraction from before the side transition
 		sequence += getRetract(state, retractDistance, state.Palette.RetractFeedrate[state.CurrentTool])
 	} else if state.Palette.UseFirmwareRetraction {
-		sequence += getFirmwareRetract()
+		sequence += getFirmwareRetract(state)
 	}
 	sequence += resetEAxis(state)
 
@@ -157,7 +157,7 @@ func sideTransitionInPlace(transitionLength float32, state *State) (string, erro
 			sequence += pingSequence
 		}
 		nextPurgeExtrusion := float32(math.Min(10, float64(transitionLength-transitionSoFar)))
-		sequence += getPurge(state, nextPurgeExtrusion, state.Palette.SideTransitionPurgeSpeed*60)
+		sequence += getPurge(state, nextPurgeExtrusion, state.Palette.GetSideTransitionPurgeSpeed(state.CurrentTool, state.PreviousTool))
 		transitionSoFar += nextPurgeExtrusion
 	}
 
@@ -175,13 +175,17 @@ func sideTransitionInPlace(transitionLength float32, state *State) (string, erro
 }
 
 func sideTransitionOnEdge(transitionLength float32, state *State) (string, error) {
-	eFeedrate := state.Palette.SideTransitionPurgeSpeed * 60
+	eFeedrate := state.Palette.GetSideTransitionPurgeSpeed(state.CurrentTool, state.PreviousTool)
 	xyFeedrate := state.Palette.SideTransitionMoveSpeed * 60
+	if maxEFeedrate := state.Palette.SideTransitionPurgeSpeed * 60; eFeedrate < maxEFeedrate {
+		// slow the moves down too, to keep the same extrusion per mm
+		xyFeedrate *= eFeedrate / maxEFeedrate
+	}
 	transitionSoFar := float32(0)
 
 	// determine next purge direction
 	var nextPurgeDirection gcode.Direction
-	if state.Palette.SideTransitionEdge == gcode.North || state.Palette.SideTransitionEdge == gcode.South {
+	if state.SideTransitionLocation.Edge == gcode.North || state.SideTransitionLocation.Edge == gcode.South {
 		if state.Palette.PrintBedMaxX-state.XYZF.CurrentX >= state.XYZF.CurrentX-state.Palette.PrintBedMinX {
 			nextPurgeDirection = gcode.East
 		} else {
@@ -196,15 +200,15 @@ func sideTransitionOnEdge(transitionLength float32, state *State) (string, error
 	}
 	nextX := state.XYZF.CurrentX
 	nextY := state.XYZF.CurrentY
-	switch state.Palette.SideTransitionEdge {
+	switch state.SideTransitionLocation.Edge {
 	case gcode.North:
-		nextY = state.Palette.PrintBedMaxY + state.Palette.SideTransitionEdgeOffset
+		nextY = state.Palette.PrintBedMaxY + state.SideTransitionLocation.EdgeOffset
 	case gcode.South:
-		nextY = state.Palette.PrintBedMinY - state.Palette.SideTransitionEdgeOffset
+		nextY = state.Palette.PrintBedMinY - state.SideTransitionLocation.EdgeOffset
 	case gcode.West:
-		nextX = state.Palette.PrintBedMinX - state.Palette.SideTransitionEdgeOffset
+		nextX = state.Palette.PrintBedMinX - state.SideTransitionLocation.EdgeOffset
 	case gcode.East:
-		nextX = state.Palette.PrintBedMaxX + state.Palette.SideTransitionEdgeOffset
+		nextX = state.Palette.PrintBedMaxX + state.SideTransitionLocation.EdgeOffset
 	}
 
 	// move to starting position
@@ -217,7 +221,7 @@ func sideTransitionOnEdge(transitionLength float32, state *State) (string, error
 	sequence += ";TYPE:Side transition" + EOL
 
 	dimensionOfInterest := state.Palette.PrintBedMaxX - state.Palette.PrintBedMinX
-	if state.Palette.SideTransitionEdge == gcode.West || state.Palette.SideTransitionEdge == gcode.East {
+	if state.SideTransitionLocation.Edge == gcode.West || state.SideTransitionLocation.Edge == gcode.East {
 		dimensionOfInterest = state.Palette.PrintBedMaxY - state.Palette.PrintBedMinY
 	}
 	edgeClearance := float32(15)
@@ -297,7 +301,7 @@ func sideTransitionCustom(transitionLength float32, state *State) (string, error
 		sequence += pingSequence
 	}
 
-	locals := state.Locals.Prepare(state.CurrentTool, map[string]float64{
+	locals := state.Locals.Prepare(state.CurrentTool, withPurgeLocationLocals(state, map[string]float64{
 		"layer":                   float64(state.CurrentLayer),
 		"currentPrintTemperature": float64(state.Temperature.Extruder),
 		"currentBedTemperature":   float64(state.Temperature.Bed),
@@ -305,7 +309,7 @@ func sideTransitionCustom(transitionLength float32, state *State) (string, error
 		"currentY":                float64(state.XYZF.CurrentY),
 		"currentZ":                float64(state.XYZF.CurrentZ),
 		"transitionLength":        float64(transitionLength),
-	})
+	}))
 	result, err := evaluateScript(state.Palette.SideTransitionScript, locals, state)
 	if err != nil {
 		return sequence, err
@@ -327,11 +331,17 @@ func sideTransitionCustom(transitionLength float32, state *State) (string, error
 }
 
 func sideTransition(transitionLength float32, state *State) (string, error) {
+	sequence, err := selectPurgeLocation(transitionLength, state)
+	if err != nil {
+		return "", err
+	}
+	var transition string
 	if state.Palette.SideTransitionScript != nil {
-		return sideTransitionCustom(transitionLength, state)
+		transition, err = sideTransitionCustom(transitionLength, state)
 	} else if state.Palette.SideTransitionJog {
-		return sideTransitionOnEdge(transitionLength, state)
+		transition, err = sideTransitionOnEdge(transitionLength, state)
 	} else {
-		return sideTransitionInPlace(transitionLength, state)
+		transition, err = sideTransitionInPlace(transitionLength, state)
 	}
+	return sequence + transition, err
 }
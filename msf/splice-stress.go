@@ -0,0 +1,235 @@
+package msf
+
+import (
+	"bufio"
+	"sort"
+	"strings"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+)
+
+// SpliceStress is how many times a splice is expected to pass through the extruder
+type SpliceStress struct {
+	Splice         int        `json:"splice"`   // 1-indexed
+	Drive          int        `json:"drive"`    // 0-indexed
+	Position       float32    `json:"position"` // mm of filament
+	GearPasses     int        `json:"gearPasses"`
+	MeltZonePasses int        `json:"meltZonePasses"`
+	Risk           SpliceRisk `json:"risk"`
+}
+
+type SpliceStressReport struct {
+	Splices               []SpliceStress `json:"splices"`
+	SuppressedRetractions int            `json:"suppressedRetractions"`
+}
+
+// GetExtruderGearDistance returns the length of the filament path from the extruder gears to the nozzle
+func (p Palette) GetExtruderGearDistance() float32 {
+	if p.ExtruderGearDistance > 0 {
+		return p.ExtruderGearDistance
+	}
+	return DefaultExtruderGearDistance
+}
+
+// GetMeltZoneLength returns the length of the filament path from the top of the melt zone to the nozzle
+func (p Palette) GetMeltZoneLength() float32 {
+	if p.MeltZoneLength > 0 {
+		return p.MeltZoneLength
+	}
+	return DefaultMeltZoneLength
+}
+
+func (p Palette) needsSpliceStressPass() bool {
+	return p.Type != TypeManual && (p.AnalyzeSpliceStress || p.SuppressSpliceRetractions)
+}
+
+func getSpliceRisk(passes int) SpliceRisk {
+	if passes >= SpliceStressHighPasses {
+		return SpliceRiskHigh
+	}
+	if passes >= SpliceStressMediumPasses {
+		return SpliceRiskMedium
+	}
+	return SpliceRiskLow
+}
+
+// spliceCrossings counts how often each splice passes a point a fixed distance up the filament path
+type spliceCrossings struct {
+	thresholds []float32 // total extrusion at which each splice is at the point
+	counts     []int
+}
+
+func newSpliceCrossings(splices []Splice, distance float32) spliceCrossings {
+	crossings := spliceCrossings{
+		thresholds: make([]float32, len(splices)),
+		counts:     make([]int, len(splices)),
+	}
+	for i, splice := range splices {
+		crossings.thresholds[i] = splice.Length - distance
+	}
+	return crossings
+}
+
+// first returns the index of the first splice the filament would pass moving forwards from position
+func (c spliceCrossings) first(position float32) int {
+	return sort.Search(len(c.thresholds), func(i int) bool {
+		return c.thresholds[i] > position
+	})
+}
+
+// track counts the splices passed when the filament moves from one position to another
+func (c spliceCrossings) track(from, to float32) {
+	if to < from {
+		from, to = to, from
+	}
+	for i := c.first(from); i < len(c.thresholds) && c.thresholds[i] <= to; i++ {
+		c.counts[i]++
+	}
+}
+
+// wouldCross returns true if retracting by distance from position would pull a splice back
+func (c spliceCrossings) wouldCross(position, distance float32) bool {
+	i := c.first(position - distance)
+	return i < len(c.thresholds) && c.thresholds[i] <= position
+}
+
+// isRetraction returns true (and the distance) for retractions, including those made while wiping
+func isRetraction(line gcode.Command) (bool, float32) {
+	if !line.IsLinearMove() || line.HasParam("z") {
+		return false, 0
+	}
+	e, ok := line.Param("e")
+	return ok && e < 0, -e
+}
+
+// replaceExtrusion rewrites the E parameter of a move, leaving the rest of the line as it was.
+// If remove is true, the parameter is dropped instead.
+func replaceExtrusion(raw string, e float32, remove bool) string {
+	code, comment := raw, ""
+	if index := strings.Index(raw, ";"); index >= 0 {
+		code, comment = raw[:index], raw[index:]
+	}
+	fields := strings.Fields(code)
+	for i, field := range fields {
+		if field[0] != 'E' && field[0] != 'e' {
+			continue
+		}
+		if remove {
+			fields = append(fields[:i], fields[i+1:]...)
+		} else {
+			fields[i] = "E" + gcode.FormatFloat(float64(e))
+		}
+		break
+	}
+	line := strings.Join(fields, " ")
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// spliceStressPass re-reads the finished G-code, counting every time each splice crosses
+// the extruder gears and the top of the melt zone. If enabled, retractions that would pull
+// a splice back through the gears are left out, along with the restarts that follow them.
+func spliceStressPass(readLines gcode.LineReader, writer *bufio.Writer, msfOut *MSF) error {
+	palette := msfOut.Palette
+	flavor := palette.GetFlavor()
+	splices := msfOut.SpliceList
+	if len(splices) > 0 {
+		// the last "splice" is the end of the filament
+		splices = splices[:len(splices)-1]
+	}
+	gears := newSpliceCrossings(splices, palette.GetExtruderGearDistance())
+	meltZone := newSpliceCrossings(splices, palette.GetMeltZoneLength())
+	report := SpliceStressReport{
+		Splices: make([]SpliceStress, 0, len(splices)),
+	}
+
+	extrusion := gcode.ExtrusionTracker{
+		TotalExtrusion: palette.FirmwarePurge,
+	}
+	currentDrive := func() int {
+		// the piece at the nozzle
+		for _, splice := range msfOut.SpliceList {
+			if splice.Length > extrusion.TotalExtrusion {
+				return splice.Drive
+			}
+		}
+		return palette.PrintExtruder
+	}
+	firmwareRetraction := float32(0) // mm, of the firmware retraction in progress
+	suppressedRestart := float32(0)  // mm, still to be skipped after suppressed retractions
+	suppressedFirmwareRetraction := false
+
+	err := readLines(func(line gcode.Command, lineNumber int) error {
+		before := extrusion.TotalExtrusion
+		if retract := flavor.FirmwareRetract(); retract != "" && line.Command == retract && !line.HasArgs() {
+			drive := currentDrive()
+			distance := float32(0)
+			if drive < len(palette.RetractDistance) {
+				distance = palette.RetractDistance[drive]
+			}
+			if palette.SuppressSpliceRetractions && gears.wouldCross(before, distance) {
+				suppressedFirmwareRetraction = true
+				report.SuppressedRetractions++
+				return nil
+			}
+			firmwareRetraction = distance
+			extrusion.TotalExtrusion -= distance
+		} else if unretract := flavor.FirmwareUnretract(); unretract != "" && line.Command == unretract && !line.HasArgs() {
+			if suppressedFirmwareRetraction {
+				suppressedFirmwareRetraction = false
+				return nil
+			}
+			extrusion.TotalExtrusion += firmwareRetraction
+			firmwareRetraction = 0
+		} else {
+			if isRetraction, distance := isRetraction(line); isRetraction &&
+				palette.SuppressSpliceRetractions && extrusion.RelativeExtrusion &&
+				gears.wouldCross(before, distance) {
+				// (absolute E positions can't be adjusted this simply)
+				suppressedRestart += distance
+				report.SuppressedRetractions++
+				if !line.HasParam("x") && !line.HasParam("y") {
+					return nil
+				}
+				// still make the wipe move
+				return writeLine(writer, replaceExtrusion(line.Raw, 0, true))
+			}
+			if suppressedRestart > 0 && line.IsLinearMove() && !line.HasParam("x") && !line.HasParam("y") {
+				if e, ok := line.Param("e"); ok && e > 0 {
+					if e <= suppressedRestart {
+						suppressedRestart -= e
+						return nil
+					}
+					line = gcode.ParseLineArgs(replaceExtrusion(line.Raw, e-suppressedRestart, false))
+					suppressedRestart = 0
+				}
+			}
+			extrusion.TrackInstruction(line)
+		}
+		gears.track(before, extrusion.TotalExtrusion)
+		meltZone.track(before, extrusion.TotalExtrusion)
+		return writeLine(writer, line.Raw)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, splice := range splices {
+		passes := gears.counts[i]
+		if meltZone.counts[i] > passes {
+			passes = meltZone.counts[i]
+		}
+		report.Splices = append(report.Splices, SpliceStress{
+			Splice:         i + 1,
+			Drive:          splice.Drive,
+			Position:       splice.Length,
+			GearPasses:     gears.counts[i],
+			MeltZonePasses: meltZone.counts[i],
+			Risk:           getSpliceRisk(passes),
+		})
+	}
+	msfOut.SpliceStress = report
+	return nil
+}
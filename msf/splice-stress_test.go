@@ -0,0 +1,63 @@
+package msf
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_SpliceStressPass(t *testing.T) {
+	palette := getTestPalette(50)
+	palette.ExtruderGearDistance = 10
+	palette.MeltZoneLength = 5
+	printContent := `M83
+G1 X10 E85
+G1 E-3 ; retract
+G1 E3 ; unretract
+G1 X20 E6
+G1 X21 E-1.5 ; wipe and retract
+G1 E-0.5 ; retract
+G1 E2 ; unretract
+G1 X30 E100
+`
+	runPass := func() (MSF, string) {
+		msfOut := NewMSF(&palette)
+		msfOut.SpliceList = []Splice{
+			{Drive: 0, Length: 100},
+			{Drive: 1, Length: 300},
+		}
+		var output bytes.Buffer
+		writer := bufio.NewWriter(&output)
+		if err := spliceStressPass(getTestLineReader(printContent), writer, &msfOut); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return msfOut, output.String()
+	}
+
+	// the splice reaches the gears at E = 90, then is pulled back and pushed through again
+	msfOut, _ := runPass()
+	stress := msfOut.SpliceStress.Splices
+	if len(stress) != 1 {
+		t.Fatalf("expected 1 splice, got %d", len(stress))
+	}
+	if stress[0].GearPasses != 3 || stress[0].MeltZonePasses != 1 || stress[0].Risk != SpliceRiskMedium {
+		t.Errorf("unexpected splice stress %+v", stress[0])
+	}
+
+	palette.SuppressSpliceRetractions = true
+	msfOut, output := runPass()
+	stress = msfOut.SpliceStress.Splices
+	if stress[0].GearPasses != 1 || msfOut.SpliceStress.SuppressedRetractions != 1 {
+		t.Errorf("expected retractions to be suppressed, got %+v", msfOut.SpliceStress)
+	}
+	if !strings.Contains(output, "G1 X21 ; wipe and retract") || !strings.Contains(output, "G1 E0.5 ; unretract") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+	if !strings.Contains(output, "G1 E-3 ; retract") {
+		t.Error("expected retractions away from splices to be kept")
+	}
+}
@@ -20,6 +20,7 @@ type State struct {
 	PastStartSequence          bool
 	FirstToolChange            bool // don't treat the first T command as a toolchange
 	CurrentTool                int
+	PreviousTool               int // tool before the most recent toolchange
 	CurrentlyTransitioning     bool
 	NeedsPostTransitionZAdjust bool
 	PostTransitionZ            float32
@@ -36,7 +37,10 @@ type State struct {
 	NextPingStart    float32
 
 	TransitionNextPositions []SideTransitionLookahead
-	Locals                  sequences.Locals // for PrinterScript side transition sequences
+	SideTransitionLocation  SideTransitionLocation // where the current side transition purges
+	PurgeLocationIndex      int                    // index into Palette.SideTransitionLocations
+	PurgeLocationUsage      []float32              // mm purged at each location since they were last emptied
+	Locals                  sequences.Locals       // for PrinterScript side transition sequences
 }
 
 func NewState(palette *Palette) State {
@@ -45,5 +49,10 @@ func NewState(palette *Palette) State {
 		FirstToolChange: true,
 		CurrentLayer:    -1,
 		PingExtrusion:   palette.GetPingExtrusion(),
+		XYZF:            gcode.PositionTracker{HomePosition: palette.HomePosition},
+		// replaced before each side transition if there are multiple locations
+		SideTransitionLocation: palette.getDefaultSideTransitionLocation(),
+		// all Palette inputs are fed through the same hotend
+		Temperature: gcode.TemperatureTracker{SharedHotend: true, Flavor: palette.GetFlavor()},
 	}
 }
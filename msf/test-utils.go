@@ -160,9 +160,10 @@ func assertTransitionValuesMatch(t *testing.T, idx int, expected, actual Transit
 	}
 }
 
-func testTowerPreflight(t *testing.T, palette *Palette, printContent string, expectedTransitions []Transition) msfPreflight {
+// getTestLineReader returns a LineReader that replays printContent
+func getTestLineReader(printContent string) gcode.LineReader {
 	gcodeLines := gcode.ParseLines(printContent)
-	readerFn := func(callback gcode.LineCallback) error {
+	return func(callback gcode.LineCallback) error {
 		for lineNumber, line := range gcodeLines {
 			if err := callback(line, lineNumber); err != nil {
 				return err
@@ -170,7 +171,10 @@ func testTowerPreflight(t *testing.T, palette *Palette, printContent string, exp
 		}
 		return nil
 	}
-	results, err := _preflight(readerFn, palette)
+}
+
+func testTowerPreflight(t *testing.T, palette *Palette, printContent string, expectedTransitions []Transition) msfPreflight {
+	results, err := _preflight(getTestLineReader(printContent), palette)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -187,18 +191,8 @@ func testTowerPreflight(t *testing.T, palette *Palette, printContent string, exp
 
 func testTowerOutput(t *testing.T, palette *Palette, printContent string, preflight *msfPreflight, locals sequences.Locals) {
 	writer := bufio.NewWriter(ioutil.Discard)
-
-	gcodeLines := gcode.ParseLines(printContent)
-	readerFn := func(callback gcode.LineCallback) error {
-		for lineNumber, line := range gcodeLines {
-			if err := callback(line, lineNumber); err != nil {
-				return err
-			}
-		}
-		return nil
-	}
 	msfOut := NewMSF(palette)
-	err := _paletteOutput(readerFn, writer, &msfOut, palette, preflight, locals)
+	err := _paletteOutput(getTestLineReader(printContent), writer, &msfOut, palette, preflight, locals)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,139 @@
+package msf
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+)
+
+// getLayerInflation returns how far a layer's paths extend beyond the tower's bounding box
+func (t *Tower) getLayerInflation(layer int) float32 {
+	if layer < t.Palette.RaftLayers {
+		if layer == 0 {
+			return t.Palette.RaftInflation * 2
+		}
+		return t.Palette.RaftInflation
+	}
+	if layer == 0 && t.BrimCount > 0 {
+		return t.Palette.TowerExtrusionWidth * float32(t.BrimCount)
+	}
+	return 0
+}
+
+// getMaxInflation returns how far any layer's paths extend beyond the tower's bounding box
+func (t *Tower) getMaxInflation() float32 {
+	inflation := float32(0)
+	for layer := range t.Layers {
+		if layerInflation := t.getLayerInflation(layer); layerInflation > inflation {
+			inflation = layerInflation
+		}
+	}
+	return inflation
+}
+
+func boxesOverlap(aMinX, aMinY, aMaxX, aMaxY float32, b gcode.BoundingBox) bool {
+	return aMinX < b.Max[0] && aMaxX > b.Min[0] &&
+		aMinY < b.Max[1] && aMaxY > b.Min[1]
+}
+
+// findCollision returns the first layer on which the tower, centered at (x, y),
+// would come within TowerPrintClearance of the print, or -1 if there is none
+func (t *Tower) findCollision(x, y float32, layerFootprints []gcode.BoundingBox) int {
+	halfWidth := (t.BoundingBox.Max[0] - t.BoundingBox.Min[0]) / 2
+	halfHeight := (t.BoundingBox.Max[1] - t.BoundingBox.Min[1]) / 2
+	for layer := 0; layer < len(t.Layers) && layer < len(layerFootprints); layer++ {
+		footprint := layerFootprints[layer]
+		if footprint.Min[0] > footprint.Max[0] {
+			// nothing printed on this layer
+			continue
+		}
+		margin := t.getLayerInflation(layer) + TowerPrintClearance
+		if boxesOverlap(x-halfWidth-margin, y-halfHeight-margin, x+halfWidth+margin, y+halfHeight+margin, footprint) {
+			return layer
+		}
+	}
+	return -1
+}
+
+// bedLimitsKnown returns false if the printer profile did not include the bed limits
+func (t *Tower) bedLimitsKnown() bool {
+	palette := t.Palette
+	return palette.PrintBedMinX != palette.PrintBedMaxX && palette.PrintBedMinY != palette.PrintBedMaxY
+}
+
+// fitsOnBed returns true if the tower, centered at (x, y), is within the bed limits
+func (t *Tower) fitsOnBed(x, y float32) bool {
+	palette := t.Palette
+	if !t.bedLimitsKnown() {
+		return true
+	}
+	halfWidth := (t.BoundingBox.Max[0]-t.BoundingBox.Min[0])/2 + t.getMaxInflation()
+	halfHeight := (t.BoundingBox.Max[1]-t.BoundingBox.Min[1])/2 + t.getMaxInflation()
+	return x-halfWidth >= palette.PrintBedMinX && x+halfWidth <= palette.PrintBedMaxX &&
+		y-halfHeight >= palette.PrintBedMinY && y+halfHeight <= palette.PrintBedMaxY
+}
+
+// moveTo re-centers the tower at (x, y)
+func (t *Tower) moveTo(x, y float32) {
+	halfWidth := (t.BoundingBox.Max[0] - t.BoundingBox.Min[0]) / 2
+	halfHeight := (t.BoundingBox.Max[1] - t.BoundingBox.Min[1]) / 2
+	t.BoundingBox.Min[0] = x - halfWidth
+	t.BoundingBox.Max[0] = x + halfWidth
+	t.BoundingBox.Min[1] = y - halfHeight
+	t.BoundingBox.Max[1] = y + halfHeight
+}
+
+// place checks the tower at the user's position against the bed limits and the print,
+// and if automatic placement is enabled, moves it to the nearest legal position instead.
+// Layer footprints are bounding boxes, so apparent overlaps may be false positives:
+// they only produce warnings, and the tower stays put if no other position is found.
+func (t *Tower) place(layerFootprints []gcode.BoundingBox) error {
+	palette := t.Palette
+	x, y := palette.TowerPosition[0], palette.TowerPosition[1]
+	onBed := t.fitsOnBed(x, y)
+	collisionLayer := t.findCollision(x, y, layerFootprints)
+	if onBed && collisionLayer < 0 {
+		return nil
+	}
+
+	if !palette.TowerAutoPlace {
+		if !onBed {
+			log.Printf("warning: the transition tower centered at (%.2f, %.2f) may extend past the edge of the print bed\n", x, y)
+		} else {
+			log.Printf("warning: the transition tower centered at (%.2f, %.2f) may overlap the print on layer %d\n", x, y, collisionLayer+1)
+		}
+		return nil
+	}
+
+	if !t.bedLimitsKnown() {
+		message := "Transition Tower Overlaps Print\n"
+		message += fmt.Sprintf("The transition tower centered at (%.2f, %.2f) would overlap the print on layer %d, ", x, y, collisionLayer+1)
+		message += "and it cannot be moved automatically because the print bed limits are unknown."
+		return errors.New(message)
+	}
+
+	// search the bed for the legal position nearest to the user's
+	bestX, bestY := float32(0), float32(0)
+	bestDistance := math.Inf(1)
+	for candidateY := palette.PrintBedMinY; candidateY <= palette.PrintBedMaxY; candidateY += TowerPlacementStep {
+		for candidateX := palette.PrintBedMinX; candidateX <= palette.PrintBedMaxX; candidateX += TowerPlacementStep {
+			distance := math.Hypot(float64(candidateX-x), float64(candidateY-y))
+			if distance >= bestDistance {
+				continue
+			}
+			if t.fitsOnBed(candidateX, candidateY) && t.findCollision(candidateX, candidateY, layerFootprints) < 0 {
+				bestX, bestY = candidateX, candidateY
+				bestDistance = distance
+			}
+		}
+	}
+	if math.IsInf(bestDistance, 1) {
+		log.Printf("warning: found no position for the transition tower clear of the print, keeping it at (%.2f, %.2f)\n", x, y)
+		return nil
+	}
+	t.moveTo(bestX, bestY)
+	return nil
+}
@@ -0,0 +1,218 @@
+package msf
+
+import (
+	"math"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+)
+
+// towerOutline is the boundary of a set of tower paths: either the rectangle
+// given by its bounds, or the circle inscribed in them
+type towerOutline struct {
+	shape                  TowerShape
+	xMin, yMin, xMax, yMax float32
+}
+
+// inset shrinks the outline by distance on every side (or grows it, if negative)
+func (o towerOutline) inset(distance float32) towerOutline {
+	o.xMin += distance
+	o.yMin += distance
+	o.xMax -= distance
+	o.yMax -= distance
+	return o
+}
+
+func (o towerOutline) isEmpty() bool {
+	return o.xMax <= o.xMin || o.yMax <= o.yMin
+}
+
+func (o towerOutline) center() (float32, float32) {
+	return (o.xMin + o.xMax) / 2, (o.yMin + o.yMax) / 2
+}
+
+func (o towerOutline) radius() float32 {
+	return float32(math.Min(float64(o.xMax-o.xMin), float64(o.yMax-o.yMin))) / 2
+}
+
+// loop returns the vertices of the outline, counter-clockwise from the southeast,
+// with the first vertex repeated at the end to close the loop
+func (o towerOutline) loop() [][2]float32 {
+	if o.shape != TowerShapeCylinder {
+		return [][2]float32{
+			{o.xMax, o.yMin},
+			{o.xMax, o.yMax},
+			{o.xMin, o.yMax},
+			{o.xMin, o.yMin},
+			{o.xMax, o.yMin},
+		}
+	}
+	centerX, centerY := o.center()
+	radius := float64(o.radius())
+	segments := int(math.Ceil(2 * math.Pi * radius / TowerArcSegmentLength))
+	if segments < 8 {
+		segments = 8
+	}
+	vertices := make([][2]float32, 0, segments+1)
+	for i := 0; i <= segments; i++ {
+		angle := -math.Pi/4 + 2*math.Pi*float64(i%segments)/float64(segments)
+		vertices = append(vertices, [2]float32{
+			centerX + float32(radius*math.Cos(angle)),
+			centerY + float32(radius*math.Sin(angle)),
+		})
+	}
+	return vertices
+}
+
+// extent returns how far the outline reaches from its center along the unit vector (nx, ny)
+func (o towerOutline) extent(nx, ny float64) float64 {
+	if o.shape == TowerShapeCylinder {
+		return float64(o.radius())
+	}
+	return math.Abs(nx)*float64(o.xMax-o.xMin)/2 + math.Abs(ny)*float64(o.yMax-o.yMin)/2
+}
+
+// span clips the line through (x, y) with unit direction (dx, dy) to the outline,
+// returning the distances along the line from (x, y) to where it enters and leaves
+func (o towerOutline) span(x, y, dx, dy float64) (float64, float64, bool) {
+	if o.shape == TowerShapeCylinder {
+		centerX, centerY := o.center()
+		radius := float64(o.radius())
+		// solve |(x, y) + t(dx, dy) - center| = radius
+		offsetX, offsetY := x-float64(centerX), y-float64(centerY)
+		b := offsetX*dx + offsetY*dy
+		c := offsetX*offsetX + offsetY*offsetY - radius*radius
+		discriminant := b*b - c
+		if discriminant <= 0 {
+			return 0, 0, false
+		}
+		root := math.Sqrt(discriminant)
+		return -b - root, -b + root, true
+	}
+	tMin, tMax := math.Inf(-1), math.Inf(1)
+	clip := func(position, direction float64, min, max float32) bool {
+		if direction == 0 {
+			return position >= float64(min) && position <= float64(max)
+		}
+		t1 := (float64(min) - position) / direction
+		t2 := (float64(max) - position) / direction
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tMin = math.Max(tMin, t1)
+		tMax = math.Min(tMax, t2)
+		return true
+	}
+	if !clip(x, dx, o.xMin, o.xMax) || !clip(y, dy, o.yMin, o.yMax) {
+		return 0, 0, false
+	}
+	return tMin, tMax, tMin < tMax
+}
+
+// getLayerOutline returns the outer boundary of a layer's paths, excluding brims
+func (t *Tower) getLayerOutline(layer int) towerOutline {
+	outline := towerOutline{
+		shape: t.Palette.TowerShape,
+		xMin:  t.BoundingBox.Min[0],
+		xMax:  t.BoundingBox.Max[0],
+		yMin:  t.BoundingBox.Min[1],
+		yMax:  t.BoundingBox.Max[1],
+	}
+	if layer < t.Palette.RaftLayers {
+		inflation := t.Palette.RaftInflation
+		if layer == 0 {
+			inflation *= 2
+		}
+		outline = outline.inset(-inflation)
+	}
+	return outline
+}
+
+// towerPathBuilder appends paths to the current layer, tracking the nozzle position
+// so that each extrusion can be sized by the length of the line it prints
+type towerPathBuilder struct {
+	tower               *Tower
+	extrusionWidth      float32
+	layerThickness      float32
+	extrusionMultiplier float32
+	x, y                float32
+}
+
+func (b *towerPathBuilder) travel(x, y float32) {
+	b.tower.CurrentLayerPaths = append(b.tower.CurrentLayerPaths, AnnotatedCommand{
+		gcode: gcode.Command{
+			Command: "G1",
+			Params: map[string]float32{
+				"x": x,
+				"y": y,
+			},
+		},
+	})
+	b.x, b.y = x, y
+}
+
+func (b *towerPathBuilder) extrude(x, y float32) {
+	lineLength := getLineLength(b.x, b.y, x, y) // mm
+	deltaE := getExtrusionLength(b.extrusionWidth, b.layerThickness, lineLength) * b.extrusionMultiplier
+	b.tower.CurrentLayerExtrusion += deltaE
+	b.tower.CurrentLayerPaths = append(b.tower.CurrentLayerPaths, AnnotatedCommand{
+		gcode: gcode.Command{
+			Command: "G1",
+			Params: map[string]float32{
+				"x": x,
+				"y": y,
+			},
+		},
+		extrusion: deltaE,
+	})
+	b.x, b.y = x, y
+}
+
+func (b *towerPathBuilder) addLoop(outline towerOutline) {
+	vertices := outline.loop()
+	b.travel(vertices[0][0], vertices[0][1])
+	for _, vertex := range vertices[1:] {
+		b.extrude(vertex[0], vertex[1])
+	}
+}
+
+// addLinearInfill fills the outline with lines in direction (dx, dy), stride apart,
+// alternating the direction of each line. If continuous, each line is joined to
+// the last by an extrusion rather than a travel.
+func (b *towerPathBuilder) addLinearInfill(outline towerOutline, dx, dy, stride float64, continuous bool) {
+	length := math.Hypot(dx, dy)
+	dx, dy = dx/length, dy/length
+	nx, ny := -dy, dx // normal to the lines
+	centerX, centerY := outline.center()
+	extent := outline.extent(nx, ny)
+
+	firstLine := true
+	reverse := false
+	for offset := -extent + stride/2; offset < extent; offset += stride {
+		x := float64(centerX) + offset*nx
+		y := float64(centerY) + offset*ny
+		tStart, tEnd, ok := outline.span(x, y, dx, dy)
+		if !ok {
+			continue
+		}
+		if reverse {
+			tStart, tEnd = tEnd, tStart
+		}
+		x1, y1 := float32(x+tStart*dx), float32(y+tStart*dy)
+		x2, y2 := float32(x+tEnd*dx), float32(y+tEnd*dy)
+		if continuous && !firstLine {
+			b.extrude(x1, y1)
+		} else {
+			b.travel(x1, y1)
+		}
+		b.extrude(x2, y2)
+		firstLine = false
+		reverse = !reverse
+	}
+}
+
+// addConcentricInfill fills the outline with loops, stride apart, working inwards
+func (b *towerPathBuilder) addConcentricInfill(outline towerOutline, stride float32) {
+	for ; !outline.isEmpty(); outline = outline.inset(stride) {
+		b.addLoop(outline)
+	}
+}
@@ -43,9 +43,11 @@ type Tower struct {
 	CurrentLayerTransitionIndex int                // current transition on this layer
 	CurrentLayerCommandIndex    int                // index into CurrentLayerPaths
 	CurrentLayerExtrusion       float32            // sum of extrusions in CurrentLayerPaths
+	CurrentLayerX               float32            // end of the last path output on this layer
+	CurrentLayerY               float32            // end of the last path output on this layer
 }
 
-func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool) {
+func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool, error) {
 	totalLayers := preflight.totalLayers
 	tower := Tower{
 		Palette:     palette,
@@ -82,7 +84,7 @@ func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool) {
 	}
 	if totalLayers == 0 {
 		// no dense layers == no Palette processing
-		return tower, false
+		return tower, false, nil
 	}
 	layerTransitionCounts = layerTransitionCounts[:totalLayers]
 
@@ -142,7 +144,7 @@ func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool) {
 	}
 	if footprintArea == 0 {
 		// no dense layers == no Palette processing
-		return tower, false
+		return tower, false, nil
 	}
 
 	// 7. finalize the tower dimensions
@@ -158,6 +160,11 @@ func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool) {
 	}
 	towerWidth = squareLength / aspectRatio
 	towerHeight = squareLength * aspectRatio
+	if palette.TowerShape == TowerShapeCylinder {
+		// aspect ratio doesn't apply -- use a circle of the same area
+		towerWidth = 2 * math.Sqrt(footprintArea/math.Pi)
+		towerHeight = towerWidth
+	}
 	towerHalfHeight := float32(towerWidth) / 2
 	towerHalfWidth := float32(towerHeight) / 2
 
@@ -179,10 +186,16 @@ func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool) {
 			// if perimeters will be added, account for it
 			if density <= TowerPerimeterThreshold {
 				perimeterLength := float32((4*towerWidth)+(4*towerHeight)) - (8 * extrusionWidth)
-				perimeterExtrusion := getExtrusionLength(extrusionWidth, layerThickness, perimeterLength) * extrusionMultiplier
-				perimeterVolume := float64(filamentLengthToVolume(perimeterExtrusion))
 				doubleEW := 2 * float64(extrusionWidth)
 				infillFootprint := footprintArea - (doubleEW * towerWidth) - (doubleEW * (towerHeight - doubleEW))
+				if palette.TowerShape == TowerShapeCylinder {
+					// outer loop (diameter 2r) and inner loop (diameter 2r - 2ew)
+					radius := towerWidth / 2
+					perimeterLength = float32((math.Pi * 2 * radius) + (math.Pi * ((2 * radius) - doubleEW)))
+					infillFootprint = math.Pi * (radius - doubleEW) * (radius - doubleEW)
+				}
+				perimeterExtrusion := getExtrusionLength(extrusionWidth, layerThickness, perimeterLength) * extrusionMultiplier
+				perimeterVolume := float64(filamentLengthToVolume(perimeterExtrusion))
 				fullInfillVolume := infillFootprint * float64(layerThickness)
 				requiredInfillVolume := (minLayerVolume - perimeterVolume) * 1.1
 				density = requiredInfillVolume / fullInfillVolume
@@ -219,22 +232,33 @@ func GenerateTower(palette *Palette, preflight *msfPreflight) (Tower, bool) {
 
 	// 10. determine number of first-layer brims needed
 	if palette.RaftLayers == 0 {
-		firstTransitionTotalE := preflight.transitions[0].TotalExtrusion
-		firstTransitionTotalE += minLayerExtrusion * float32(preflight.transitions[0].Layer)
-		firstTransitionTotalE += preflight.transitions[0].TransitionLength * (palette.TransitionTarget / 100)
+		firstTransition := preflight.transitions[0]
+		firstTransitionTotalE := firstTransition.TotalExtrusion
+		firstTransitionTotalE += minLayerExtrusion * float32(firstTransition.Layer)
+		firstTransitionTotalE += firstTransition.TransitionLength * (palette.GetTransitionTarget(firstTransition.To, firstTransition.From) / 100)
 		firstLayerThickness := tower.Layers[0].Thickness
 		minFirstSpliceLength := palette.GetFirstSpliceMinLength()
 		perimeterLength := (towerHalfWidth * 4) + (towerHalfHeight * 4) + (palette.TowerExtrusionWidth * 8)
+		perimeterStep := palette.TowerExtrusionWidth * 8
+		if palette.TowerShape == TowerShapeCylinder {
+			perimeterLength = 2 * math.Pi * (towerHalfWidth + palette.TowerExtrusionWidth)
+			perimeterStep = 2 * math.Pi * palette.TowerExtrusionWidth
+		}
 		for firstTransitionTotalE < minFirstSpliceLength || tower.BrimCount < palette.TowerMinBrims {
 			tower.BrimCount++
 			brimExtrusion := getExtrusionLength(extrusionWidth, firstLayerThickness, perimeterLength) * extrusionMultiplier
 			firstTransitionTotalE += brimExtrusion
 			tower.BrimExtrusion += brimExtrusion
-			perimeterLength += palette.TowerExtrusionWidth * 8
+			perimeterLength += perimeterStep
 		}
 	}
 
-	return tower, true
+	// 11. make sure the tower (including brims and rafts) stays on the bed and clear of the print
+	if err := tower.place(preflight.layerFootprints); err != nil {
+		return tower, true, err
+	}
+
+	return tower, true, nil
 }
 
 func (t *Tower) layerNeedsPerimeters(layer int, density float32) bool {
@@ -250,37 +274,30 @@ func (t *Tower) layerNeedsPerimeters(layer int, density float32) bool {
 	return density <= TowerPerimeterThreshold
 }
 
-func (t *Tower) rasterizeLayer(layer int) {
-	xMin := t.BoundingBox.Min[0]
-	xMax := t.BoundingBox.Max[0]
-	yMin := t.BoundingBox.Min[1]
-	yMax := t.BoundingBox.Max[1]
+func (t *Tower) getLayerExtrusionWidth(layer int) float32 {
 	if layer < t.Palette.RaftLayers {
-		inflation := t.Palette.RaftInflation
-		if layer == 0 {
-			inflation *= 2
-		}
-		xMin -= inflation
-		yMin -= inflation
-		xMax += inflation
-		yMax += inflation
+		return t.Palette.RaftExtrusionWidth
 	}
+	return t.Palette.TowerExtrusionWidth
+}
+
+func (t *Tower) rasterizeLayer(layer int) {
+	outline := t.getLayerOutline(layer)
 
 	t.CurrentLayerPaths = make([]AnnotatedCommand, 0)
 	t.CurrentLayerExtrusion = 0
-	currentXMin := xMin
-	currentXMax := xMax
-	currentYMin := yMin
-	currentYMax := yMax
 
 	layerThickness := t.Layers[layer].Thickness
 	density := t.Layers[layer].Density
-	extrusionWidth := t.Palette.TowerExtrusionWidth
+	extrusionWidth := t.getLayerExtrusionWidth(layer)
 	extrusionMultiplier := t.Palette.TowerExtrusionMultiplier / 100
-	if layer < t.Palette.RaftLayers {
-		extrusionWidth = t.Palette.RaftExtrusionWidth
-	}
 	addPerimeters := t.layerNeedsPerimeters(layer, density)
+	paths := towerPathBuilder{
+		tower:               t,
+		extrusionWidth:      extrusionWidth,
+		layerThickness:      layerThickness,
+		extrusionMultiplier: extrusionMultiplier,
+	}
 
 	// create perimeters
 
@@ -288,114 +305,16 @@ func (t *Tower) rasterizeLayer(layer int) {
 		perimeterCount := TowerPerimeterCount
 		if layer == 0 && t.BrimCount > 0 {
 			perimeterCount += t.BrimCount
-			inflation := extrusionWidth * float32(t.BrimCount)
-			currentXMin -= inflation
-			currentYMin -= inflation
-			currentXMax += inflation
-			currentYMax += inflation
+			outline = outline.inset(-extrusionWidth * float32(t.BrimCount))
 		}
 		for i := 0; i < perimeterCount; i++ {
-			// travel to southeast corner
-			nextX, nextY := currentXMax, currentYMin
-			travel := AnnotatedCommand{
-				gcode: gcode.Command{
-					Command: "G1",
-					Params: map[string]float32{
-						"x": nextX,
-						"y": nextY,
-					},
-				},
-			}
-			// for next extrusion line's length
-			fromX, fromY := nextX, nextY
-
-			// extrude to northeast corner
-			nextX, nextY = currentXMax, currentYMax
-			lineLength := getLineLength(fromX, fromY, nextX, nextY) // mm
-			deltaE := getExtrusionLength(extrusionWidth, layerThickness, lineLength) * extrusionMultiplier
-			t.CurrentLayerExtrusion += deltaE
-			extrudeNorth := AnnotatedCommand{
-				gcode: gcode.Command{
-					Command: "G1",
-					Params: map[string]float32{
-						"x": nextX,
-						"y": nextY,
-					},
-				},
-				extrusion: deltaE,
-			}
-			fromX, fromY = nextX, nextY
-
-			// extrude to northwest corner
-			nextX, nextY = currentXMin, currentYMax
-			lineLength = getLineLength(fromX, fromY, nextX, nextY) // mm
-			deltaE = getExtrusionLength(extrusionWidth, layerThickness, lineLength) * extrusionMultiplier
-			t.CurrentLayerExtrusion += deltaE
-			extrudeWest := AnnotatedCommand{
-				gcode: gcode.Command{
-					Command: "G1",
-					Params: map[string]float32{
-						"x": nextX,
-						"y": nextY,
-					},
-				},
-				extrusion: deltaE,
-			}
-			fromX, fromY = nextX, nextY
-
-			// extrude to southwest corner
-			nextX, nextY = currentXMin, currentYMin
-			lineLength = getLineLength(fromX, fromY, nextX, nextY) // mm
-			deltaE = getExtrusionLength(extrusionWidth, layerThickness, lineLength) * extrusionMultiplier
-			t.CurrentLayerExtrusion += deltaE
-			extrudeSouth := AnnotatedCommand{
-				gcode: gcode.Command{
-					Command: "G1",
-					Params: map[string]float32{
-						"x": nextX,
-						"y": nextY,
-					},
-				},
-				extrusion: deltaE,
-			}
-			fromX, fromY = nextX, nextY
-
-			// extrude to southeast corner
-			nextX, nextY = currentXMax, currentYMin
-			lineLength = getLineLength(fromX, fromY, nextX, nextY) // mm
-			deltaE = getExtrusionLength(extrusionWidth, layerThickness, lineLength) * extrusionMultiplier
-			t.CurrentLayerExtrusion += deltaE
-			extrudeEast := AnnotatedCommand{
-				gcode: gcode.Command{
-					Command: "G1",
-					Params: map[string]float32{
-						"x": nextX,
-						"y": nextY,
-					},
-				},
-				extrusion: deltaE,
-			}
-			fromX, fromY = nextX, nextY
-
-			t.CurrentLayerPaths = append(t.CurrentLayerPaths,
-				travel,
-				extrudeNorth,
-				extrudeWest,
-				extrudeSouth,
-				extrudeEast,
-			)
+			paths.addLoop(outline)
 			// step inward by 1 extrusion width
-			currentXMin += extrusionWidth
-			currentYMin += extrusionWidth
-			currentXMax -= extrusionWidth
-			currentYMax -= extrusionWidth
+			outline = outline.inset(extrusionWidth)
 		}
 		// step outward slightly to produce an infill-perimeter overlap
 		overlap := extrusionWidth * (t.Palette.InfillPerimeterOverlap / 100)
-		currentXMin -= overlap
-		currentYMin -= overlap
-		currentXMax += overlap
-		currentYMax += overlap
+		outline = outline.inset(-overlap)
 	}
 
 	// create infill
@@ -404,15 +323,51 @@ func (t *Tower) rasterizeLayer(layer int) {
 	if layer < t.Palette.RaftLayers {
 		stride = t.Palette.RaftStride
 	}
+	// raft layers should have continuous extrusion after the initial travel
+	continuous := layer < t.Palette.RaftLayers
+
+	switch t.Palette.TowerInfill {
+	case TowerInfillRectilinear, TowerInfillSerpentine:
+		// follow the long axis, or alternate axes on cylinders, which don't have one
+		dx, dy := 1.0, 0.0
+		if t.Palette.TowerShape == TowerShapeCylinder {
+			if layer%2 == 1 {
+				dx, dy = 0, 1
+			}
+		} else if outline.yMax-outline.yMin > outline.xMax-outline.xMin {
+			dx, dy = 0, 1
+		}
+		continuous = continuous || t.Palette.TowerInfill == TowerInfillSerpentine
+		paths.addLinearInfill(outline, dx, dy, float64(stride), continuous)
+	case TowerInfillConcentric:
+		paths.addConcentricInfill(outline, stride)
+	default:
+		if t.Palette.TowerShape == TowerShapeCylinder {
+			// mirror every other layer
+			dx := 1.0
+			if layer%2 == 1 {
+				dx = -1
+			}
+			paths.addLinearInfill(outline, dx, 1, float64(stride), continuous)
+		} else {
+			paths.addDiagonalInfill(outline, stride, layer%2 == 1, continuous)
+		}
+	}
+}
+
+// addDiagonalInfill fills a rectangular outline with 45-degree lines, stride apart
+func (b *towerPathBuilder) addDiagonalInfill(outline towerOutline, stride float32, reverseLayer, continuous bool) {
+	currentXMin := outline.xMin
+	currentXMax := outline.xMax
+	currentYMin := outline.yMin
+	currentYMax := outline.yMax
 	axisAlignedStride := float32(math.Sqrt(float64(stride * stride * 2)))
 
 	firstLine := true
-	var lastX, lastY float32 // populated once firstLine == false
 	needsMoreLines := true
-	xBoundReached := false       // once reached, step southwest vertex north instead of west
-	yBoundReached := false       // once reached, step northeast vertex west instead of north
-	printSouthwest := true       // direction of the next extrusion line ("back" or "forth")
-	reverseLayer := layer%2 == 1 // mirror every other layer's X coordinates
+	xBoundReached := false // once reached, step southwest vertex north instead of west
+	yBoundReached := false // once reached, step northeast vertex west instead of north
+	printSouthwest := true // direction of the next extrusion line ("back" or "forth")
 
 	neX := currentXMax
 	neY := currentYMin
@@ -461,41 +416,14 @@ func (t *Tower) rasterizeLayer(layer int) {
 			x2 = currentXMax + currentXMin - x2
 		}
 
-		// travel to (x1, y1)
-		travel := AnnotatedCommand{
-			gcode: gcode.Command{
-				Command: "G1",
-				Params: map[string]float32{
-					"x": x1,
-					"y": y1,
-				},
-			},
-		}
-		if !firstLine && layer < t.Palette.RaftLayers {
-			// raft layers should have continuous extrusion after the initial travel
-			lineLength := getLineLength(lastX, lastY, x1, y1) // mm
-			travel.extrusion = getExtrusionLength(extrusionWidth, layerThickness, lineLength) * extrusionMultiplier
-			t.CurrentLayerExtrusion += travel.extrusion
+		// move to (x1, y1), then extrude to (x2, y2)
+		if continuous && !firstLine {
+			b.extrude(x1, y1)
+		} else {
+			b.travel(x1, y1)
 		}
 		firstLine = false
-		lastX, lastY = x1, y1
-
-		// extrude to (x2, y2)
-		extrude := AnnotatedCommand{
-			gcode: gcode.Command{
-				Command: "G1",
-				Params: map[string]float32{
-					"x": x2,
-					"y": y2,
-				},
-			},
-		}
-		lineLength := getLineLength(lastX, lastY, x2, y2) // mm
-		extrude.extrusion = getExtrusionLength(extrusionWidth, layerThickness, lineLength) * extrusionMultiplier
-		t.CurrentLayerExtrusion += extrude.extrusion
-		lastX, lastY = x2, y2
-
-		t.CurrentLayerPaths = append(t.CurrentLayerPaths, travel, extrude)
+		b.extrude(x2, y2)
 
 		if neX-currentXMin < axisAlignedStride && currentYMax-swY < axisAlignedStride {
 			// layer has been fully rasterized
@@ -505,7 +433,6 @@ func (t *Tower) rasterizeLayer(layer int) {
 			printSouthwest = !printSouthwest
 		}
 	}
-
 }
 
 func (t *Tower) IsComplete() bool {
@@ -549,19 +476,31 @@ func (t *Tower) moveToTower(state *State) (string, error) {
 	sequence += fmt.Sprintf(";WIDTH:%s%s", gcode.FormatFloat(float64(t.Palette.TowerExtrusionWidth)), EOL)
 	sequence += fmt.Sprintf(";HEIGHT:%s%s", gcode.FormatFloat(float64(t.Layers[t.CurrentLayerIndex].Thickness)), EOL)
 
-	// next tower command should always be a travel
+	// next tower command should be a travel, unless the last segment stopped partway
+	// through a continuous path -- in that case, travel back to where it left off
 	annotatedTravel := t.CurrentLayerPaths[t.CurrentLayerCommandIndex]
 	travel := annotatedTravel.gcode
 	if annotatedTravel.extrusion > 0 {
-		return "", errors.New("tower segment started with extrusion, not travel")
+		if t.CurrentLayerCommandIndex == 0 {
+			return "", errors.New("tower layer started with extrusion, not travel")
+		}
+		travel = gcode.Command{
+			Command: "G1",
+			Params: map[string]float32{
+				"x": t.CurrentLayerX,
+				"y": t.CurrentLayerY,
+			},
+		}
+	} else {
+		t.CurrentLayerCommandIndex++ // use up the command
 	}
 	travel.Params["f"] = state.Palette.TravelSpeedXY
 	travel.Comment = "move to tower"
-	t.CurrentLayerCommandIndex++ // use up the command
-	sequence += travel.String() + EOL
 
-	state.TimeEstimate += estimateMoveTime(state.XYZF.CurrentX, state.XYZF.CurrentY, travel.Params["x"], travel.Params["y"], travel.Params["f"])
+	useRelativeXYZ(state, &travel)
+	state.TimeEstimate += getTimeEstimate(travel, state)
 	state.XYZF.TrackInstruction(travel)
+	sequence += travel.String() + EOL
 
 	// z-lift down if needed
 	if topZ := t.CurrentLayerTopZ(); state.XYZF.CurrentZ > topZ {
@@ -572,7 +511,7 @@ func (t *Tower) moveToTower(state *State) (string, error) {
 		// un-retract
 		sequence += getRestart(state, state.E.CurrentRetraction, state.Palette.RestartFeedrate[state.CurrentTool])
 	} else if state.Palette.UseFirmwareRetraction {
-		sequence += getFirmwareRestart()
+		sequence += getFirmwareRestart(state)
 	}
 	return sequence, nil
 }
@@ -586,7 +525,7 @@ func (t *Tower) leaveTower(state *State, retractDistance float32) string {
 		// restore any retraction from before tower was started
 		sequence += getRetract(state, retractDistance, state.Palette.RetractFeedrate[state.CurrentTool])
 	} else if state.Palette.UseFirmwareRetraction {
-		sequence += getFirmwareRetract()
+		sequence += getFirmwareRetract(state)
 	}
 	sequence += resetEAxis(state)
 	if state.Palette.ZLift[state.CurrentTool] > 0 {
@@ -614,11 +553,11 @@ func (t *Tower) getNextPath(state *State, printFeedrate float32) (string, float3
 	} else {
 		// travel command
 	}
-	currentX := state.XYZF.CurrentX
-	currentY := state.XYZF.CurrentY
 	currentFeedrate := state.XYZF.CurrentFeedrate
+	t.CurrentLayerX, t.CurrentLayerY = command.Params["x"], command.Params["y"]
 
-	state.TimeEstimate += estimateMoveTime(currentX, currentY, command.Params["x"], command.Params["y"], command.Params["f"])
+	useRelativeXYZ(state, &command)
+	state.TimeEstimate += getTimeEstimate(command, state)
 	state.XYZF.TrackInstruction(command)
 	state.E.TrackInstruction(command)
 
@@ -750,6 +689,8 @@ func (t *Tower) getNextDenseSegmentPaths(state *State) string {
 		transitionInfo.To,
 		transitionInfo.From,
 		state.CurrentLayer,
+		t.getLayerExtrusionWidth(t.CurrentLayerIndex),
+		t.Layers[t.CurrentLayerIndex].Thickness,
 	)
 
 	sequence := ""
@@ -769,7 +710,11 @@ func (t *Tower) getNextDenseSegmentPaths(state *State) string {
 }
 
 func (t *Tower) getNextSparseLayerPaths(state *State) string {
-	printFeedrate := t.Palette.TowerSpeed[state.CurrentTool] * 60
+	printFeedrate := t.Palette.GetSparseTowerPrintSpeed(
+		state.CurrentTool,
+		t.getLayerExtrusionWidth(t.CurrentLayerIndex),
+		t.Layers[t.CurrentLayerIndex].Thickness,
+	)
 
 	sequence := ""
 
@@ -1,7 +1,10 @@
 package msf
 
 import (
+	"math"
+	"mosaicmfg.com/ps-postprocess/gcode"
 	"mosaicmfg.com/ps-postprocess/sequences"
+	"strings"
 	"testing"
 )
 
@@ -324,3 +327,139 @@ func Test_8ColorTowerVariableTransitions(t *testing.T) {
 //   - inclusion of sparse layers
 //   - infill transitions enabled
 //   - variable transition lengths
+
+func Test_TowerPlacement(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.TowerPosition = [2]float32{50, 50}
+	tower := Tower{
+		Palette:     &palette,
+		BoundingBox: gcode.NewBoundingBox(),
+		Layers:      make([]TowerLayer, 2),
+	}
+	tower.BoundingBox.Min[0], tower.BoundingBox.Max[0] = 40, 60
+	tower.BoundingBox.Min[1], tower.BoundingBox.Max[1] = 40, 60
+
+	// print occupies the middle of the bed on the second layer
+	footprints := []gcode.BoundingBox{gcode.NewBoundingBox(), gcode.NewBoundingBox()}
+	footprints[1].ExpandX(30)
+	footprints[1].ExpandX(70)
+	footprints[1].ExpandY(30)
+	footprints[1].ExpandY(70)
+
+	// without automatic placement, the tower stays where the user put it
+	if err := tower.place(footprints); err != nil {
+		t.Fatal(err)
+	}
+	if tower.BoundingBox.Min[0] != 40 || tower.BoundingBox.Min[1] != 40 {
+		t.Fatalf("expected the tower not to move, got %v", tower.BoundingBox)
+	}
+
+	palette.TowerAutoPlace = true
+	if err := tower.place(footprints); err != nil {
+		t.Fatal(err)
+	}
+	if tower.findCollision(tower.BoundingBox.Min[0]+10, tower.BoundingBox.Min[1]+10, footprints) >= 0 {
+		t.Fatalf("tower was placed on the print at %v", tower.BoundingBox)
+	}
+	if tower.BoundingBox.Min[0] < palette.PrintBedMinX || tower.BoundingBox.Min[1] < palette.PrintBedMinY {
+		t.Fatalf("tower was placed off the bed at %v", tower.BoundingBox)
+	}
+
+	// no room anywhere -- keep the user's position
+	footprints[1].ExpandX(0)
+	footprints[1].ExpandX(100)
+	footprints[1].ExpandY(0)
+	footprints[1].ExpandY(100)
+	tower.moveTo(50, 50)
+	if err := tower.place(footprints); err != nil {
+		t.Fatal(err)
+	}
+	if tower.BoundingBox.Min[0] != 40 || tower.BoundingBox.Min[1] != 40 {
+		t.Fatalf("expected the tower not to move, got %v", tower.BoundingBox)
+	}
+
+	// nowhere to search without bed limits
+	palette.PrintBedMinX, palette.PrintBedMaxX = 0, 0
+	palette.PrintBedMinY, palette.PrintBedMaxY = 0, 0
+	if err := tower.place(footprints); err == nil || !strings.Contains(err.Error(), "bed limits are unknown") {
+		t.Fatalf("expected an error for automatic placement without bed limits, got %v", err)
+	}
+}
+
+func Test_TowerShapesAndInfill(t *testing.T) {
+	shapes := []TowerShape{TowerShapeRectangle, TowerShapeCylinder}
+	patterns := []TowerInfill{TowerInfillDiagonal, TowerInfillRectilinear, TowerInfillConcentric, TowerInfillSerpentine}
+	for _, shape := range shapes {
+		for _, pattern := range patterns {
+			palette := getTestPalette(30)
+			palette.TowerShape = shape
+			palette.TowerInfill = pattern
+			tower := Tower{
+				Palette:     &palette,
+				BoundingBox: gcode.NewBoundingBox(),
+				Layers: []TowerLayer{
+					{TopZ: 0.2, Thickness: 0.2, Density: 0.5},
+					{TopZ: 0.4, Thickness: 0.2, Density: 0.5},
+				},
+			}
+			tower.BoundingBox.Min[0], tower.BoundingBox.Max[0] = 40, 60
+			tower.BoundingBox.Min[1], tower.BoundingBox.Max[1] = 40, 60
+			area := float32(20 * 20)
+			if shape == TowerShapeCylinder {
+				area = float32(math.Pi * 10 * 10)
+			}
+
+			for layer, towerLayer := range tower.Layers {
+				tower.rasterizeLayer(layer)
+				if tower.CurrentLayerPaths[0].extrusion > 0 {
+					t.Fatalf("%s/%s: layer %d started with extrusion", shape, pattern, layer)
+				}
+				for _, path := range tower.CurrentLayerPaths {
+					x, y := path.gcode.Params["x"], path.gcode.Params["y"]
+					if x < 39.99 || x > 60.01 || y < 39.99 || y > 60.01 {
+						t.Fatalf("%s/%s: path to (%f, %f) leaves the tower", shape, pattern, x, y)
+					}
+				}
+				// extrusion should match the area covered at the layer's density
+				lineLength := area * towerLayer.Density / palette.TowerExtrusionWidth
+				expected := getExtrusionLength(palette.TowerExtrusionWidth, towerLayer.Thickness, lineLength)
+				if ratio := tower.CurrentLayerExtrusion / expected; ratio < 0.9 || ratio > 1.1 {
+					t.Errorf("%s/%s: layer %d has %.2f mm extrusion, expected about %.2f mm", shape, pattern, layer, tower.CurrentLayerExtrusion, expected)
+				}
+			}
+		}
+	}
+}
+
+func Test_TowerPrintSpeedVolumetricFlow(t *testing.T) {
+	palette := getTestPalette(30)
+
+	// no flow limit: use the speed caps
+	if speed := palette.GetTowerPrintSpeed(1, 0, 1, 0.4, 0.2); speed != 60*60 {
+		t.Fatalf("expected 3600 mm/min, got %f", speed)
+	}
+
+	// the slower material's flow limit applies
+	palette.MaxVolumetricFlow = []float32{10, 2}
+	crossSection := getExtrusionVolume(0.4, 0.2, 1)
+	expected := 2 / crossSection * 60
+	if speed := palette.GetTowerPrintSpeed(1, 0, 1, 0.4, 0.2); speed != expected {
+		t.Fatalf("expected %f mm/min, got %f", expected, speed)
+	}
+	// thicker layers print slower
+	if speed := palette.GetTowerPrintSpeed(1, 0, 1, 0.4, 0.3); speed >= expected {
+		t.Fatalf("expected less than %f mm/min, got %f", expected, speed)
+	}
+	// but never faster than the speed caps
+	palette.MaxVolumetricFlow = []float32{100, 100}
+	if speed := palette.GetTowerPrintSpeed(1, 0, 1, 0.4, 0.2); speed != 60*60 {
+		t.Fatalf("expected 3600 mm/min, got %f", speed)
+	}
+
+	palette.SideTransitionPurgeSpeed = 5
+	palette.MaxVolumetricFlow = []float32{2, 10}
+	expected = 2 / float32(filamentPiRSquared) * 60
+	if speed := palette.GetSideTransitionPurgeSpeed(1, 0); speed != expected {
+		t.Fatalf("expected %f mm/min, got %f", expected, speed)
+	}
+}
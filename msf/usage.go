@@ -0,0 +1,211 @@
+package msf
+
+import "mosaicmfg.com/ps-postprocess/gcode"
+
+var usageCategories = []UsageCategory{UsageModel, UsageTower, UsageSideTransitions, UsageBrimRaft, UsageFirmwarePurge, UsageUnprinted}
+
+// FilamentUsage is an amount of filament, with its weight and cost if the material's are known
+type FilamentUsage struct {
+	Length float32 `json:"length"` // mm
+	Weight float32 `json:"weight"` // g
+	Cost   float32 `json:"cost"`
+}
+
+func (u *FilamentUsage) add(length float32, material *Material) {
+	u.Length += length
+	if material == nil || material.Density <= 0 {
+		return
+	}
+	weight := filamentLengthToVolume(length) / 1000 * material.Density
+	u.Weight += weight
+	u.Cost += weight / 1000 * material.Price
+}
+
+func (u *FilamentUsage) addUsage(other FilamentUsage) {
+	u.Length += other.Length
+	u.Weight += other.Weight
+	u.Cost += other.Cost
+}
+
+// UsageBreakdown splits filament usage by what it was used for.
+// Everything other than the model itself is counted as waste.
+type UsageBreakdown struct {
+	Model           FilamentUsage `json:"model"`
+	Tower           FilamentUsage `json:"tower"`
+	SideTransitions FilamentUsage `json:"sideTransitions"`
+	BrimRaft        FilamentUsage `json:"brimRaft"`
+	FirmwarePurge   FilamentUsage `json:"firmwarePurge"`
+	Unprinted       FilamentUsage `json:"unprinted"`
+	Total           FilamentUsage `json:"total"`
+	WastePercent    float32       `json:"wastePercent"`
+}
+
+func (b *UsageBreakdown) get(category UsageCategory) *FilamentUsage {
+	switch category {
+	case UsageTower:
+		return &b.Tower
+	case UsageSideTransitions:
+		return &b.SideTransitions
+	case UsageBrimRaft:
+		return &b.BrimRaft
+	case UsageFirmwarePurge:
+		return &b.FirmwarePurge
+	case UsageUnprinted:
+		return &b.Unprinted
+	}
+	return &b.Model
+}
+
+func (b *UsageBreakdown) addBreakdown(other UsageBreakdown) {
+	for _, category := range usageCategories {
+		b.get(category).addUsage(*other.get(category))
+	}
+}
+
+func (b *UsageBreakdown) finish() {
+	b.Total = FilamentUsage{}
+	for _, category := range usageCategories {
+		b.Total.addUsage(*b.get(category))
+	}
+	b.WastePercent = 0
+	if b.Total.Length > 0 {
+		b.WastePercent = 100 * (b.Total.Length - b.Model.Length) / b.Total.Length
+	}
+}
+
+type DriveUsage struct {
+	Drive int    `json:"drive"` // 0-indexed
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	UsageBreakdown
+}
+
+type UsageReport struct {
+	Drives []DriveUsage   `json:"drives"` // only the drives used
+	Total  UsageBreakdown `json:"total"`
+}
+
+type usageSpan struct {
+	category   UsageCategory
+	start, end float32 // mm of total extrusion
+}
+
+// usageTracker records what each stretch of the print's extrusion was used for
+type usageTracker struct {
+	spans    []usageSpan
+	category UsageCategory
+	start    float32
+}
+
+func newUsageTracker() usageTracker {
+	return usageTracker{
+		spans:    make([]usageSpan, 0),
+		category: UsageFirmwarePurge,
+	}
+}
+
+// set attributes extrusion from position onwards to category
+func (u *usageTracker) set(category UsageCategory, position float32) {
+	if category == u.category {
+		return
+	}
+	u.finish(position)
+	u.category = category
+}
+
+func (u *usageTracker) finish(position float32) {
+	if position != u.start {
+		u.spans = append(u.spans, usageSpan{
+			category: u.category,
+			start:    u.start,
+			end:      position,
+		})
+	}
+	u.start = position
+}
+
+// getFeatureUsage returns what a sliced feature is counted as
+func getFeatureUsage(feature string) UsageCategory {
+	switch feature {
+	case gcode.FeatureWipeTower:
+		return UsageTower
+	case gcode.FeatureSkirt, gcode.FeatureSkirtBrim:
+		return UsageBrimRaft
+	}
+	return UsageModel
+}
+
+// getUsageReport splits the tracked usage between the drives
+// feeding each piece of the splice list
+func (msf *MSF) getUsageReport(usage usageTracker) UsageReport {
+	numInputs := len(msf.DrivesUsed)
+	byDrive := make([]UsageBreakdown, numInputs)
+	for _, span := range usage.spans {
+		pieceStart := float32(0)
+		for _, splice := range msf.SpliceList {
+			var length float32
+			if span.end < span.start {
+				// net retraction -- count it against the piece it started in
+				if span.start > pieceStart && span.start <= splice.Length {
+					length = span.end - span.start
+				}
+			} else {
+				start, end := span.start, span.end
+				if pieceStart > start {
+					start = pieceStart
+				}
+				if splice.Length < end {
+					end = splice.Length
+				}
+				if end > start {
+					length = end - start
+				}
+			}
+			if length != 0 {
+				var material *Material
+				if splice.Drive < len(msf.Palette.MaterialMeta) {
+					material = &msf.Palette.MaterialMeta[splice.Drive]
+				}
+				byDrive[splice.Drive].get(span.category).add(length, material)
+			}
+			pieceStart = splice.Length
+		}
+	}
+
+	report := UsageReport{
+		Drives: make([]DriveUsage, 0, numInputs),
+	}
+	for drive, breakdown := range byDrive {
+		breakdown.finish()
+		if breakdown.Total.Length == 0 {
+			continue
+		}
+		driveUsage := DriveUsage{
+			Drive:          drive,
+			UsageBreakdown: breakdown,
+		}
+		if drive < len(msf.Palette.MaterialMeta) {
+			driveUsage.Name = msf.Palette.MaterialMeta[drive].Name
+			driveUsage.Color = msf.Palette.MaterialMeta[drive].Color
+		}
+		report.Drives = append(report.Drives, driveUsage)
+		report.Total.addBreakdown(breakdown)
+	}
+	report.Total.finish()
+	return report
+}
+
+// trackUsage attributes the extrusion of the tower's next segment, starting at position
+func (t *Tower) trackUsage(usage *usageTracker, position float32) {
+	if t.CurrentLayerIndex < t.Palette.RaftLayers {
+		usage.set(UsageBrimRaft, position)
+		return
+	}
+	if t.CurrentLayerIndex == 0 && t.CurrentLayerTransitionIndex == 0 && t.BrimExtrusion > 0 {
+		// brims are printed first
+		usage.set(UsageBrimRaft, position)
+		usage.set(UsageTower, position+t.BrimExtrusion)
+		return
+	}
+	usage.set(UsageTower, position)
+}
@@ -0,0 +1,59 @@
+package msf
+
+import (
+	"bytes"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
+
+func Test_UsageReport(t *testing.T) {
+	palette := getTestPalette(50)
+	palette.Type = TypeManual
+	palette.TransitionMethod = None
+	palette.FirmwarePurge = 5
+	palette.MaterialMeta = []Material{
+		{Name: "Red PLA", Color: "ff0000", Density: 1.24, Price: 20},
+		{Name: "Blue PLA", Color: "0000ff"},
+	}
+	printContent := `
+;START_OF_PRINT
+T0
+;LAYER_CHANGE
+;Z:0.2
+;TYPE:Skirt/Brim
+G1 X0 Y0 Z0.2 F6000
+G1 X60 Y0 E10 F600
+;TYPE:External perimeter
+G1 X0 Y0 E20
+T1
+G1 X60 Y0 E30
+`
+	var output bytes.Buffer
+	msfOut, err := ConvertForPaletteStream(getTestLineReader(printContent), &output, &palette, sequences.NewLocals())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drives := msfOut.Usage.Drives
+	if len(drives) != 2 {
+		t.Fatalf("expected 2 drives, got %+v", drives)
+	}
+	red, blue := drives[0], drives[1]
+	if red.FirmwarePurge.Length != 5 || red.BrimRaft.Length != 10 || red.Model.Length != 10 || red.Total.Length != 25 {
+		t.Errorf("unexpected usage for drive 1: %+v", red.UsageBreakdown)
+	}
+	if blue.Model.Length != 10 || blue.Total.Length != 10 || blue.WastePercent != 0 {
+		t.Errorf("unexpected usage for drive 2: %+v", blue.UsageBreakdown)
+	}
+	// 25 mm of 1.75 mm filament is 60.13 mm^3
+	if red.Total.Weight < 0.0745 || red.Total.Weight > 0.0747 || red.Total.Cost < 0.00149 || red.Total.Cost > 0.0015 {
+		t.Errorf("unexpected weight and cost %+v", red.Total)
+	}
+	if blue.Total.Weight != 0 {
+		t.Error("expected no weight without a density")
+	}
+	if total := msfOut.Usage.Total; total.Total.Length != 35 || total.WastePercent < 42.8 || total.WastePercent > 42.9 {
+		t.Errorf("unexpected total usage %+v", total)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"mosaicmfg.com/ps-postprocess/gcode"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -67,23 +68,6 @@ func getLineLength(x1, y1, x2, y2 float32) float32 {
 	return float32(math.Sqrt(dx*dx + dy*dy))
 }
 
-func estimateMoveTime(x1, y1, x2, y2, feedrate float32) float32 {
-	lineLength := getLineLength(x1, y1, x2, y2)
-	mmPerS := feedrate / 60
-	return lineLength / mmPerS
-}
-
-func estimateZMoveTime(z1, z2, feedrate float32) float32 {
-	lineLength := float32(math.Abs(float64(z2 - z1)))
-	mmPerS := feedrate / 60
-	return lineLength / mmPerS
-}
-
-func estimatePurgeTime(eDelta, feedrate float32) float32 {
-	mmPerS := feedrate / 60
-	return float32(math.Abs(float64(eDelta))) / mmPerS
-}
-
 func lerp(minVal, maxVal, t float32) float32 {
 	boundedT := float32(math.Max(0, math.Min(1, float64(t))))
 	return ((1 - boundedT) * minVal) + (t * maxVal)
@@ -113,6 +97,15 @@ func getExtrusionLength(extrusionWidth, layerHeight, length float32) float32 {
 	return filamentVolumeToLength(volume)
 }
 
+// clampToVolumetricFlow returns the lower of speed and the speed at which
+// extruding a path of the given cross-section (mm2) reaches flow (mm3/s)
+func clampToVolumetricFlow(speed, flow, crossSection float32) float32 {
+	if flowSpeed := flow / crossSection; flowSpeed < speed {
+		return flowSpeed
+	}
+	return speed
+}
+
 // getTowerExtrusionCorrectionFactor returns the difference, as a ratio, between the
 // naive estimate of a toolpath's cross-sectional area that treats it as a rectangle
 // (width * height) and the more accurate, smaller value that treats it as a rectangle
@@ -129,7 +122,7 @@ func getTowerExtrusionCorrectionFactor(extrusionWidth, layerHeight float32) floa
 	return naiveArea / informedArea
 }
 
-func getPrintSummary(msf *MSF, timeEstimate float32) string {
+func getPrintSummary(msf *MSF, timeEstimate float32, layerTimes []float32) string {
 	totalFilament := msf.GetTotalFilamentLength()
 	filamentByDrive := msf.GetFilamentLengthsByDrive()
 
@@ -151,45 +144,27 @@ func getPrintSummary(msf *MSF, timeEstimate float32) string {
 
 	// time estimate
 	summary += fmt.Sprintf("; estimated printing time = %s%s", gcode.GetTimeString(timeEstimate), EOL)
+
+	// time estimates by layer
+	if len(layerTimes) > 0 {
+		summary += "; estimated layer times:" + EOL
+		for layer, layerTime := range layerTimes {
+			summary += fmt.Sprintf(";    Layer %d = %s%s", layer+1, gcode.GetTimeString(layerTime), EOL)
+		}
+	}
 	summary += EOL
 
 	return summary
 }
 
-func prependFile(filepath, content string) error {
-	// create a temporary file
-	tempfile, err := ioutil.TempFile(path.Dir(filepath), "")
-	if err != nil {
-		return err
-	}
-
-	// write prepended content first
-	if _, err := tempfile.WriteString(content); err != nil {
-		return err
+// createTempOutput creates a temporary file to hold output until it can be completed,
+// next to output if that is a file. The caller removes it when done.
+func createTempOutput(output io.Writer) (*os.File, error) {
+	dir := ""
+	if file, ok := output.(*os.File); ok && file != os.Stdout {
+		dir = path.Dir(file.Name())
 	}
-
-	// now append content of original file
-	reader, err := os.Open(filepath)
-	if err != nil {
-		return err
-	}
-	if _, err := io.Copy(tempfile, reader); err != nil {
-		return err
-	}
-	if err := reader.Close(); err != nil {
-		return err
-	}
-
-	// finalize and close temporary file
-	if err := tempfile.Sync(); err != nil {
-		return err
-	}
-	if err := tempfile.Close(); err != nil {
-		return err
-	}
-
-	// overwrite original file with temporary one
-	return os.Rename(tempfile.Name(), filepath)
+	return ioutil.TempFile(dir, "")
 }
 
 func getPtpStartComment(purgeLength, transitionLength, spliceOffset, target float32) string {
@@ -199,3 +174,18 @@ func getPtpStartComment(purgeLength, transitionLength, spliceOffset, target floa
 func getPtpEndComment() string {
 	return fmt.Sprintf(";PTP_END%s", EOL)
 }
+
+func hexStringToInt(value string) (uint, error) {
+	parsed, err := strconv.ParseUint(value, 16, 32)
+	return uint(parsed), err
+}
+
+func hexStringToInt16(value string) (int16, error) {
+	parsed, err := strconv.ParseUint(value, 16, 16)
+	return int16(uint16(parsed)), err
+}
+
+func hexStringToFloat(value string) (float32, error) {
+	parsed, err := strconv.ParseUint(value, 16, 32)
+	return math.Float32frombits(uint32(parsed)), err
+}
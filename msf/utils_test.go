@@ -1,6 +1,15 @@
 package msf
 
-import "testing"
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+	"mosaicmfg.com/ps-postprocess/printerscript"
+	"mosaicmfg.com/ps-postprocess/sequences"
+)
 
 func Test_intToHexString(t *testing.T) {
 	type testCase struct {
@@ -64,3 +73,79 @@ func Test_floatToHexString(t *testing.T) {
 		}
 	}
 }
+
+func Test_PrintTimeEstimate(t *testing.T) {
+	palette := getTestPalette(50)
+	palette.Type = TypeManual
+	palette.TransitionMethod = None
+	palette.Flavor = gcode.Marlin{}
+	palette.MaterialMeta = []Material{
+		{Name: "Red PLA", Color: "ff0000"},
+		{Name: "Blue PLA", Color: "0000ff"},
+	}
+	script, err := printerscript.LexAndParse(`"G4 S30"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	palette.FilamentChangeScript = script
+	printContent := `
+;START_OF_PRINT
+T0
+;LAYER_CHANGE
+;Z:0.2
+G1 X0 Y0 Z0.2 F6000
+G1 X60 Y0 E10 F600
+;LAYER_CHANGE
+;Z:0.4
+G1 Z0.4
+T1
+G1 X0 Y0 E20 F600
+; estimated printing time (normal mode) = 1m 0s
+;
+;
+`
+	var output bytes.Buffer
+	if _, err := ConvertForPaletteStream(getTestLineReader(printContent), &output, &palette, sequences.NewLocals()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the slicer's minute is split evenly between the layers, and the inserted pause
+	// is added to the second layer
+	for _, expected := range []string{
+		"; estimated printing time = 1m 30s",
+		";    Layer 1 = 30s",
+		";    Layer 2 = 1m 0s",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("expected %q in summary:\n%s", expected, output.String())
+		}
+	}
+}
+
+func Test_PingTimeEstimate(t *testing.T) {
+	palette := getTestPalette(30)
+	palette.TransitionMethod = SideTransitions
+	palette.SideTransitionPurgeSpeed = 10
+	msfOut := NewMSF(&palette)
+	ping := func() float32 {
+		state := NewState(&palette)
+		state.MSF = &msfOut
+		_, purgeLength := doSideTransitionInPlaceAccessoryPing(&state)
+		// subtract the purge between the pauses
+		return state.TimeEstimate - purgeLength/10
+	}
+
+	// dwells of 13 s and 7 s
+	if pauses := ping(); math.Abs(float64(pauses-20)) > 0.01 {
+		t.Errorf("expected dwell pauses to take 20s, got %.2fs", pauses)
+	}
+
+	// jogs of 13 mm and 7 mm, 10 times each at 10 mm/min
+	palette.JogPauses = true
+	if pauses := ping(); math.Abs(float64(pauses-1200)) > 0.1 {
+		t.Errorf("expected jog pauses to take 1200s, got %.2fs", pauses)
+	}
+	if len(msfOut.PingList) != 2 {
+		t.Errorf("expected 2 pings, got %d", len(msfOut.PingList))
+	}
+}
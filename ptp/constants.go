@@ -11,6 +11,9 @@ const (
 // segments with all dimension deltas smaller than this will be skipped
 const skipThreshold = 0.01
 
+// maximum deviation (mm) between an arc move and the segments used to draw it
+const arcChordTolerance = 0.02
+
 // tolerance used by collinearity-checking functions
 const collinearityEpsilon = 10e-5
 
@@ -2,7 +2,6 @@ package ptp
 
 import (
 	"errors"
-	"mosaicmfg.com/ps-postprocess/gcode"
 	"strconv"
 	"strings"
 )
@@ -33,6 +32,22 @@ func parseToolColors(serialized string) ([][3]float32, error) {
 	return toolColors, nil
 }
 
+func parseHomePosition(serialized string) ([3]float32, error) {
+	var position [3]float32
+	parts := strings.Split(serialized, ",")
+	if len(parts) != 3 {
+		return position, errors.New("expected 3 components for home position")
+	}
+	for axis, part := range parts {
+		value, err := strconv.ParseFloat(part, 32)
+		if err != nil {
+			return position, err
+		}
+		position[axis] = float32(value)
+	}
+	return position, nil
+}
+
 func convertPathType(hint string) PathType {
 	switch hint {
 	case "Perimeter":
@@ -70,15 +85,3 @@ func convertPathType(hint string) PathType {
 	}
 	return PathTypeUnknown
 }
-
-func IsPathTypeComment(line gcode.Command) bool {
-	return strings.HasPrefix(line.Comment, "TYPE:")
-}
-
-func IsWidthComment(line gcode.Command) bool {
-	return strings.HasPrefix(line.Comment, "WIDTH:")
-}
-
-func IsHeightComment(line gcode.Command) bool {
-	return strings.HasPrefix(line.Comment, "HEIGHT:")
-}
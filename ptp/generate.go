@@ -16,6 +16,7 @@ type generatorState struct {
 	currentLayerZ float32
 	currentE      float32
 	relativeE     bool
+	position      gcode.PositionTracker // resolves relative, offset and arc moves
 
 	// only used for transition tower gradients
 	extrusionSoFar   float32 // cumulative over the transition
@@ -28,12 +29,13 @@ type generatorState struct {
 
 }
 
-func getStartingGeneratorState() generatorState {
+func getStartingGeneratorState(homePosition [3]float32) generatorState {
 	return generatorState{
 		currentTool:      0,
 		currentLayerZ:    0,
 		currentE:         0,
 		relativeE:        false,
+		position:         gcode.PositionTracker{HomePosition: homePosition},
 		transitioning:    false,
 		extrusionSoFar:   0,
 		lastTool:         0,
@@ -107,6 +109,50 @@ func (s *generatorState) getT() float32 {
 	return interpolateTowerColor((s.extrusionSoFar-s.offset)/s.purgeLength, s.target)
 }
 
+// addArcLines outputs the linearized points of an arc move, spreading the move's
+// extrusion evenly over the segments so that transition gradients stay smooth
+func addArcLines(writer *Writer, state *generatorState, points [][3]float32, isPrintMove bool, deltaE float32) error {
+	startExtrusionSoFar := state.extrusionSoFar - deltaE
+	for i, point := range points {
+		var err error
+		if isPrintMove {
+			if state.transitioning {
+				// segments are of equal length, so extrusion is split evenly
+				state.extrusionSoFar = startExtrusionSoFar + deltaE*float32(i+1)/float32(len(points))
+				err = writer.AddXYZTransitionLineTo(point[0], point[1], point[2], state.lastTool, state.getT())
+			} else {
+				err = writer.AddXYZPrintLineTo(point[0], point[1], point[2])
+			}
+		} else {
+			err = writer.AddXYZTravelTo(point[0], point[1], point[2])
+		}
+		if err != nil {
+			return err
+		}
+		updateBoundingBox(writer)
+	}
+	return nil
+}
+
+func updateBoundingBox(writer *Writer) {
+	if writer.state.currentPathType != PathTypeTravel &&
+		writer.state.currentPathType != PathTypeSequence &&
+		writer.state.currentPathType != PathTypeUnknown {
+		x, y, z := writer.GetCurrentPosition()
+		currentExtrusionRadius := (writer.state.currentExtrusionWidth) / 2
+		// x: account for the extrusion radius
+		writer.state.boundingBox.Min.X = MinFloat32(writer.state.boundingBox.Min.X, x-currentExtrusionRadius)
+		writer.state.boundingBox.Max.X = MaxFloat32(writer.state.boundingBox.Max.X, x+currentExtrusionRadius)
+		// y: account for the extrusion radius
+		writer.state.boundingBox.Min.Y = MinFloat32(writer.state.boundingBox.Min.Y, y-currentExtrusionRadius)
+		writer.state.boundingBox.Max.Y = MaxFloat32(writer.state.boundingBox.Max.Y, y+currentExtrusionRadius)
+		// z
+		// min: calculate min from the bottom of each path
+		writer.state.boundingBox.Min.Z = MinFloat32(writer.state.boundingBox.Min.Z, z-writer.state.currentLayerHeight)
+		writer.state.boundingBox.Max.Z = MaxFloat32(writer.state.boundingBox.Max.Z, z)
+	}
+}
+
 func parseArgvFloat32(arg string) (float32, error) {
 	if val, err := strconv.ParseFloat(arg, 32); err != nil {
 		return 0, err
@@ -115,11 +161,22 @@ func parseArgvFloat32(arg string) (float32, error) {
 	}
 }
 
+// ToolpathOptions holds the print settings needed to interpret the G-code for a preview.
+type ToolpathOptions struct {
+	InitialExtrusionWidth float32
+	InitialLayerHeight    float32
+	ZOffset               float32
+	BrimIsSkirt           bool
+	ToolColors            [][3]float32
+	Flavor                gcode.Flavor // nil == gcode.DefaultFlavor
+	HomePosition          [3]float32   // machine XYZ after homing with G28
+}
+
 func generateToolpath(argv []string) error {
 	argc := len(argv)
 
-	if argc != 7 {
-		return errors.New("expected 7 command-line arguments")
+	if argc < 7 || argc > 9 {
+		return errors.New("expected 7 to 9 command-line arguments")
 	}
 	inpath := argv[0]
 	outpath := argv[1]
@@ -137,45 +194,103 @@ func generateToolpath(argv []string) error {
 	if err != nil {
 		return err
 	}
-	preflight, err := toolpathPreflight(inpath)
+	flavorName := "" // optional
+	if argc > 7 {
+		flavorName = argv[7]
+	}
+	flavor, err := gcode.FlavorByName(flavorName)
+	if err != nil {
+		return err
+	}
+	var homePosition [3]float32 // optional, e.g. "0,0,0"
+	if argc > 8 {
+		if homePosition, err = parseHomePosition(argv[8]); err != nil {
+			return err
+		}
+	}
+	readLines, err := gcode.OpenLineReader(inpath)
+	if err != nil {
+		return err
+	}
+	summary, err := GenerateToolpathStream(readLines, outpath, ToolpathOptions{
+		InitialExtrusionWidth: initialExtrusionWidth,
+		InitialLayerHeight:    initialLayerHeight,
+		ZOffset:               zOffset,
+		BrimIsSkirt:           brimIsSkirt,
+		ToolColors:            toolColors,
+		Flavor:                flavor,
+		HomePosition:          homePosition,
+	})
 	if err != nil {
 		return err
 	}
 
-	writer := NewWriter(outpath, initialExtrusionWidth, initialLayerHeight, zOffset, brimIsSkirt, toolColors)
+	// write bounding box info as a JSON to outPath.summary
+	summaryPath := inpath + ".summary"
+	return summary.Save(summaryPath)
+}
+
+// GenerateToolpathStream writes the preview of the G-code read by readLines to the
+// files at outpath (the preview is split over several files, so output remains path-based).
+// readLines is called twice (once for preflight), so it must be able to replay its input.
+func GenerateToolpathStream(readLines gcode.LineReader, outpath string, opts ToolpathOptions) (Summary, error) {
+	preflight, err := toolpathPreflight(readLines, opts.HomePosition)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	writer := NewWriter(outpath, opts.InitialExtrusionWidth, opts.InitialLayerHeight, opts.ZOffset, opts.BrimIsSkirt, opts.ToolColors)
 	writer.SetFeedrateBounds(preflight.minFeedrate, preflight.maxFeedrate)
 	writer.SetTemperatureBounds(preflight.minTemperature, preflight.maxTemperature)
 	writer.SetLayerHeightBounds(preflight.minLayerHeight, preflight.maxLayerHeight)
 	if err = writer.Initialize(); err != nil {
-		return err
+		return Summary{}, err
 	}
 
-	state := getStartingGeneratorState()
-	err = gcode.ReadByLine(inpath, func(line gcode.Command, _ int) error {
+	flavor := gcode.FlavorOrDefault(opts.Flavor)
+	state := getStartingGeneratorState(opts.HomePosition)
+	layer := 0                    // index of the next layer change
+	layerZAnnotated := false      // the slicer gave the upcoming layer's Z
+	layerHeightAnnotated := false // the slicer gave the upcoming layer's height
+	dialect := gcode.Dialect{}
+	err = readLines(func(line gcode.Command, _ int) error {
+		state.position.TrackInstruction(line)
+		annotation := dialect.ParseAnnotation(line)
 		if setExtrusionMode, relative := line.IsSetExtrusionMode(); setExtrusionMode {
 			state.relativeE = relative
 			state.currentE = 0
+		} else if setPositioningMode, relative := line.IsSetPositioningMode(); setPositioningMode {
+			// G90/G91 apply to the E axis too
+			state.relativeE = relative
 		} else if line.IsSetPosition() {
-			if e, ok := line.Params["e"]; ok {
+			if e, ok := line.Param("e"); ok {
 				state.currentE = e
 			}
-		} else if line.IsLinearMove() {
+		} else if line.IsLinearMove() || line.IsArcMove() {
 			isVisibleMove := false // either print line or travel line
 			isPrintMove := false   // specifically print line
-			x, y, z := writer.GetCurrentPosition()
-			if lineX, ok := line.Params["x"]; ok {
-				x = lineX
-				isVisibleMove = true
-			}
-			if lineY, ok := line.Params["y"]; ok {
-				y = lineY
+			// draw in machine coordinates, so G92 offsets don't shift the preview
+			target := state.position.MachinePosition()
+			x, y, z := target[0], target[1], target[2]
+			var arcPoints [][3]float32 // linearized path of arc moves
+			if arc := state.position.LastArc; arc != nil && arc.Sweep != 0 {
+				arcPoints = arc.Linearize(arcChordTolerance)
+				for i := range arcPoints {
+					for axis := range arcPoints[i] {
+						arcPoints[i][axis] += state.position.Offset[axis]
+					}
+				}
+				// full circles need not include any X/Y/Z parameters
 				isVisibleMove = true
 			}
-			if lineZ, ok := line.Params["z"]; ok {
-				z = lineZ
+			deltaE := float32(0)
+			_, hasX := line.Param("x")
+			_, hasY := line.Param("y")
+			_, hasZ := line.Param("z")
+			if hasX || hasY || hasZ {
 				isVisibleMove = true
 			}
-			if e, ok := line.Params["e"]; ok {
+			if e, ok := line.Param("e"); ok {
 				eIncreased := e > state.currentE
 				eDecreased := e < state.currentE
 				if state.relativeE {
@@ -183,7 +298,7 @@ func generateToolpath(argv []string) error {
 					eDecreased = e < 0
 				}
 				if state.transitioning {
-					deltaE := e - state.currentE
+					deltaE = e - state.currentE
 					if state.relativeE {
 						deltaE = e
 					}
@@ -208,12 +323,16 @@ func generateToolpath(argv []string) error {
 				}
 				state.currentE = e
 			}
-			if f, ok := line.Params["f"]; ok {
+			if f, ok := line.Param("f"); ok {
 				if err = writer.SetFeedrate(f); err != nil {
 					return err
 				}
 			}
-			if isVisibleMove {
+			if isVisibleMove && len(arcPoints) > 0 {
+				if err = addArcLines(&writer, &state, arcPoints, isPrintMove, deltaE); err != nil {
+					return err
+				}
+			} else if isVisibleMove {
 				if isPrintMove {
 					if state.transitioning {
 						t := state.getT()
@@ -233,8 +352,8 @@ func generateToolpath(argv []string) error {
 			}
 		} else if line.Command == "M106" {
 			// ignore P10, which is specifically assigned to the cooling module
-			if fanIndex, ok := line.Params["p"]; !ok || fanIndex != 10 {
-				if pwm, ok := line.Params["s"]; ok {
+			if fanIndex, ok := line.Param("p"); !ok || fanIndex != 10 {
+				if pwm, ok := line.Param("s"); ok {
 					if err = writer.SetFanSpeed(int(pwm)); err != nil {
 						return err
 					}
@@ -242,45 +361,39 @@ func generateToolpath(argv []string) error {
 			}
 		} else if line.Command == "M107" {
 			// ignore P10, which is specifically assigned to the cooling module
-			if fanIndex, ok := line.Params["p"]; !ok || fanIndex != 10 {
+			if fanIndex, ok := line.Param("p"); !ok || fanIndex != 10 {
 				if err = writer.SetFanSpeed(0); err != nil {
 					return err
 				}
 			}
 		} else if line.Command == "M104" {
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				if err = writer.SetTemperature(temp); err != nil {
 					return err
 				}
 			}
 		} else if line.Command == "M109" {
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				if err = writer.SetTemperature(temp); err != nil {
 					return err
 				}
-			} else if temp, ok = line.Params["r"]; ok {
+			} else if temp, ok = line.Param("r"); ok {
 				if err = writer.SetTemperature(temp); err != nil {
 					return err
 				}
 			}
-		} else if isToolChange, tool := line.IsToolChange(); isToolChange {
+		} else if isToolChange, tool := flavor.IsToolChange(line); isToolChange {
 			if err = writer.SetTool(tool); err != nil {
 				return err
 			}
-		} else if line.Command == "M135" {
-			if t, ok := line.Params["t"]; ok {
-				if err = writer.SetTool(int(t)); err != nil {
-					return err
-				}
-			}
 		} else if line.Command == "O31" {
 			if err = writer.AddPing(); err != nil {
 				return err
 			}
 		} else if line.Comment != "" {
-			if line.Comment == "WIPE_START" {
+			if annotation.Kind == gcode.AnnotationWipeStart {
 				writer.state.inWipe = true
-			} else if line.Comment == "WIPE_END" {
+			} else if annotation.Kind == gcode.AnnotationWipeEnd {
 				// retract points were not added during the wipe sequence
 				if writer.state.inWipe {
 					// add retract point regardless of there being X/Y/Z movement as well
@@ -289,35 +402,26 @@ func generateToolpath(argv []string) error {
 					}
 				}
 				writer.state.inWipe = false
-			} else if strings.HasPrefix(line.Comment, "Z:") {
-				z, err := strconv.ParseFloat(line.Comment[2:], 32)
-				if err != nil {
-					return err
-				}
-				state.currentLayerZ = float32(z)
+			} else if annotation.Kind == gcode.AnnotationLayerZ ||
+				(annotation.Kind == gcode.AnnotationLayerChange && annotation.Value > 0) {
+				state.currentLayerZ = annotation.Value
+				layerZAnnotated = true
 				// TODO: do we need to explicitly also trigger a layer change at end sequence?
-			} else if IsPathTypeComment(line) {
+			} else if annotation.Kind == gcode.AnnotationFeature {
 				// path type hints
-				pathType := convertPathType(line.Comment[5:])
+				pathType := convertPathType(annotation.Feature)
 				if err = writer.SetPathType(pathType); err != nil {
 					return err
 				}
-			} else if IsWidthComment(line) {
+			} else if annotation.Kind == gcode.AnnotationWidth {
 				// extrusion width hints
-				width, err := strconv.ParseFloat(line.Comment[6:], 32)
-				if err != nil {
-					return err
-				}
-				if err = writer.SetExtrusionWidth(float32(width)); err != nil {
+				if err = writer.SetExtrusionWidth(annotation.Value); err != nil {
 					return err
 				}
-			} else if IsHeightComment(line) {
+			} else if annotation.Kind == gcode.AnnotationLayerHeight {
 				// layer height hints
-				height, err := strconv.ParseFloat(line.Comment[7:], 32)
-				if err != nil {
-					return err
-				}
-				if err = writer.SetLayerHeight(roundZ(float32(height))); err != nil {
+				layerHeightAnnotated = true
+				if err = writer.SetLayerHeight(roundZ(annotation.Value)); err != nil {
 					return err
 				}
 			} else if strings.HasPrefix(line.Comment, "PTP_TYPE:") {
@@ -339,48 +443,39 @@ func generateToolpath(argv []string) error {
 					return err
 				}
 			} else if line.Raw == ";END OF LAYER CHANGE SEQUENCE" {
+				if !layerZAnnotated && layer < len(preflight.layerTopZs) && preflight.layerTopZs[layer] > 0 {
+					// fall back to the height of the layer's extrusions (e.g. Cura)
+					lastZ := state.currentLayerZ
+					state.currentLayerZ = preflight.layerTopZs[layer]
+					if !layerHeightAnnotated && state.currentLayerZ > lastZ {
+						if err = writer.SetLayerHeight(roundZ(state.currentLayerZ - lastZ)); err != nil {
+							return err
+						}
+					}
+				}
 				if err = writer.LayerChange(state.currentLayerZ); err != nil {
 					return err
 				}
+				layer++
+				layerZAnnotated = false
+				layerHeightAnnotated = false
 			}
 		}
 
 		// calculate bounding box
-		if writer.state.currentPathType != PathTypeTravel &&
-			writer.state.currentPathType != PathTypeSequence &&
-			writer.state.currentPathType != PathTypeUnknown {
-			x, y, z := writer.GetCurrentPosition()
-			currentExtrusionRadius := (writer.state.currentExtrusionWidth) / 2
-			// x: account for the extrusion radius
-			writer.state.boundingBox.Min.X = MinFloat32(writer.state.boundingBox.Min.X, x-currentExtrusionRadius)
-			writer.state.boundingBox.Max.X = MaxFloat32(writer.state.boundingBox.Max.X, x+currentExtrusionRadius)
-			// y: account for the extrusion radius
-			writer.state.boundingBox.Min.Y = MinFloat32(writer.state.boundingBox.Min.Y, y-currentExtrusionRadius)
-			writer.state.boundingBox.Max.Y = MaxFloat32(writer.state.boundingBox.Max.Y, y+currentExtrusionRadius)
-			// z
-			// min: calculate min from the bottom of each path
-			writer.state.boundingBox.Min.Z = MinFloat32(writer.state.boundingBox.Min.Z, z-writer.state.currentLayerHeight)
-			writer.state.boundingBox.Max.Z = MaxFloat32(writer.state.boundingBox.Max.Z, z)
-		}
+		updateBoundingBox(&writer)
 
 		return nil
 	})
 
 	if err != nil {
-		return err
+		return Summary{}, err
 	}
 
-	// write bounding box info as a JSON to outPath.summary
-	summaryPath := inpath + ".summary"
 	summary := Summary{
 		BoundingBox: writer.state.boundingBox,
 	}
-
-	if err = summary.Save(summaryPath); err != nil {
-		return err
-	}
-
-	return writer.Finalize()
+	return summary, writer.Finalize()
 }
 
 func GenerateToolpath(argv []string) {
@@ -3,8 +3,6 @@ package ptp
 import (
 	"math"
 	"mosaicmfg.com/ps-postprocess/gcode"
-	"strconv"
-	"strings"
 )
 
 type ptpPreflight struct {
@@ -14,9 +12,10 @@ type ptpPreflight struct {
 	maxTemperature float32
 	minLayerHeight float32
 	maxLayerHeight float32
+	layerTopZs     []float32 // highest extrusion of each layer, for slicers that don't annotate layer Z
 }
 
-func toolpathPreflight(inpath string) (ptpPreflight, error) {
+func toolpathPreflight(readLines gcode.LineReader, homePosition [3]float32) (ptpPreflight, error) {
 	minFeedrate := float32(math.Inf(1))
 	maxFeedrate := float32(math.Inf(-1))
 	minTemperature := float32(math.Inf(1))
@@ -24,19 +23,31 @@ func toolpathPreflight(inpath string) (ptpPreflight, error) {
 	minLayerHeight := float32(math.Inf(1))
 	maxLayerHeight := float32(math.Inf(-1))
 	currentFeedrate := float32(0)
+	layerTopZs := make([]float32, 0)
+	position := gcode.PositionTracker{HomePosition: homePosition}
+	dialect := gcode.Dialect{}
 
-	err := gcode.ReadByLine(inpath, func(line gcode.Command, _ int) error {
-		if line.IsLinearMove() {
+	err := readLines(func(line gcode.Command, _ int) error {
+		position.TrackInstruction(line)
+		annotation := dialect.ParseAnnotation(line)
+		if line.Raw == ";END OF LAYER CHANGE SEQUENCE" {
+			layerTopZs = append(layerTopZs, 0)
+		}
+		if layer := len(layerTopZs) - 1; layer >= 0 && (line.IsLinearMove() || line.IsArcMove()) &&
+			line.HasParam("e") && position.MachinePosition()[2] > layerTopZs[layer] {
+			layerTopZs[layer] = position.MachinePosition()[2]
+		}
+		if line.IsLinearMove() || line.IsArcMove() {
 			// feedrates
-			if f, ok := line.Params["f"]; ok {
+			if f, ok := line.Param("f"); ok {
 				currentFeedrate = f
 			}
-			if _, ok := line.Params["e"]; ok {
-				hasMovement := false
-				if _, ok := line.Params["x"]; ok {
+			if _, ok := line.Param("e"); ok {
+				hasMovement := line.IsArcMove()
+				if _, ok := line.Param("x"); ok {
 					hasMovement = true
 				}
-				if _, ok := line.Params["y"]; ok {
+				if _, ok := line.Param("y"); ok {
 					hasMovement = true
 				}
 				if hasMovement {
@@ -50,7 +61,7 @@ func toolpathPreflight(inpath string) (ptpPreflight, error) {
 			}
 		} else if line.Command == "M104" {
 			// temperatures
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				if temp < minTemperature {
 					minTemperature = temp
 				}
@@ -60,14 +71,14 @@ func toolpathPreflight(inpath string) (ptpPreflight, error) {
 			}
 		} else if line.Command == "M109" {
 			// temperatures
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				if temp < minTemperature {
 					minTemperature = temp
 				}
 				if temp > maxTemperature {
 					maxTemperature = temp
 				}
-			} else if temp, ok = line.Params["r"]; ok {
+			} else if temp, ok = line.Param("r"); ok {
 				if temp < minTemperature {
 					minTemperature = temp
 				}
@@ -75,13 +86,10 @@ func toolpathPreflight(inpath string) (ptpPreflight, error) {
 					maxTemperature = temp
 				}
 			}
-		} else if line.Comment != "" && strings.HasPrefix(line.Comment, "HEIGHT:") {
+		} else if annotation.Kind == gcode.AnnotationLayerHeight {
 			// layer heights
-			height, err := strconv.ParseFloat(line.Comment[7:], 64)
-			if err != nil {
-				return err
-			}
-			height32 := roundZ(float32(height))
+			height := annotation.Value
+			height32 := roundZ(height)
 			if height32 < minLayerHeight {
 				minLayerHeight = height32
 			}
@@ -94,6 +102,23 @@ func toolpathPreflight(inpath string) (ptpPreflight, error) {
 	if err != nil {
 		return ptpPreflight{}, err
 	}
+	if math.IsInf(float64(minLayerHeight), 1) {
+		// no layer height annotations -- use the differences between layer Zs instead
+		lastZ := float32(0)
+		for _, z := range layerTopZs {
+			if z <= lastZ {
+				continue
+			}
+			height := roundZ(z - lastZ)
+			if height < minLayerHeight {
+				minLayerHeight = height
+			}
+			if height > maxLayerHeight {
+				maxLayerHeight = height
+			}
+			lastZ = z
+		}
+	}
 	results := ptpPreflight{
 		minFeedrate:    minFeedrate,
 		maxFeedrate:    maxFeedrate,
@@ -101,6 +126,7 @@ func toolpathPreflight(inpath string) (ptpPreflight, error) {
 		maxTemperature: maxTemperature,
 		minLayerHeight: minLayerHeight,
 		maxLayerHeight: maxLayerHeight,
+		layerTopZs:     layerTopZs,
 	}
 	return results, err
 }
@@ -3,8 +3,8 @@ package sequences
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,16 +28,36 @@ func handleCoolingModuleGCodeInsert(materialCoolingModulePercentage int) string
 }
 
 func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error {
-	outfile, createErr := os.Create(outpath)
+	readLines, err := gcode.OpenLineReader(inpath)
+	if err != nil {
+		return err
+	}
+	outfile, createErr := gcode.CreateOutput(outpath)
 	if createErr != nil {
 		return createErr
 	}
-	writer := bufio.NewWriter(outfile)
+	preheat, err := ConvertSequencesStream(readLines, outfile, scripts, locals)
+	if err != nil {
+		return err
+	}
+	if err := outfile.Close(); err != nil {
+		return err
+	}
+	return preheat.Save(outpath + ".preheat")
+}
+
+// ConvertSequencesStream writes the G-code read by readLines to output, with
+// sequence placeholders replaced by the evaluated scripts. readLines is called
+// twice (once for preflight), so it must be able to replay its input.
+func ConvertSequencesStream(readLines gcode.LineReader, output io.Writer, scripts ParsedScripts, locals Locals) (PreheatHints, error) {
+	writer := bufio.NewWriter(output)
+
+	flavor := gcode.FlavorOrDefault(scripts.Flavor)
 
 	// run through the file once for summary information
-	preflightResults, err := preflight(inpath)
+	preflightResults, err := preflight(readLines, flavor, scripts.HomePosition)
 	if err != nil {
-		return err
+		return PreheatHints{}, err
 	}
 	locals.Global["totalLayers"] = float64(preflightResults.totalLayers)
 	locals.Global["totalTime"] = float64(preflightResults.totalTime)
@@ -45,8 +65,8 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 	// keep track of current state
 	inStartSequence := false
 	replacedSlicedByLine := false
-	positionTracker := gcode.PositionTracker{}
-	temperatureTracker := gcode.TemperatureTracker{}
+	positionTracker := gcode.PositionTracker{HomePosition: scripts.HomePosition}
+	temperatureTracker := gcode.TemperatureTracker{Flavor: flavor}
 	// refer to locals for the first used tool in the print during
 	// the start sequence, rather than always tool 0
 	currentTool := preflightResults.firstToolIndex
@@ -55,14 +75,16 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 	nextMaterialChangeIdx := 0
 	currentCoolingModuleDutyPercent := 0
 	moveToFirstLayerPointSeen := false
+	dialect := gcode.Dialect{}
 
 	// todo: any way to cheaply calculate timeElapsed?
 
-	err = gcode.ReadByLine(inpath, func(line gcode.Command, _ int) error {
+	err = readLines(func(line gcode.Command, _ int) error {
 		// update current position and/or temperature
 		positionTracker.TrackInstruction(line)
 		temperatureTracker.TrackInstruction(line)
-		if isToolChange, tool := line.IsToolChange(); isToolChange {
+		annotation := dialect.ParseAnnotation(line)
+		if isToolChange, tool := flavor.IsToolChange(line); isToolChange {
 			currentTool = tool
 		}
 
@@ -95,7 +117,7 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 				if err != nil {
 					return err
 				}
-				output = filterToolchangeCommands(result.Output)
+				output = filterToolchangeCommands(result.Output, flavor)
 			}
 		} else if line.Raw == endPlaceholder && scripts.End != nil {
 			opts := printerscript.InterpreterOptions{
@@ -115,13 +137,13 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 			if err != nil {
 				return err
 			}
-			output = filterToolchangeCommands(result.Output)
+			output = filterToolchangeCommands(result.Output, flavor)
 		} else if strings.HasPrefix(line.Raw, layerChangePrefix) ||
-			(!moveToFirstLayerPointSeen && line.IsMoveToFirstLayerPoint()) {
+			(!moveToFirstLayerPointSeen && annotation.Kind == gcode.AnnotationMoveToFirstLayerPoint) {
 			var layer int
 			var layerZ float64
 			var err error
-			if !moveToFirstLayerPointSeen && line.IsMoveToFirstLayerPoint() {
+			if !moveToFirstLayerPointSeen && annotation.Kind == gcode.AnnotationMoveToFirstLayerPoint {
 				layer = 0
 				layerZ = preflightResults.firstLayerZ
 			} else {
@@ -152,7 +174,7 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 				if err != nil {
 					return err
 				}
-				output = filterToolchangeCommands(result.Output)
+				output = filterToolchangeCommands(result.Output, flavor)
 			}
 			if scripts.Extension == "daf" {
 				materialCoolingModulePercentage := scripts.CoolingModuleSpeedPercentage[currentTool]
@@ -169,7 +191,7 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 				}
 			}
 			output += EOL + endOfLayerChange
-			if !moveToFirstLayerPointSeen && line.IsMoveToFirstLayerPoint() {
+			if !moveToFirstLayerPointSeen && annotation.Kind == gcode.AnnotationMoveToFirstLayerPoint {
 				moveToFirstLayerPointSeen = true
 				output += EOL + line.Raw
 			}
@@ -203,7 +225,7 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 				if err != nil {
 					return err
 				}
-				output = filterToolchangeCommands(result.Output)
+				output = filterToolchangeCommands(result.Output, flavor)
 			}
 
 			if scripts.Extension == "daf" {
@@ -231,21 +253,14 @@ func convert(inpath, outpath string, scripts ParsedScripts, locals Locals) error
 		return nil
 	})
 	if err != nil {
-		return err
+		return PreheatHints{}, err
 	}
 
 	if err := writer.Flush(); err != nil {
-		return err
-	}
-	if err := outfile.Close(); err != nil {
-		return err
-	}
-
-	if err := preflightResults.preheat.Save(outpath + ".preheat"); err != nil {
-		return err
+		return PreheatHints{}, err
 	}
 
-	return nil
+	return preflightResults.preheat, nil
 }
 
 func ConvertSequences(argv []string) {
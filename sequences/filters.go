@@ -5,12 +5,12 @@ import (
 	"strings"
 )
 
-func filterToolchangeCommands(evaluatedScript string) string {
+func filterToolchangeCommands(evaluatedScript string, flavor gcode.Flavor) string {
 	lines := gcode.ParseLines(evaluatedScript)
 	filteredLines := make([]string, 0)
 
 	for _, line := range lines {
-		if isToolChange, _ := line.IsToolChange(); !isToolChange {
+		if isToolChange, _ := flavor.IsToolChange(line); !isToolChange {
 			filteredLines = append(filteredLines, line.Raw)
 		}
 	}
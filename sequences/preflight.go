@@ -32,13 +32,14 @@ type sequencesPreflight struct {
 	firstLayerZ           float64
 }
 
-func preflight(inpath string) (sequencesPreflight, error) {
+func preflight(readLines gcode.LineReader, flavor gcode.Flavor, homePosition [3]float32) (sequencesPreflight, error) {
 	results := sequencesPreflight{
 		firstToolIndex:        -1,
 		layerChangeNextPos:    make([]lookahead, 0),
 		materialChangeNextPos: make([]lookahead, 0),
 	}
-	position := gcode.PositionTracker{}
+	position := gcode.PositionTracker{HomePosition: homePosition}
+	temperature := gcode.TemperatureTracker{Flavor: flavor}
 
 	currentLookaheads := make([]lookahead, 0)
 	moveToFirstLayerPointSeen := false
@@ -69,76 +70,63 @@ func preflight(inpath string) (sequencesPreflight, error) {
 		})
 	}
 
-	err := gcode.ReadByLine(inpath, func(line gcode.Command, lineNum int) error {
+	dialect := gcode.Dialect{}
+
+	err := readLines(func(line gcode.Command, lineNum int) error {
 		position.TrackInstruction(line)
+		temperature.TrackInstruction(line)
+		annotation := dialect.ParseAnnotation(line)
 
-		if line.Command == "M104" {
-			// - first extruder temperature in the print
+		if line.Command == "M104" || line.Command == "M109" || line.Command == "M568" ||
+			line.Command == "SET_HEATER_TEMPERATURE" {
+			// - first temperature of each extruder in the print
 			// - max extruder temperature in the print
-			if temp, ok := line.Params["s"]; ok {
-				if results.preheat.Extruder == 0 {
-					results.preheat.Extruder = temp
-				}
-				if temp > results.preheat.ExtruderMax {
-					results.preheat.ExtruderMax = temp
-				}
+			if tool, ok := temperature.SetPointTool(line); ok {
+				results.preheat.addExtruderTemperature(tool, temperature.Tool(tool).Active)
 			}
-		} else if line.Command == "M109" {
-			// - first extruder temperature in the print
-			// - max extruder temperature in the print
-			if temp, ok := line.Params["s"]; ok {
-				if results.preheat.Extruder == 0 {
-					results.preheat.Extruder = temp
-				}
-				if temp > results.preheat.ExtruderMax {
-					results.preheat.ExtruderMax = temp
-				}
-			} else if temp, ok = line.Params["r"]; ok {
-				if results.preheat.Extruder == 0 {
-					results.preheat.Extruder = temp
-				}
-				if temp > results.preheat.ExtruderMax {
-					results.preheat.ExtruderMax = temp
-				}
+			// - Klipper's SET_HEATER_TEMPERATURE may also set the bed or chamber
+			if results.preheat.Bed == 0 {
+				results.preheat.Bed = temperature.Bed
+			}
+			if results.preheat.Chamber == 0 {
+				results.preheat.Chamber = temperature.Chamber
 			}
 			return nil
 		} else if results.preheat.Bed == 0 && line.Command == "M140" {
 			// - first print bed temperature in the print
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				results.preheat.Bed = temp
 			}
 			return nil
 		} else if results.preheat.Bed == 0 && line.Command == "M190" {
 			// - first print bed temperature in the print
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				results.preheat.Bed = temp
-			} else if temp, ok = line.Params["r"]; ok {
+			} else if temp, ok = line.Param("r"); ok {
 				results.preheat.Bed = temp
 			}
 			return nil
 		} else if results.preheat.Chamber == 0 && line.Command == "M141" {
 			// - first chamber temperature in the print
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				results.preheat.Chamber = temp
 			}
 			return nil
 		} else if results.preheat.Chamber == 0 && line.Command == "M191" {
 			// - first chamber temperature in the print
-			if temp, ok := line.Params["s"]; ok {
+			if temp, ok := line.Param("s"); ok {
 				results.preheat.Chamber = temp
-			} else if temp, ok = line.Params["r"]; ok {
+			} else if temp, ok = line.Param("r"); ok {
 				results.preheat.Chamber = temp
 			}
 			return nil
-		} else if isToolChange, tool := line.IsToolChange(); isToolChange && results.firstToolIndex < 0 {
+		} else if isToolChange, tool := flavor.IsToolChange(line); isToolChange && results.firstToolIndex < 0 {
 			results.firstToolIndex = tool
-		} else if line.IsMoveToFirstLayerPoint() &&
-			line.IsLinearMove() &&
-			!moveToFirstLayerPointSeen {
-			if z, ok := line.Params["z"]; ok {
-				results.firstLayerZ = float64(z)
+		} else if annotation.Kind == gcode.AnnotationMoveToFirstLayerPoint && !moveToFirstLayerPointSeen {
+			if _, ok := line.Param("z"); ok {
+				results.firstLayerZ = float64(position.CurrentZ)
 				moveToFirstLayerPointSeen = true
-				// consider first seen "line.IsMoveToFirstLayerPoint()" as a layer change because
+				// consider the first move to the first layer point as a layer change because
 				// we will insert a layer command before it
 				addLookahead(lookaheadLayerChange)
 			}
@@ -148,20 +136,20 @@ func preflight(inpath string) (sequencesPreflight, error) {
 			// logic: keep applying Z changes, and commit when we see X and/or Y change
 			if line.IsLinearMove() {
 				needsCommit := false
-				if z, ok := line.Params["z"]; ok {
+				if _, ok := line.Param("z"); ok {
 					for i := 0; i < len(currentLookaheads); i++ {
-						currentLookaheads[i].nextZ = float64(z)
+						currentLookaheads[i].nextZ = float64(position.CurrentZ)
 					}
 				}
-				if x, ok := line.Params["x"]; ok {
+				if _, ok := line.Param("x"); ok {
 					for i := 0; i < len(currentLookaheads); i++ {
-						currentLookaheads[i].nextX = float64(x)
+						currentLookaheads[i].nextX = float64(position.CurrentX)
 					}
 					needsCommit = true
 				}
-				if y, ok := line.Params["y"]; ok {
+				if _, ok := line.Param("y"); ok {
 					for i := 0; i < len(currentLookaheads); i++ {
-						currentLookaheads[i].nextY = float64(y)
+						currentLookaheads[i].nextY = float64(position.CurrentY)
 					}
 					needsCommit = true
 				}
@@ -176,14 +164,10 @@ func preflight(inpath string) (sequencesPreflight, error) {
 			return nil
 		}
 
-		if line.Comment == "LAYER_CHANGE" {
+		if annotation.Kind == gcode.AnnotationLayerChange {
 			results.totalLayers++
-		} else if strings.HasPrefix(line.Comment, "estimated printing time (normal mode) = ") {
-			timeEstimate, err := gcode.ParseTimeString(line.Comment)
-			if err != nil {
-				return err
-			}
-			results.totalTime = int(timeEstimate)
+		} else if annotation.Kind == gcode.AnnotationTimeEstimate {
+			results.totalTime = int(annotation.Value)
 		} else if line.Raw == endOfStartPlaceholder {
 			addLookahead(lookaheadStart)
 		} else if strings.HasPrefix(line.Raw, layerChangePrefix) {
@@ -199,6 +183,12 @@ func preflight(inpath string) (sequencesPreflight, error) {
 		// single-material print
 		results.firstToolIndex = 0
 	}
+	if results.firstToolIndex < len(results.preheat.Extruders) {
+		// prefer the set-point of the first tool used over that of the first tool heated
+		if temp := results.preheat.Extruders[results.firstToolIndex]; temp > 0 {
+			results.preheat.Extruder = temp
+		}
+	}
 
 	return results, err
 }
@@ -6,10 +6,11 @@ import (
 )
 
 type PreheatHints struct {
-	Extruder    float32 `json:"extruder"`    // first extruder temperature used in the print
-	ExtruderMax float32 `json:"extruderMax"` // highest extruder temperature used in the print
-	Bed         float32 `json:"bed"`         // first bed temperature used in the print
-	Chamber     float32 `json:"chamber"`     // first chamber temperature used in the print
+	Extruder    float32   `json:"extruder"`    // first extruder temperature used in the print
+	ExtruderMax float32   `json:"extruderMax"` // highest extruder temperature used in the print
+	Extruders   []float32 `json:"extruders"`   // first temperature used by each extruder in the print (0 == unused)
+	Bed         float32   `json:"bed"`         // first bed temperature used in the print
+	Chamber     float32   `json:"chamber"`     // first chamber temperature used in the print
 }
 
 func (p *PreheatHints) Save(path string) error {
@@ -19,3 +20,21 @@ func (p *PreheatHints) Save(path string) error {
 	}
 	return ioutil.WriteFile(path, asJson, 0644)
 }
+
+func (p *PreheatHints) addExtruderTemperature(tool int, temp float32) {
+	if temp <= 0 {
+		return
+	}
+	if p.Extruder == 0 {
+		p.Extruder = temp
+	}
+	if temp > p.ExtruderMax {
+		p.ExtruderMax = temp
+	}
+	for len(p.Extruders) <= tool {
+		p.Extruders = append(p.Extruders, 0)
+	}
+	if p.Extruders[tool] == 0 {
+		p.Extruders[tool] = temp
+	}
+}
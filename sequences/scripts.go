@@ -3,18 +3,21 @@ package sequences
 import (
 	"encoding/json"
 	"io/ioutil"
+	"mosaicmfg.com/ps-postprocess/gcode"
 	"mosaicmfg.com/ps-postprocess/printerscript"
 	"strings"
 )
 
 type Scripts struct {
-	Start                        string   `json:"start"`
-	End                          string   `json:"end"`
-	LayerChange                  string   `json:"layerChange"`
-	MaterialChange               []string `json:"materialChange"`
-	CoolingModuleSpeedPercentage []int    `json:"coolingModuleSpeedPercentage"`
-	EnableCoolingModuleAtLayer   []int    `json:"enableCoolingModuleAtLayer"`
-	Extension                    string   `json:"extension"`
+	Start                        string     `json:"start"`
+	End                          string     `json:"end"`
+	LayerChange                  string     `json:"layerChange"`
+	MaterialChange               []string   `json:"materialChange"`
+	CoolingModuleSpeedPercentage []int      `json:"coolingModuleSpeedPercentage"`
+	EnableCoolingModuleAtLayer   []int      `json:"enableCoolingModuleAtLayer"`
+	Extension                    string     `json:"extension"`
+	FirmwareFlavor               string     `json:"firmwareFlavor"` // e.g. marlin, reprap, klipper (empty == generic)
+	HomePosition                 [3]float32 `json:"homePosition"`   // mm, machine XYZ after homing with G28
 }
 
 type ParsedScripts struct {
@@ -25,6 +28,8 @@ type ParsedScripts struct {
 	CoolingModuleSpeedPercentage []int
 	EnableCoolingModuleAtLayer   []int
 	Extension                    string
+	Flavor                       gcode.Flavor
+	HomePosition                 [3]float32
 }
 
 func LoadScripts(jsonPath string) (Scripts, error) {
@@ -48,7 +53,13 @@ func (s *Scripts) Parse() (ParsedScripts, error) {
 		CoolingModuleSpeedPercentage: s.CoolingModuleSpeedPercentage,
 		EnableCoolingModuleAtLayer:   s.EnableCoolingModuleAtLayer,
 		Extension:                    s.Extension,
+		HomePosition:                 s.HomePosition,
 	}
+	flavor, err := gcode.FlavorByName(s.FirmwareFlavor)
+	if err != nil {
+		return parsed, err
+	}
+	parsed.Flavor = flavor
 	s.Start = printerscript.Normalize(s.Start)
 	if len(strings.TrimSpace(s.Start)) > 0 {
 		tree, err := printerscript.LexAndParse(s.Start)
@@ -0,0 +1,136 @@
+package simulator
+
+import (
+	"math"
+
+	"mosaicmfg.com/ps-postprocess/msf"
+)
+
+// device models Palette producing filament for the printer. Positions are
+// lengths of real filament: produced is measured at Palette's output, and
+// consumed at the printer's extruder, with lead filling the path between them.
+type device struct {
+	options Options
+	splices []msf.Splice
+	lead    float64 // mm of filament between Palette's cutter and the extruder
+
+	// (float64, as these sum many short moves over a whole print)
+	produced float64 // mm
+	consumed float64 // mm
+
+	// ping calibration, mapping G-code extrusion to real filament
+	ratio            float64 // estimated mm of filament per mm of G-code extrusion
+	lastPingLength   float64 // mm of G-code extrusion at the last ping
+	lastPingFilament float64 // mm of filament consumed at the last ping
+
+	nextSplice      int
+	spliceTimeLeft  float64   // s, while cutting and splicing
+	spliceFilaments []float64 // mm, of filament at which each splice was made
+}
+
+func newDevice(msfData *msf.MSF, palette *msf.Palette, options Options) *device {
+	lead := palette.GetEffectiveLoadingOffset()
+	if lead == 0 {
+		lead = palette.BowdenTubeLength
+	}
+	if lead == 0 {
+		lead = msf.BowdenDefault
+	}
+	splices := msfData.SpliceList
+	if len(splices) > 0 {
+		// the last "splice" is the end of the filament
+		splices = splices[:len(splices)-1]
+	}
+	d := &device{
+		options:         options,
+		splices:         splices,
+		lead:            float64(lead),
+		ratio:           1,
+		spliceFilaments: make([]float64, 0, len(splices)),
+	}
+	// the printer waits for Palette to load filament and fill its buffer before starting
+	d.produced = d.lead
+	d.run(math.Inf(1))
+	return d
+}
+
+// slack returns how much filament is buffered beyond what the printer needs
+func (d *device) slack() float64 {
+	return d.produced - d.lead - d.consumed
+}
+
+func (d *device) isSplicing() bool {
+	return d.spliceTimeLeft > 0
+}
+
+// nextSpliceTarget returns the production length at which to make the next splice,
+// using the current calibration
+func (d *device) nextSpliceTarget() float64 {
+	length := float64(d.splices[d.nextSplice].Length)
+	return d.lastPingFilament + (length-d.lastPingLength)*d.ratio + d.lead
+}
+
+// run advances Palette by duration seconds
+func (d *device) run(duration float64) {
+	for duration > 0 {
+		if d.isSplicing() {
+			if duration < d.spliceTimeLeft {
+				d.spliceTimeLeft -= duration
+				return
+			}
+			duration -= d.spliceTimeLeft
+			d.spliceTimeLeft = 0
+			continue
+		}
+		room := float64(d.options.BufferCapacity) - d.slack()
+		if room <= 0 {
+			return
+		}
+		produce := room
+		splicing := false
+		if d.nextSplice < len(d.splices) {
+			if toSplice := d.nextSpliceTarget() - d.produced; toSplice <= produce {
+				produce = toSplice
+				splicing = true
+			}
+		}
+		if maxProduce := duration * float64(d.options.FeedRate); maxProduce < produce {
+			d.produced += maxProduce
+			return
+		}
+		if produce > 0 {
+			d.produced += produce
+			duration -= produce / float64(d.options.FeedRate)
+		}
+		if !splicing {
+			// the buffer is full
+			return
+		}
+		d.spliceFilaments = append(d.spliceFilaments, d.produced-d.lead)
+		d.nextSplice++
+		d.spliceTimeLeft = float64(d.options.SpliceTime)
+	}
+}
+
+// consume takes filament from the buffer, returning false if there wasn't enough
+func (d *device) consume(filament float64) bool {
+	d.consumed += filament
+	return d.slack() >= 0
+}
+
+// ping calibrates Palette against the printer, which has consumed the filament
+// for length mm of G-code extrusion
+func (d *device) ping(length float64) {
+	if length > d.lastPingLength {
+		d.ratio = (d.consumed - d.lastPingFilament) / (length - d.lastPingLength)
+	}
+	d.lastPingLength = length
+	d.lastPingFilament = d.consumed
+}
+
+// finish makes any splices that were still to come at the end of the print
+func (d *device) finish() {
+	for ; d.nextSplice < len(d.splices); d.nextSplice++ {
+		d.spliceFilaments = append(d.spliceFilaments, d.nextSpliceTarget()-d.lead)
+	}
+}
@@ -0,0 +1,263 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+	"mosaicmfg.com/ps-postprocess/msf"
+)
+
+type FailureKind string
+
+const (
+	FailurePingTooClose       FailureKind = "ping-too-close"       // pings closer than Palette can measure
+	FailurePingInTransition   FailureKind = "ping-in-transition"   // a ping measures filament across a splice
+	FailurePingMismatch       FailureKind = "ping-mismatch"        // a ping's length doesn't match the G-code's extrusion
+	FailureMissingClearBuffer FailureKind = "missing-clear-buffer" // a connected ping isn't preceded by the clear buffer command
+	FailureMissingHeader      FailureKind = "missing-header"       // a .mcf.gcode file doesn't start multicolor mode and pause before printing
+	FailureBufferUnderrun     FailureKind = "buffer-underrun"      // the printer needs filament faster than Palette makes it
+	FailurePlacementError     FailureKind = "placement-error"      // a splice is predicted to reach the nozzle too far from its target
+)
+
+// Options describe the simulated Palette and printer
+type Options struct {
+	ExtrusionError    float32 // fraction of extra filament the printer really consumes, e.g. 0.02
+	FeedRate          float32 // mm/s, Palette's filament production speed
+	SpliceTime        float32 // s, to cut and splice each transition
+	BufferCapacity    float32 // mm, of filament Palette can buffer ahead of the printer
+	MaxPlacementError float32 // mm, of extrusion before or after a splice's target
+	PingTolerance     float32 // mm, between a ping's length and the G-code's extrusion
+}
+
+func DefaultOptions() Options {
+	return Options{
+		ExtrusionError:    0.01,
+		FeedRate:          30,
+		SpliceTime:        30,
+		BufferCapacity:    300,
+		MaxPlacementError: 10,
+		PingTolerance:     1,
+	}
+}
+
+type Failure struct {
+	Kind    FailureKind `json:"kind"`
+	Line    int         `json:"line,omitempty"` // 1-indexed, or 0 for the whole print
+	Message string      `json:"message"`
+}
+
+type TransitionResult struct {
+	Splice    int     `json:"splice"`    // 1-indexed
+	Drive     int     `json:"drive"`     // 0-indexed
+	Target    float32 `json:"target"`    // mm of extrusion, where the G-code expects the splice
+	Predicted float32 `json:"predicted"` // mm of extrusion, where the splice is predicted to reach the nozzle
+	Error     float32 `json:"error"`     // mm, positive == late
+}
+
+type Report struct {
+	Pings       int                `json:"pings"`
+	Transitions []TransitionResult `json:"transitions"`
+	Failures    []Failure          `json:"failures"`
+}
+
+func (r *Report) fail(kind FailureKind, lineNumber int, format string, args ...interface{}) {
+	r.Failures = append(r.Failures, Failure{
+		Kind:    kind,
+		Line:    lineNumber + 1,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Simulate runs a processed print through a model of Palette, predicting where each splice
+// will reach the nozzle and collecting anything that would desynchronize Palette and the printer
+func Simulate(readLines gcode.LineReader, msfData *msf.MSF, palette *msf.Palette, options Options) (Report, error) {
+	report := Report{
+		Transitions: make([]TransitionResult, 0),
+		Failures:    make([]Failure, 0),
+	}
+	flavor := palette.GetFlavor()
+	device := newDevice(msfData, palette, options)
+	ratio := 1 + float64(options.ExtrusionError)
+
+	extrusion := gcode.ExtrusionTracker{
+		TotalExtrusion: palette.FirmwarePurge,
+	}
+	position := gcode.PositionTracker{HomePosition: palette.HomePosition}
+	underrun := false
+	connectedPings := 0
+	lastPingStart := float32(math.Inf(-1))
+	accessoryPingStart := float32(-1)
+	lastCommand := ""
+	headerSeen := false
+	pausedForPalette := false
+	mcfFile := false
+	printStarted := false
+
+	ping := func(lineNumber int, start, length float32) {
+		report.Pings++
+		if start-lastPingStart < msf.PingMinSpacing {
+			report.fail(FailurePingTooClose, lineNumber, "ping %d is only %.2f mm after the previous one", report.Pings, start-lastPingStart)
+		}
+		if math.Abs(float64(length-start)) > float64(options.PingTolerance) {
+			report.fail(FailurePingMismatch, lineNumber, "ping %d is at %.2f mm, but the G-code has extruded %.2f mm", report.Pings, length, start)
+		}
+		lastPingStart = start
+		device.ping(float64(length))
+	}
+
+	// checkSplices fails a ping whose measurement spans [start, end] of filament
+	// if a splice passes through in the meantime
+	checkSplices := func(lineNumber, index int, start, end float32) {
+		for _, splice := range device.splices {
+			if splice.Length > start && splice.Length < end {
+				report.fail(FailurePingInTransition, lineNumber, "ping %d measures filament across splice at %.2f mm", index+1, splice.Length)
+				break
+			}
+		}
+	}
+
+	err := readLines(func(line gcode.Command, lineNumber int) error {
+		comment := strings.TrimSpace(line.Comment)
+		if line.Command == "" {
+			if strings.HasPrefix(comment, "Ping") && strings.HasSuffix(comment, "pause 1") {
+				accessoryPingStart = extrusion.TotalExtrusion
+			} else if strings.HasPrefix(comment, "Ping") && strings.HasSuffix(comment, "pause 2") && accessoryPingStart >= 0 {
+				index := report.Pings
+				length := accessoryPingStart
+				if index < len(msfData.PingList) {
+					length = msfData.PingList[index].Length
+				}
+				checkSplices(lineNumber, index, accessoryPingStart, extrusion.TotalExtrusion)
+				ping(lineNumber, accessoryPingStart, length)
+				accessoryPingStart = -1
+			}
+			return nil
+		}
+
+		code := strings.TrimSpace(strings.SplitN(line.Raw, ";", 2)[0])
+		if code == "M0" || code == flavor.Pause() {
+			// the printer waits for Palette to load filament (the MSF2 header
+			// always uses M0, see GetMSF2Header)
+			pausedForPalette = headerSeen
+		}
+		switch line.Command {
+		case "O21":
+			mcfFile = true
+		case "O1":
+			headerSeen = true
+		case "O31":
+			if palette.ClearBufferCommand != "" && lastCommand != strings.TrimSpace(palette.ClearBufferCommand) {
+				report.fail(FailureMissingClearBuffer, lineNumber, "ping %d is not preceded by %s", report.Pings+1, palette.ClearBufferCommand)
+			}
+			length, ok := line.Param("l")
+			if !ok && connectedPings < len(msfData.PingList) {
+				length = msfData.PingList[connectedPings].Length
+			}
+			// pings with an extrusion (E) measure the filament extruded after them,
+			// others are taken at a single point
+			if measured, ok := line.Param("e"); ok {
+				checkSplices(lineNumber, report.Pings, extrusion.TotalExtrusion, extrusion.TotalExtrusion+measured)
+			}
+			connectedPings++
+			ping(lineNumber, extrusion.TotalExtrusion, length)
+		}
+		lastCommand = code
+
+		duration := gcode.EstimateDuration(line, &position, &extrusion, flavor)
+		before := extrusion.TotalExtrusion
+		extrusion.TrackInstruction(line)
+		position.TrackInstruction(line)
+		if extrusion.TotalExtrusion > before && !printStarted {
+			printStarted = true
+			if mcfFile && !headerSeen {
+				report.fail(FailureMissingHeader, lineNumber, "printing starts before multicolor mode (O1)")
+			} else if mcfFile && !pausedForPalette {
+				report.fail(FailureMissingHeader, lineNumber, "printing starts without pausing for Palette (M0)")
+			}
+		}
+
+		device.run(float64(duration))
+		if !device.consume(float64(extrusion.TotalExtrusion-before) * ratio) {
+			if !underrun {
+				report.fail(FailureBufferUnderrun, lineNumber, "Palette's buffer runs out at %.2f mm", extrusion.TotalExtrusion)
+			}
+			underrun = true
+		} else {
+			underrun = false
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	device.finish()
+
+	for i, splice := range device.splices {
+		// the splice reaches the nozzle once the printer has consumed the filament before it
+		predicted := float32(device.spliceFilaments[i] / ratio)
+		result := TransitionResult{
+			Splice:    i + 1,
+			Drive:     splice.Drive,
+			Target:    splice.Length,
+			Predicted: predicted,
+			Error:     predicted - splice.Length,
+		}
+		report.Transitions = append(report.Transitions, result)
+		if math.Abs(float64(result.Error)) > float64(options.MaxPlacementError) {
+			report.fail(FailurePlacementError, -1, "splice %d is predicted to be %.2f mm from its target", i+1, result.Error)
+		}
+	}
+	return report, nil
+}
+
+// Run simulates a processed print on Palette, and prints a JSON report
+// of the predicted splice placement and any failures. If there are failures,
+// it exits with a non-zero status:
+//
+//	simulate <gcodepath> <msfpath> [palettepath]
+func Run(argv []string) {
+	argc := len(argv)
+
+	if argc < 2 {
+		log.Fatalln("expected 2 or 3 command-line arguments")
+	}
+	gcodePath := argv[0] // processed G-code file
+	msfPath := argv[1]   // MSF file (the G-code file itself for .mcf.gcode)
+
+	msfData, err := msf.LoadMSFFromFile(msfPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	palette := msfData.Palette
+	if argc > 2 {
+		loaded, err := msf.LoadPaletteFromFile(argv[2])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		palette = &loaded
+	}
+
+	readLines, err := gcode.OpenLineReader(gcodePath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	report, err := Simulate(readLines, &msfData, palette, DefaultOptions())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	bytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := os.Stdout.Write(append(bytes, '\n')); err != nil {
+		log.Fatalln(err)
+	}
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
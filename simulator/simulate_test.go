@@ -0,0 +1,127 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+
+	"mosaicmfg.com/ps-postprocess/gcode"
+	"mosaicmfg.com/ps-postprocess/msf"
+)
+
+func Test_Simulate(t *testing.T) {
+	palette := msf.Palette{
+		Type:               msf.TypeP3,
+		BowdenTubeLength:   100,
+		ClearBufferCommand: "G4 P0",
+	}
+	msfData := msf.NewMSF(&palette)
+	msfData.SpliceList = []msf.Splice{
+		{Drive: 0, Length: 600},
+		{Drive: 1, Length: 1000},
+	}
+	simulate := func(printContent string) Report {
+		readLines, err := gcode.BufferLines(strings.NewReader(printContent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := Simulate(readLines, &msfData, &palette, DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	// slow enough for Palette to keep up, with one ping before the splice
+	report := simulate(`M83
+G1 X100 E200 F60
+G1 X0 E200
+G4 P0
+O31 L400
+G1 X100 E200
+G1 X0 E200
+G1 X100 E200
+`)
+	if len(report.Failures) > 0 {
+		t.Errorf("expected no failures, got %+v", report.Failures)
+	}
+	if report.Pings != 1 || len(report.Transitions) != 1 {
+		t.Fatalf("expected 1 ping and 1 transition, got %+v", report)
+	}
+	if placement := report.Transitions[0].Error; placement < -0.1 || placement > 0.1 {
+		t.Errorf("expected the calibrated splice to be placed accurately, got %.2f mm", placement)
+	}
+
+	// pings too close together, without the clear buffer command, and printed too fast
+	report = simulate(`M83
+G1 X100 E200 F60
+G1 X0 E200
+O31 L400
+G1 X1 E100 F6000
+O31 L500
+G1 X100 E500
+`)
+	kinds := make(map[FailureKind]int)
+	for _, failure := range report.Failures {
+		kinds[failure.Kind]++
+	}
+	if kinds[FailureMissingClearBuffer] != 2 || kinds[FailurePingTooClose] != 1 || kinds[FailureBufferUnderrun] != 1 {
+		t.Errorf("unexpected failures %+v", report.Failures)
+	}
+
+	// connected ping measuring filament across the splice at 600 mm
+	report = simulate(`M83
+G1 X100 E200 F60
+G1 X0 E190
+G4 P0
+O31 L390
+G1 X100 E200
+G1 X0 E200
+G4 P0
+O31 L790
+G1 X100 E200
+`)
+	if len(report.Failures) != 0 {
+		t.Errorf("expected no failures, got %+v", report.Failures)
+	}
+	report = simulate(`M83
+G1 X100 E200 F60
+G1 X0 E200
+G1 X100 E190
+G4 P0
+O31 L590 E20
+G1 X0 E200
+G1 X100 E200
+`)
+	if len(report.Failures) != 1 || report.Failures[0].Kind != FailurePingInTransition {
+		t.Errorf("expected a ping-in-transition failure, got %+v", report.Failures)
+	}
+}
+
+func Test_SimulateHeader(t *testing.T) {
+	palette := msf.Palette{
+		Type:             msf.TypeP2,
+		BowdenTubeLength: 100,
+	}
+	msfData := msf.NewMSF(&palette)
+	msfData.SpliceList = []msf.Splice{
+		{Drive: 0, Length: 100},
+	}
+	for printContent, expectFailure := range map[string]bool{
+		"O21 D0002\nO1 D001 D00000064\nM0\nM83\nG1 X10 E100 F60\n": false,
+		"O21 D0002\nO1 D001 D00000064\nM83\nG1 X10 E100 F60\n":     true,
+		"O21 D0002\nM83\nG1 X10 E100 F60\n":                        true,
+	} {
+		readLines, err := gcode.BufferLines(strings.NewReader(printContent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := Simulate(readLines, &msfData, &palette, DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := len(report.Failures) > 0 && report.Failures[0].Kind == FailureMissingHeader
+		if failed != expectFailure {
+			t.Errorf("unexpected failures %+v for:\n%s", report.Failures, printContent)
+		}
+	}
+}
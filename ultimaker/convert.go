@@ -41,33 +41,24 @@ func AddHeader(argv []string) {
 		log.Fatalln(err)
 	}
 
-	opts := griffinOpts{
-		firstTemperature:    firstTemperature,
-		firstBedTemperature: firstBedTemperature,
-		materialVolumeUsed:  materialVolumeUsed,
-		nozzleDiameter:      nozzleDiameter,
-		totalPrintTime:      int(totalPrintTime),
-		boundingBox:         boundingBox,
+	opts := HeaderOpts{
+		FirstTemperature:    firstTemperature,
+		FirstBedTemperature: firstBedTemperature,
+		MaterialVolumeUsed:  materialVolumeUsed,
+		NozzleDiameter:      nozzleDiameter,
+		TotalPrintTime:      int(totalPrintTime),
+		BoundingBox:         boundingBox,
 	}
 
-	header := getUltimakerGriffinHeader(opts)
-
-	outfile, err := os.Create(outpath)
+	infile, err := gcode.OpenInput(inpath)
 	if err != nil {
 		log.Fatalln(err)
 	}
-
-	// write header first
-	if _, err := outfile.WriteString(header); err != nil {
-		log.Fatalln(err)
-	}
-
-	// concat entire infile
-	infile, err := os.Open(inpath)
+	outfile, err := gcode.CreateOutput(outpath)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	if _, err := io.Copy(outfile, infile); err != nil {
+	if err := AddHeaderStream(infile, outfile, opts); err != nil {
 		log.Fatalln(err)
 	}
 	if err := infile.Close(); err != nil {
@@ -75,10 +66,26 @@ func AddHeader(argv []string) {
 	}
 
 	// finalize and close temporary file
-	if err := outfile.Sync(); err != nil {
-		log.Fatalln(err)
+	if file, ok := outfile.(*os.File); ok {
+		if err := file.Sync(); err != nil {
+			log.Fatalln(err)
+		}
 	}
 	if err := outfile.Close(); err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// AddHeaderStream writes a Griffin header to output, followed by all G-code from input.
+func AddHeaderStream(input io.Reader, output io.Writer, opts HeaderOpts) error {
+	header := getUltimakerGriffinHeader(opts)
+
+	// write header first
+	if _, err := io.WriteString(output, header); err != nil {
+		return err
+	}
+
+	// concat entire input
+	_, err := io.Copy(output, input)
+	return err
+}
@@ -14,27 +14,28 @@ func getCurrentDateString() string {
 	return now.Format("2006-02-01")
 }
 
-type griffinOpts struct {
-	firstTemperature    float64
-	firstBedTemperature float64
-	materialVolumeUsed  float64
-	nozzleDiameter      float64
-	totalPrintTime      int
-	boundingBox         gcode.BoundingBox
+// HeaderOpts holds the print information written to the Griffin header.
+type HeaderOpts struct {
+	FirstTemperature    float64
+	FirstBedTemperature float64
+	MaterialVolumeUsed  float64
+	NozzleDiameter      float64
+	TotalPrintTime      int
+	BoundingBox         gcode.BoundingBox
 }
 
-func getUltimakerGriffinHeader(opts griffinOpts) string {
+func getUltimakerGriffinHeader(opts HeaderOpts) string {
 	date := getCurrentDateString()
-	initialExtTemp := int(math.Round(opts.firstTemperature))
-	initialBedTemp := int(math.Round(opts.firstBedTemperature))
-	materialVolume := float32(math.Round(opts.materialVolumeUsed))
-	bbox := opts.boundingBox
+	initialExtTemp := int(math.Round(opts.FirstTemperature))
+	initialBedTemp := int(math.Round(opts.FirstBedTemperature))
+	materialVolume := float32(math.Round(opts.MaterialVolumeUsed))
+	bbox := opts.BoundingBox
 	if bbox.Min[0] > bbox.Max[0] || bbox.Min[1] > bbox.Max[1] || bbox.Min[2] > bbox.Max[2] {
 		// safety default
 		bbox.Min = [3]float32{0, 0, 0}
 		bbox.Max = [3]float32{10, 10, 10}
 	}
-	printTime := opts.totalPrintTime
+	printTime := opts.TotalPrintTime
 	if printTime < 10 {
 		printTime = 10
 	}
@@ -49,8 +50,8 @@ func getUltimakerGriffinHeader(opts griffinOpts) string {
 	header += fmt.Sprintf(";EXTRUDER_TRAIN.0.INITIAL_TEMPERATURE:%d%s", initialExtTemp, EOL)
 	header += fmt.Sprintf(";EXTRUDER_TRAIN.0.MATERIAL.VOLUME_USED:%f%s", materialVolume, EOL)
 	header += ";EXTRUDER_TRAIN.0.MATERIAL.GUID:506c9f0d-e3aa-4bd4-b2d2-23e2425b1aa9" + EOL
-	header += fmt.Sprintf(";EXTRUDER_TRAIN.0.NOZZLE.DIAMETER:%.2f%s", opts.nozzleDiameter, EOL)
-	header += fmt.Sprintf(";EXTRUDER_TRAIN.0.NOZZLE.NAME:AA %.2f%s", opts.nozzleDiameter, EOL)
+	header += fmt.Sprintf(";EXTRUDER_TRAIN.0.NOZZLE.DIAMETER:%.2f%s", opts.NozzleDiameter, EOL)
+	header += fmt.Sprintf(";EXTRUDER_TRAIN.0.NOZZLE.NAME:AA %.2f%s", opts.NozzleDiameter, EOL)
 	header += ";BUILD_PLATE.TYPE:glass" + EOL
 	header += fmt.Sprintf(";BUILD_PLATE.INITIAL_TEMPERATURE:%d%s", initialBedTemp, EOL)
 	header += fmt.Sprintf(";PRINT.TIME:%d%s", printTime, EOL)
@@ -2,14 +2,12 @@ package zeros
 
 import (
 	"bufio"
+	"io"
 	"log"
 	"mosaicmfg.com/ps-postprocess/gcode"
-	"os"
-	"regexp"
+	"strings"
 )
 
-var reParamWithNoLeadingZero = regexp.MustCompile("( [XYZEF]-?)\\.([0-9]+)")
-
 const EOL = "\r\n"
 
 func RestoreLeadingZeros(argv []string) {
@@ -21,44 +19,65 @@ func RestoreLeadingZeros(argv []string) {
 	inpath := argv[0]
 	outpath := argv[1]
 
-	outfile, createErr := os.Create(outpath)
+	infile, openErr := gcode.OpenInput(inpath)
+	if openErr != nil {
+		log.Fatalln(openErr)
+	}
+	outfile, createErr := gcode.CreateOutput(outpath)
 	if createErr != nil {
 		log.Fatalln(createErr)
 	}
-	writer := bufio.NewWriter(outfile)
+	if err := RestoreLeadingZerosStream(infile, outfile); err != nil {
+		log.Fatalln(err)
+	}
+	if err := infile.Close(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := outfile.Close(); err != nil {
+		log.Fatalln(err)
+	}
+}
 
-	err := gcode.ReadByLine(inpath, func(command gcode.Command, _ int) error {
+// RestoreLeadingZerosStream copies G-code from input to output, adding a leading
+// zero to parameter values written without one (e.g. X.5 -> X0.5).
+func RestoreLeadingZerosStream(input io.Reader, output io.Writer) error {
+	writer := bufio.NewWriter(output)
+	err := gcode.ReadArgsByLineFrom(input, func(command gcode.Command, _ int) error {
 		// ignore non-command lines
 		if len(command.Raw) == 0 || len(command.Command) == 0 {
 			_, err := writer.WriteString(command.Raw + EOL)
 			return err
 		}
 
-		// ignore commands that include arbitary text content
-		if command.Command == "M70" || command.Command == "M118" {
-			_, err := writer.WriteString(command.Raw + EOL)
-			return err
+		// only parameters are rewritten -- comments and free-text arguments
+		// (e.g. M117 messages) are copied as-is
+		var result strings.Builder
+		lexer := gcode.NewLexer(command.Raw)
+		for token, ok := lexer.Next(); ok; token, ok = lexer.Next() {
+			if token.Kind == gcode.TokenParam && needsLeadingZero(token.Text) {
+				dot := strings.IndexByte(token.Text, '.')
+				result.WriteString(token.Text[:dot] + "0" + token.Text[dot:])
+			} else {
+				result.WriteString(token.Text)
+			}
 		}
-
-		// transform raw command output where needed
-		result := replaceAllStringSubmatchFunc(
-			reParamWithNoLeadingZero,
-			command.Raw,
-			func(groups []string) string {
-				return groups[1] + "0." + groups[2]
-			},
-		)
-		_, err := writer.WriteString(result + EOL)
+		_, err := writer.WriteString(result.String() + EOL)
 		return err
 	})
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
+	return writer.Flush()
+}
 
-	if err = writer.Flush(); err != nil {
-		log.Fatalln(err)
+// needsLeadingZero returns true for X, Y, Z, E and F parameters written like X.5 or X-.5
+func needsLeadingZero(param string) bool {
+	if len(param) < 3 || strings.IndexByte("XYZEF", param[0]) < 0 {
+		return false
 	}
-	if err = outfile.Close(); err != nil {
-		log.Fatalln(err)
+	value := param[1:]
+	if value[0] == '-' {
+		value = value[1:]
 	}
+	return len(value) > 1 && value[0] == '.' && value[1] >= '0' && value[1] <= '9'
 }